@@ -0,0 +1,174 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/secnex/reverse-proxy/config"
+)
+
+const (
+	FormatCommon   = "common"
+	FormatCombined = "combined"
+	FormatJSON     = "json"
+	FormatLogfmt   = "logfmt"
+
+	OutputStdout = "stdout"
+	OutputFile   = "file"
+	OutputSyslog = "syslog"
+)
+
+type Entry struct {
+	Time       time.Time     `json:"time"`
+	ClientIP   string        `json:"client_ip"`
+	Host       string        `json:"host"`
+	Method     string        `json:"method"`
+	Path       string        `json:"path"`
+	Proto      string        `json:"proto"`
+	Status     int           `json:"status"`
+	Bytes      int64         `json:"bytes"`
+	Duration   time.Duration `json:"-"`
+	Upstream   string        `json:"upstream,omitempty"`
+	TLSVersion string        `json:"tls_version,omitempty"`
+	Referer    string        `json:"referer,omitempty"`
+	UserAgent  string        `json:"user_agent,omitempty"`
+	RequestID  string        `json:"request_id,omitempty"`
+}
+
+type Logger struct {
+	format string
+	out    io.WriteCloser
+	mu     sync.Mutex
+}
+
+func NewLogger(cfg config.AccessLogConfig) (*Logger, error) {
+	var out io.WriteCloser
+	switch cfg.Output {
+	case OutputStdout, "":
+		out = nopCloser{os.Stdout}
+	case OutputFile:
+		file, err := NewRotatingFile(cfg.File, int64(cfg.MaxSizeMB)*1024*1024, cfg.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		out = file
+	case OutputSyslog:
+		writer, err := newSyslogWriter(cfg.SyslogNetwork, cfg.SyslogAddress)
+		if err != nil {
+			return nil, err
+		}
+		out = writer
+	default:
+		return nil, fmt.Errorf("unknown access log output %q", cfg.Output)
+	}
+
+	return &Logger{format: cfg.Format, out: out}, nil
+}
+
+func (l *Logger) Log(entry Entry) {
+	line := Format(l.format, entry)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(line)
+}
+
+func (l *Logger) Close() error {
+	return l.out.Close()
+}
+
+// Format renders the entry as a single line terminated by a newline. The
+// common and combined formats follow the Apache definitions exactly, so log
+// parsers keep working; the other fields are only part of JSON and logfmt.
+func Format(format string, entry Entry) []byte {
+	switch format {
+	case FormatCommon:
+		return []byte(common(entry) + "\n")
+	case FormatJSON:
+		data, _ := json.Marshal(struct {
+			Entry
+			DurationMS float64 `json:"duration_ms"`
+		}{
+			Entry:      entry,
+			DurationMS: float64(entry.Duration.Microseconds()) / 1000,
+		})
+		return append(data, '\n')
+	case FormatLogfmt:
+		return []byte(logfmt(entry) + "\n")
+	default:
+		return []byte(common(entry) + " " + strconv.Quote(dash(entry.Referer)) + " " + strconv.Quote(dash(entry.UserAgent)) + "\n")
+	}
+}
+
+func common(entry Entry) string {
+	bytes := "-"
+	if entry.Bytes > 0 {
+		bytes = strconv.FormatInt(entry.Bytes, 10)
+	}
+	return fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %s",
+		dash(entry.ClientIP),
+		entry.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		entry.Method, entry.Path, entry.Proto,
+		entry.Status, bytes)
+}
+
+func logfmt(entry Entry) string {
+	pairs := []struct {
+		key   string
+		value string
+	}{
+		{"time", entry.Time.Format(time.RFC3339)},
+		{"client_ip", entry.ClientIP},
+		{"host", entry.Host},
+		{"method", entry.Method},
+		{"path", entry.Path},
+		{"proto", entry.Proto},
+		{"status", strconv.Itoa(entry.Status)},
+		{"bytes", strconv.FormatInt(entry.Bytes, 10)},
+		{"duration_ms", strconv.FormatFloat(float64(entry.Duration.Microseconds())/1000, 'f', 3, 64)},
+		{"upstream", entry.Upstream},
+		{"tls_version", entry.TLSVersion},
+		{"referer", entry.Referer},
+		{"user_agent", entry.UserAgent},
+		{"request_id", entry.RequestID},
+	}
+
+	var b strings.Builder
+	for _, pair := range pairs {
+		if pair.value == "" {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(pair.key)
+		b.WriteByte('=')
+		if strings.ContainsAny(pair.value, " \"=\\") || !strconv.CanBackquote(pair.value) {
+			b.WriteString(strconv.Quote(pair.value))
+		} else {
+			b.WriteString(pair.value)
+		}
+	}
+	return b.String()
+}
+
+func dash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
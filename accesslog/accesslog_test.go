@@ -0,0 +1,89 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestFormat(t *testing.T) {
+	entry := Entry{
+		Time:      time.Date(2025, time.March, 4, 13, 5, 9, 0, time.FixedZone("", 3600)),
+		ClientIP:  "203.0.113.7",
+		Host:      "example.com",
+		Method:    "GET",
+		Path:      "/index.html?q=1",
+		Proto:     "HTTP/1.1",
+		Status:    200,
+		Bytes:     512,
+		Duration:  1500 * time.Microsecond,
+		Upstream:  "http://10.0.0.2:8080",
+		Referer:   "https://example.org/",
+		UserAgent: `curl/8.0 "test"`,
+		RequestID: "abc123",
+	}
+
+	tests := []struct {
+		name   string
+		format string
+		entry  Entry
+		want   string
+	}{
+		{
+			"common",
+			FormatCommon,
+			entry,
+			`203.0.113.7 - - [04/Mar/2025:13:05:09 +0100] "GET /index.html?q=1 HTTP/1.1" 200 512` + "\n",
+		},
+		{
+			"common without bytes",
+			FormatCommon,
+			Entry{Time: entry.Time, Method: "HEAD", Path: "/", Proto: "HTTP/2.0", Status: 304},
+			`- - - [04/Mar/2025:13:05:09 +0100] "HEAD / HTTP/2.0" 304 -` + "\n",
+		},
+		{
+			"combined",
+			FormatCombined,
+			entry,
+			`203.0.113.7 - - [04/Mar/2025:13:05:09 +0100] "GET /index.html?q=1 HTTP/1.1" 200 512 "https://example.org/" "curl/8.0 \"test\""` + "\n",
+		},
+		{
+			"combined without referer and user agent",
+			FormatCombined,
+			Entry{Time: entry.Time, ClientIP: "::1", Method: "GET", Path: "/", Proto: "HTTP/1.1", Status: 404, Bytes: 9},
+			`::1 - - [04/Mar/2025:13:05:09 +0100] "GET / HTTP/1.1" 404 9 "-" "-"` + "\n",
+		},
+		{
+			"logfmt",
+			FormatLogfmt,
+			entry,
+			`time=2025-03-04T13:05:09+01:00 client_ip=203.0.113.7 host=example.com method=GET path="/index.html?q=1" proto=HTTP/1.1 status=200 bytes=512 duration_ms=1.500 upstream=http://10.0.0.2:8080 referer=https://example.org/ user_agent="curl/8.0 \"test\"" request_id=abc123` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(Format(tt.format, tt.entry)); got != tt.want {
+				t.Errorf("Format() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatJSON(t *testing.T) {
+	line := Format(FormatJSON, Entry{Method: "GET", Path: "/", Status: 200, Duration: 2 * time.Millisecond, RequestID: "abc123"})
+	if line[len(line)-1] != '\n' {
+		t.Errorf("line %q is not terminated by a newline", line)
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal(line, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if fields["request_id"] != "abc123" || fields["duration_ms"] != 2.0 || fields["status"] != 200.0 {
+		t.Errorf("fields = %v", fields)
+	}
+	if _, exists := fields["upstream"]; exists {
+		t.Errorf("empty upstream is logged: %v", fields)
+	}
+}
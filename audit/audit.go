@@ -0,0 +1,77 @@
+package audit
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/secnex/reverse-proxy/models"
+)
+
+const (
+	ActionCreate     = "create"
+	ActionUpdate     = "update"
+	ActionDelete     = "delete"
+	ActionActivate   = "activate"
+	ActionDeactivate = "deactivate"
+	ActionIssue      = "issue"
+	ActionRenew      = "renew"
+	ActionPurge      = "purge"
+	ActionImport     = "import"
+
+	ResourceWebsite       = "website"
+	ResourceCertificate   = "certificate"
+	ResourceBasicAuthUser = "basic_auth_user"
+	ResourceHeaderRule    = "header_rule"
+	ResourceRedirectRule  = "redirect_rule"
+	ResourceErrorTemplate = "error_template"
+	ResourceMaintenance   = "maintenance_window"
+	ResourceCache         = "cache"
+
+	// System is the actor for changes that are not triggered by an API token.
+	System = "system"
+)
+
+type Recorder interface {
+	Record(entry models.AuditEntry) error
+}
+
+// NewEntry builds an audit entry with before and after encoded as JSON. A nil
+// state is stored as NULL, e.g. the before state of a created resource.
+func NewEntry(actor string, action string, resourceType string, resourceID string, before interface{}, after interface{}) models.AuditEntry {
+	if actor == "" {
+		actor = System
+	}
+	return models.AuditEntry{
+		CreatedAt:    time.Now(),
+		Actor:        actor,
+		Action:       action,
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		Before:       encode(before),
+		After:        encode(after),
+	}
+}
+
+// Record stores the entry and only logs failures, so a broken audit sink never
+// blocks the change itself.
+func Record(recorder Recorder, entry models.AuditEntry) {
+	if recorder == nil {
+		return
+	}
+	if err := recorder.Record(entry); err != nil {
+		log.Printf("Error recording audit entry for %s %s: %v", entry.ResourceType, entry.ResourceID, err)
+	}
+}
+
+func encode(state interface{}) json.RawMessage {
+	if state == nil {
+		return nil
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		log.Printf("Error encoding audit state: %v", err)
+		return nil
+	}
+	return data
+}
@@ -0,0 +1,65 @@
+package audit
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/secnex/reverse-proxy/models"
+)
+
+type fakeRecorder struct {
+	entries []models.AuditEntry
+	err     error
+}
+
+func (r *fakeRecorder) Record(entry models.AuditEntry) error {
+	r.entries = append(r.entries, entry)
+	return r.err
+}
+
+func TestNewEntry(t *testing.T) {
+	entry := NewEntry("", ActionCreate, ResourceWebsite, "a.example", nil, map[string]string{"domain": "a.example"})
+	if entry.Actor != System {
+		t.Errorf("Actor = %q, want %q", entry.Actor, System)
+	}
+	if entry.Before != nil {
+		t.Errorf("Before = %s, want NULL", entry.Before)
+	}
+	if string(entry.After) != `{"domain":"a.example"}` {
+		t.Errorf("After = %s", entry.After)
+	}
+	if entry.CreatedAt.IsZero() {
+		t.Error("CreatedAt not set")
+	}
+
+	// A state that cannot be encoded is stored as NULL.
+	if entry := NewEntry("admin", ActionUpdate, ResourceWebsite, "a.example", func() {}, nil); entry.Before != nil || entry.Actor != "admin" {
+		t.Errorf("entry = %+v", entry)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+
+	entry := NewEntry("admin", ActionPurge, ResourceCache, "a.example", nil, nil)
+
+	recorder := &fakeRecorder{}
+	Record(recorder, entry)
+	if len(recorder.entries) != 1 || recorder.entries[0].Action != ActionPurge {
+		t.Errorf("entries = %+v", recorder.entries)
+	}
+
+	Record(&fakeRecorder{err: errors.New("database down")}, entry)
+	if !strings.Contains(output.String(), "Error recording audit entry for cache a.example: database down") {
+		t.Errorf("log = %q", output.String())
+	}
+
+	// Without a recorder nothing happens.
+	Record(nil, entry)
+}
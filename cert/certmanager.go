@@ -2,10 +2,16 @@ package cert
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"log"
+	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/secnex/reverse-proxy/audit"
 	"github.com/secnex/reverse-proxy/cert/provider"
 	"github.com/secnex/reverse-proxy/cert/provider/acme"
 	"github.com/secnex/reverse-proxy/cert/provider/self"
@@ -14,6 +20,15 @@ import (
 type CertManager struct {
 	certDir   string
 	providers map[string]provider.CertificateProvider
+	recorder  audit.Recorder
+}
+
+type certificateState struct {
+	Host      string    `json:"host"`
+	Provider  string    `json:"provider"`
+	Serial    string    `json:"serial"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
 }
 
 func NewCertManager(certDir string) *CertManager {
@@ -28,22 +43,65 @@ func NewCertManager(certDir string) *CertManager {
 	return cm
 }
 
+func (cm *CertManager) SetAuditRecorder(recorder audit.Recorder) {
+	cm.recorder = recorder
+}
+
 func (cm *CertManager) GetCertificate(host string, providerType string, email string) (*tls.Certificate, error) {
+	if err := checkHost(host); err != nil {
+		return nil, err
+	}
 	provider, exists := cm.providers[providerType]
 	if !exists {
 		return nil, fmt.Errorf("provider %s not found", providerType)
 	}
 
-	return provider.GetCertificate(host, email)
+	existed := provider.ValidateCertificate(host)
+	cert, err := provider.GetCertificate(host, email)
+	if err != nil {
+		return nil, err
+	}
+
+	if !existed {
+		audit.Record(cm.recorder, audit.NewEntry(audit.System, audit.ActionIssue, audit.ResourceCertificate, host, nil, newCertificateState(host, providerType, cert)))
+	}
+	return cert, nil
 }
 
-func (cm *CertManager) RenewCertificate(host string, providerType string, email string) error {
+func (cm *CertManager) RenewCertificate(actor string, host string, providerType string, email string) error {
+	if err := checkHost(host); err != nil {
+		return err
+	}
 	provider, exists := cm.providers[providerType]
 	if !exists {
 		return fmt.Errorf("provider %s not found", providerType)
 	}
 
-	return provider.RenewCertificate(host, email)
+	var before interface{}
+	if provider.ValidateCertificate(host) {
+		if cert, err := provider.GetCertificate(host, email); err == nil {
+			before = newCertificateState(host, providerType, cert)
+		}
+	}
+
+	if err := provider.RenewCertificate(host, email); err != nil {
+		return err
+	}
+
+	var after interface{}
+	if cert, err := provider.GetCertificate(host, email); err == nil {
+		after = newCertificateState(host, providerType, cert)
+	}
+	audit.Record(cm.recorder, audit.NewEntry(actor, audit.ActionRenew, audit.ResourceCertificate, host, before, after))
+	return nil
+}
+
+// checkHost rejects hosts that cannot be used as certificate file names.
+func checkHost(host string) error {
+	if host == "" || host == "." || host == ".." || strings.ContainsAny(host, `/\`) {
+		return fmt.Errorf("invalid host %q", host)
+	}
+	return nil
 }
 
 func (cm *CertManager) ValidateCertificate(host string, providerType string) bool {
@@ -77,3 +135,70 @@ func (cm *CertManager) LoadCert(certFile, keyFile string) (*tls.Certificate, err
 	log.Printf("Certificate loaded successfully for %s!", certFile)
 	return &cert, nil
 }
+
+func newCertificateState(host string, providerType string, cert *tls.Certificate) *certificateState {
+	state := &certificateState{Host: host, Provider: providerType}
+	if cert == nil || len(cert.Certificate) == 0 {
+		return state
+	}
+	if x509Cert, err := x509.ParseCertificate(cert.Certificate[0]); err == nil {
+		state.Serial = x509Cert.SerialNumber.String()
+		state.NotBefore = x509Cert.NotBefore
+		state.NotAfter = x509Cert.NotAfter
+	}
+	return state
+}
+
+type CertificateInfo struct {
+	Host     string
+	Provider string
+	NotAfter time.Time
+}
+
+// Certificates lists the certificates stored by all providers.
+func (cm *CertManager) Certificates() []CertificateInfo {
+	var infos []CertificateInfo
+	for providerType := range cm.providers {
+		dir := filepath.Join(cm.certDir, providerType)
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || name == "acme_account+key" || strings.HasSuffix(name, ".key") {
+				continue
+			}
+			notAfter, ok := readNotAfter(filepath.Join(dir, name))
+			if !ok {
+				continue
+			}
+			host := strings.TrimSuffix(strings.TrimSuffix(name, ".crt"), "+rsa")
+			infos = append(infos, CertificateInfo{Host: host, Provider: providerType, NotAfter: notAfter})
+		}
+	}
+	return infos
+}
+
+func readNotAfter(path string) (time.Time, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return time.Time{}, false
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return time.Time{}, false
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		x509Cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return x509Cert.NotAfter, true
+	}
+}
@@ -0,0 +1,29 @@
+package cert
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRenewCertificateRejectsPathTraversal(t *testing.T) {
+	dir := t.TempDir()
+	certDir := filepath.Join(dir, "certs")
+	cm := NewCertManager(certDir)
+
+	for _, host := range []string{"../../x", `..\x`, "..", ""} {
+		if err := cm.RenewCertificate("admin", host, "self", ""); err == nil {
+			t.Errorf("RenewCertificate(%q) succeeded", host)
+		}
+		if _, err := cm.GetCertificate(host, "self", ""); err == nil {
+			t.Errorf("GetCertificate(%q) succeeded", host)
+		}
+	}
+
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if entry.Name() != "certs" {
+			t.Errorf("file written outside the certificate directory: %s", entry.Name())
+		}
+	}
+}
@@ -0,0 +1,648 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	HTTP    []Listener `json:"http"`
+	HTTPS   []Listener `json:"https"`
+	API     APIConfig  `json:"api"`
+	IPv6    bool       `json:"ipv6"`
+	CertDir string     `json:"cert_dir"`
+	WWWDir  string     `json:"www_dir"`
+	// StaticDir contains the roots of all static websites.
+	StaticDir      string          `json:"static_dir"`
+	Database       DatabaseConfig  `json:"database"`
+	Limits         Limits          `json:"limits"`
+	AccessLog      AccessLogConfig `json:"access_log"`
+	Tracing        TracingConfig   `json:"tracing"`
+	Cache          CacheConfig     `json:"cache"`
+	AffinitySecret string          `json:"affinity_secret"`
+	TrustedProxies []string        `json:"trusted_proxies"`
+	GeoIPDatabase  string          `json:"geoip_database"`
+	SessionSecret  string          `json:"session_secret"`
+}
+
+type Listener struct {
+	Address  string `json:"address"`
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+	// HTTP3 additionally serves HTTP/3 over QUIC on the UDP port of the same
+	// address. Only HTTPS listeners support it.
+	HTTP3 bool `json:"http3,omitempty"`
+}
+
+type APIConfig struct {
+	Address      string   `json:"address"`
+	CertFile     string   `json:"cert_file,omitempty"`
+	KeyFile      string   `json:"key_file,omitempty"`
+	ClientCAFile string   `json:"client_ca_file,omitempty"`
+	CORSOrigins  []string `json:"cors_origins,omitempty"`
+}
+
+type DatabaseConfig struct {
+	Host     string `json:"host"`
+	Port     string `json:"port"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	Name     string `json:"name"`
+	SSLMode  string `json:"sslmode"`
+	Reset    bool   `json:"reset"`
+}
+
+type Limits struct {
+	ReadHeaderTimeout Duration `json:"read_header_timeout"`
+	ReadTimeout       Duration `json:"read_timeout"`
+	WriteTimeout      Duration `json:"write_timeout"`
+	IdleTimeout       Duration `json:"idle_timeout"`
+	UpstreamTimeout   Duration `json:"upstream_timeout"`
+	MaxHeaderBytes    int      `json:"max_header_bytes"`
+	MaxBodyBytes      int64    `json:"max_body_bytes"`
+}
+
+type AccessLogConfig struct {
+	Enabled       bool   `json:"enabled"`
+	Format        string `json:"format"`
+	Output        string `json:"output"`
+	File          string `json:"file,omitempty"`
+	MaxSizeMB     int    `json:"max_size_mb,omitempty"`
+	MaxBackups    int    `json:"max_backups,omitempty"`
+	SyslogNetwork string `json:"syslog_network,omitempty"`
+	SyslogAddress string `json:"syslog_address,omitempty"`
+}
+
+type TracingConfig struct {
+	Enabled     bool    `json:"enabled"`
+	Exporter    string  `json:"exporter"`
+	Endpoint    string  `json:"endpoint,omitempty"`
+	Insecure    bool    `json:"insecure,omitempty"`
+	SampleRate  float64 `json:"sample_rate"`
+	ServiceName string  `json:"service_name"`
+}
+
+type CacheConfig struct {
+	Storage         string `json:"storage"`
+	Dir             string `json:"dir,omitempty"`
+	MaxSizeMB       int    `json:"max_size_mb"`
+	MaxObjectSizeMB int    `json:"max_object_size_mb"`
+}
+
+// Duration accepts Go duration strings such as "30s" in configuration files.
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\": %v", err)
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	d.Duration = duration
+	return nil
+}
+
+func Default() *Config {
+	return &Config{
+		HTTP:      []Listener{{Address: ":80"}},
+		HTTPS:     []Listener{{Address: ":443"}},
+		API:       APIConfig{Address: ":8081"},
+		IPv6:      true,
+		CertDir:   "certs",
+		WWWDir:    "www",
+		StaticDir: "static",
+		Database: DatabaseConfig{
+			Host:     "localhost",
+			Port:     "5432",
+			User:     "postgres",
+			Password: "postgres",
+			Name:     "secnex",
+			SSLMode:  "disable",
+		},
+		Limits: Limits{
+			ReadHeaderTimeout: Duration{10 * time.Second},
+			ReadTimeout:       Duration{60 * time.Second},
+			WriteTimeout:      Duration{120 * time.Second},
+			IdleTimeout:       Duration{120 * time.Second},
+			UpstreamTimeout:   Duration{60 * time.Second},
+			MaxHeaderBytes:    1 << 20,
+		},
+		AccessLog: AccessLogConfig{
+			Enabled:    true,
+			Format:     "combined",
+			Output:     "stdout",
+			MaxSizeMB:  100,
+			MaxBackups: 5,
+		},
+		Cache: CacheConfig{
+			Storage:         "memory",
+			Dir:             "cache",
+			MaxSizeMB:       256,
+			MaxObjectSizeMB: 10,
+		},
+		Tracing: TracingConfig{
+			Exporter:    "otlp-http",
+			SampleRate:  1,
+			ServiceName: "secnex-reverse-proxy",
+		},
+	}
+}
+
+// Network returns the network used for all listeners. Without IPv6 the
+// listeners only bind IPv4 addresses.
+func (c *Config) Network() string {
+	if c.IPv6 {
+		return "tcp"
+	}
+	return "tcp4"
+}
+
+// HTTPSPort returns the port of the first HTTPS listener, which is used as
+// target for HTTPS redirects.
+func (c *Config) HTTPSPort() string {
+	if len(c.HTTPS) == 0 {
+		return ""
+	}
+	_, port, err := net.SplitHostPort(c.HTTPS[0].Address)
+	if err != nil {
+		return ""
+	}
+	return port
+}
+
+// enableHTTP3 switches HTTP/3 on or off for all HTTPS listeners.
+func (c *Config) enableHTTP3(enabled bool) {
+	for i := range c.HTTPS {
+		c.HTTPS[i].HTTP3 = enabled
+	}
+}
+
+type Options struct {
+	Config      *Config
+	CheckConfig bool
+	CreateToken string
+	TokenScopes []string
+	TokenTTL    time.Duration
+}
+
+// Load builds the configuration from defaults, an optional JSON file,
+// environment variables and command line flags, in that order of precedence.
+func Load(args []string) (*Options, error) {
+	fs := flag.NewFlagSet("reverse-proxy", flag.ContinueOnError)
+
+	configFile := fs.String("config", os.Getenv("PROXY_CONFIG"), "path to a JSON configuration file")
+	checkConfig := fs.Bool("check-config", false, "validate the configuration and exit")
+	createToken := fs.String("create-token", "", "create an API token with the given name and exit")
+	tokenScopes := fs.String("token-scopes", "read-only", "comma-separated scopes of the created API token")
+	tokenTTL := fs.Duration("token-ttl", 0, "lifetime of the created API token, 0 for no expiry")
+	httpAddrs := fs.String("http", "", "comma-separated HTTP listener addresses")
+	httpsAddrs := fs.String("https", "", "comma-separated HTTPS listener addresses")
+	http3 := fs.Bool("http3", false, "also serve HTTP/3 on the HTTPS listeners")
+	apiAddr := fs.String("api", "", "admin API listener address")
+	ipv6 := fs.Bool("ipv6", true, "listen on IPv6 addresses")
+	certDir := fs.String("cert-dir", "", "certificate directory")
+	wwwDir := fs.String("www-dir", "", "directory with pages that replace the embedded ones")
+	staticDir := fs.String("static-dir", "", "directory containing the roots of static websites")
+	geoIPDatabase := fs.String("geoip-db", "", "MaxMind-format GeoIP database for country rules")
+	dbHost := fs.String("db-host", "", "database host")
+	dbPort := fs.String("db-port", "", "database port")
+	dbUser := fs.String("db-user", "", "database user")
+	dbPassword := fs.String("db-password", "", "database password")
+	dbName := fs.String("db-name", "", "database name")
+	dbSSLMode := fs.String("db-sslmode", "", "database SSL mode")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := Default()
+	if *configFile != "" {
+		if err := cfg.loadFile(*configFile); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	http3Set := false
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "http":
+			cfg.HTTP = parseListeners(*httpAddrs)
+		case "https":
+			cfg.HTTPS = parseListeners(*httpsAddrs)
+		case "http3":
+			http3Set = true
+		case "api":
+			cfg.API.Address = *apiAddr
+		case "ipv6":
+			cfg.IPv6 = *ipv6
+		case "cert-dir":
+			cfg.CertDir = *certDir
+		case "www-dir":
+			cfg.WWWDir = *wwwDir
+		case "static-dir":
+			cfg.StaticDir = *staticDir
+		case "geoip-db":
+			cfg.GeoIPDatabase = *geoIPDatabase
+		case "db-host":
+			cfg.Database.Host = *dbHost
+		case "db-port":
+			cfg.Database.Port = *dbPort
+		case "db-user":
+			cfg.Database.User = *dbUser
+		case "db-password":
+			cfg.Database.Password = *dbPassword
+		case "db-name":
+			cfg.Database.Name = *dbName
+		case "db-sslmode":
+			cfg.Database.SSLMode = normalizeSSLMode(*dbSSLMode)
+		}
+	})
+
+	// Applied after the visit, which is in lexical order, so that --http3
+	// covers the listeners of --https.
+	if http3Set {
+		cfg.enableHTTP3(*http3)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &Options{
+		Config:      cfg,
+		CheckConfig: *checkConfig,
+		CreateToken: *createToken,
+		TokenScopes: splitList(*tokenScopes),
+		TokenTTL:    *tokenTTL,
+	}, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %v", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("error parsing config file %s: %v", path, err)
+	}
+	return nil
+}
+
+func (c *Config) applyEnv() error {
+	if value := os.Getenv("PROXY_HTTP_ADDRS"); value != "" {
+		c.HTTP = parseListeners(value)
+	}
+	if value := os.Getenv("PROXY_HTTPS_ADDRS"); value != "" {
+		c.HTTPS = parseListeners(value)
+	}
+	if value := os.Getenv("PROXY_HTTP3"); value != "" {
+		http3, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid PROXY_HTTP3: %v", err)
+		}
+		c.enableHTTP3(http3)
+	}
+	if value := os.Getenv("PROXY_API_ADDR"); value != "" {
+		c.API.Address = value
+	}
+	if value := os.Getenv("PROXY_IPV6"); value != "" {
+		ipv6, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid PROXY_IPV6: %v", err)
+		}
+		c.IPv6 = ipv6
+	}
+	if value := os.Getenv("PROXY_API_CORS_ORIGINS"); value != "" {
+		c.API.CORSOrigins = splitList(value)
+	}
+	if value := os.Getenv("PROXY_CERT_DIR"); value != "" {
+		c.CertDir = value
+	}
+	if value := os.Getenv("PROXY_WWW_DIR"); value != "" {
+		c.WWWDir = value
+	}
+	if value := os.Getenv("PROXY_STATIC_DIR"); value != "" {
+		c.StaticDir = value
+	}
+	if value := os.Getenv("PROXY_GEOIP_DATABASE"); value != "" {
+		c.GeoIPDatabase = value
+	}
+	if value := os.Getenv("PROXY_TRUSTED_PROXIES"); value != "" {
+		c.TrustedProxies = splitList(value)
+	}
+	if value := os.Getenv("PROXY_SESSION_SECRET"); value != "" {
+		c.SessionSecret = value
+	}
+	if value := os.Getenv("AFFINITY_SECRET"); value != "" {
+		c.AffinitySecret = value
+	}
+
+	if value := os.Getenv("PROXY_ACCESS_LOG"); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid PROXY_ACCESS_LOG: %v", err)
+		}
+		c.AccessLog.Enabled = enabled
+	}
+	if value := os.Getenv("PROXY_ACCESS_LOG_FORMAT"); value != "" {
+		c.AccessLog.Format = value
+	}
+	if value := os.Getenv("PROXY_ACCESS_LOG_OUTPUT"); value != "" {
+		c.AccessLog.Output = value
+	}
+	if value := os.Getenv("PROXY_ACCESS_LOG_FILE"); value != "" {
+		c.AccessLog.File = value
+	}
+
+	if value := os.Getenv("PROXY_TRACING"); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid PROXY_TRACING: %v", err)
+		}
+		c.Tracing.Enabled = enabled
+	}
+	if value := os.Getenv("PROXY_TRACING_EXPORTER"); value != "" {
+		c.Tracing.Exporter = value
+	}
+	if value := os.Getenv("PROXY_TRACING_ENDPOINT"); value != "" {
+		c.Tracing.Endpoint = value
+	}
+	if value := os.Getenv("PROXY_TRACING_SAMPLE_RATE"); value != "" {
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid PROXY_TRACING_SAMPLE_RATE: %v", err)
+		}
+		c.Tracing.SampleRate = rate
+	}
+
+	if value := os.Getenv("PROXY_CACHE_STORAGE"); value != "" {
+		c.Cache.Storage = value
+	}
+	if value := os.Getenv("PROXY_CACHE_DIR"); value != "" {
+		c.Cache.Dir = value
+	}
+	if value := os.Getenv("PROXY_CACHE_MAX_SIZE_MB"); value != "" {
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid PROXY_CACHE_MAX_SIZE_MB: %v", err)
+		}
+		c.Cache.MaxSizeMB = number
+	}
+
+	if value := os.Getenv("DB_HOST"); value != "" {
+		c.Database.Host = value
+	}
+	if value := os.Getenv("DB_PORT"); value != "" {
+		c.Database.Port = value
+	}
+	if value := os.Getenv("DB_USER"); value != "" {
+		c.Database.User = value
+	}
+	if value := os.Getenv("DB_PASSWORD"); value != "" {
+		c.Database.Password = value
+	}
+	if value := os.Getenv("DB_NAME"); value != "" {
+		c.Database.Name = value
+	}
+	if value := os.Getenv("DB_SSLMODE"); value != "" {
+		c.Database.SSLMode = normalizeSSLMode(value)
+	}
+	if os.Getenv("DB_RESET") == "true" {
+		c.Database.Reset = true
+	}
+
+	durations := map[string]*Duration{
+		"PROXY_READ_HEADER_TIMEOUT": &c.Limits.ReadHeaderTimeout,
+		"PROXY_READ_TIMEOUT":        &c.Limits.ReadTimeout,
+		"PROXY_WRITE_TIMEOUT":       &c.Limits.WriteTimeout,
+		"PROXY_IDLE_TIMEOUT":        &c.Limits.IdleTimeout,
+		"PROXY_UPSTREAM_TIMEOUT":    &c.Limits.UpstreamTimeout,
+	}
+	for key, target := range durations {
+		value := os.Getenv(key)
+		if value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", key, err)
+		}
+		target.Duration = duration
+	}
+
+	if value := os.Getenv("PROXY_MAX_HEADER_BYTES"); value != "" {
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid PROXY_MAX_HEADER_BYTES: %v", err)
+		}
+		c.Limits.MaxHeaderBytes = number
+	}
+	if value := os.Getenv("PROXY_MAX_BODY_BYTES"); value != "" {
+		number, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid PROXY_MAX_BODY_BYTES: %v", err)
+		}
+		c.Limits.MaxBodyBytes = number
+	}
+
+	return nil
+}
+
+func (c *Config) Validate() error {
+	var errs []error
+
+	if len(c.HTTP) == 0 && len(c.HTTPS) == 0 {
+		errs = append(errs, errors.New("at least one HTTP or HTTPS listener is required"))
+	}
+
+	seen := make(map[string]bool)
+	check := func(kind string, address string) {
+		if err := c.validateAddress(address); err != nil {
+			errs = append(errs, fmt.Errorf("%s listener %q: %v", kind, address, err))
+			return
+		}
+		if seen[address] {
+			errs = append(errs, fmt.Errorf("%s listener %q: address is used more than once", kind, address))
+		}
+		seen[address] = true
+	}
+	for _, listener := range c.HTTP {
+		check("http", listener.Address)
+		if listener.HTTP3 {
+			errs = append(errs, fmt.Errorf("http listener %q: http3 requires an HTTPS listener", listener.Address))
+		}
+	}
+	for _, listener := range c.HTTPS {
+		check("https", listener.Address)
+		if (listener.CertFile == "") != (listener.KeyFile == "") {
+			errs = append(errs, fmt.Errorf("https listener %q: cert_file and key_file must be set together", listener.Address))
+		}
+	}
+	check("api", c.API.Address)
+	if (c.API.CertFile == "") != (c.API.KeyFile == "") {
+		errs = append(errs, errors.New("api cert_file and key_file must be set together"))
+	}
+	if c.API.ClientCAFile != "" && c.API.CertFile == "" {
+		errs = append(errs, errors.New("api client_ca_file requires cert_file and key_file"))
+	}
+
+	for _, entry := range c.TrustedProxies {
+		if _, _, err := net.ParseCIDR(entry); err != nil && net.ParseIP(entry) == nil {
+			errs = append(errs, fmt.Errorf("invalid trusted proxy %q", entry))
+		}
+	}
+
+	if c.GeoIPDatabase != "" {
+		if _, err := os.Stat(c.GeoIPDatabase); err != nil {
+			errs = append(errs, fmt.Errorf("geoip_database: %v", err))
+		}
+	}
+
+	if c.CertDir == "" {
+		errs = append(errs, errors.New("cert_dir must not be empty"))
+	}
+	if c.StaticDir == "" {
+		errs = append(errs, errors.New("static_dir must not be empty"))
+	}
+
+	if c.Database.Host == "" || c.Database.Name == "" || c.Database.User == "" {
+		errs = append(errs, errors.New("database host, name and user must not be empty"))
+	}
+	if port, err := strconv.Atoi(c.Database.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("invalid database port %q", c.Database.Port))
+	}
+	switch c.Database.SSLMode {
+	case "disable", "allow", "prefer", "require", "verify-ca", "verify-full":
+	default:
+		errs = append(errs, fmt.Errorf("invalid database sslmode %q", c.Database.SSLMode))
+	}
+
+	limits := []Duration{c.Limits.ReadHeaderTimeout, c.Limits.ReadTimeout, c.Limits.WriteTimeout, c.Limits.IdleTimeout, c.Limits.UpstreamTimeout}
+	for _, limit := range limits {
+		if limit.Duration < 0 {
+			errs = append(errs, errors.New("timeouts must not be negative"))
+			break
+		}
+	}
+	if c.Limits.MaxHeaderBytes < 0 || c.Limits.MaxBodyBytes < 0 {
+		errs = append(errs, errors.New("size limits must not be negative"))
+	}
+
+	switch c.Cache.Storage {
+	case "memory":
+	case "disk":
+		if c.Cache.Dir == "" {
+			errs = append(errs, errors.New("cache storage disk requires a dir"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("invalid cache storage %q", c.Cache.Storage))
+	}
+	if c.Cache.MaxSizeMB < 0 || c.Cache.MaxObjectSizeMB < 0 {
+		errs = append(errs, errors.New("cache sizes must not be negative"))
+	}
+
+	if c.AccessLog.Enabled {
+		switch c.AccessLog.Format {
+		case "common", "combined", "json", "logfmt":
+		default:
+			errs = append(errs, fmt.Errorf("invalid access log format %q", c.AccessLog.Format))
+		}
+		switch c.AccessLog.Output {
+		case "stdout":
+		case "file":
+			if c.AccessLog.File == "" {
+				errs = append(errs, errors.New("access log output file requires a file path"))
+			}
+		case "syslog":
+		default:
+			errs = append(errs, fmt.Errorf("invalid access log output %q", c.AccessLog.Output))
+		}
+	}
+
+	if c.Tracing.Enabled {
+		switch c.Tracing.Exporter {
+		case "otlp-http", "otlp-grpc", "stdout":
+		default:
+			errs = append(errs, fmt.Errorf("invalid trace exporter %q", c.Tracing.Exporter))
+		}
+		if c.Tracing.SampleRate < 0 || c.Tracing.SampleRate > 1 {
+			errs = append(errs, errors.New("tracing sample_rate must be between 0 and 1"))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c *Config) validateAddress(address string) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	number, err := strconv.Atoi(port)
+	if err != nil || number < 0 || number > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	if host == "" || host == "localhost" {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("bind address %q is not an IP address", host)
+	}
+	if ip.To4() == nil && !c.IPv6 {
+		return errors.New("IPv6 address configured while IPv6 is disabled")
+	}
+	return nil
+}
+
+func parseListeners(value string) []Listener {
+	var listeners []Listener
+	for _, address := range splitList(value) {
+		listeners = append(listeners, Listener{Address: address})
+	}
+	return listeners
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func normalizeSSLMode(mode string) string {
+	switch mode {
+	case "true":
+		return "require"
+	case "false":
+		return "disable"
+	}
+	return mode
+}
@@ -0,0 +1,225 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	file := writeConfigFile(t, `{
+		"http": [{"address": ":8080"}],
+		"api": {"address": "127.0.0.1:9000"},
+		"cert_dir": "file-certs",
+		"www_dir": "file-www",
+		"limits": {"upstream_timeout": "5s"}
+	}`)
+
+	tests := []struct {
+		name  string
+		args  []string
+		env   map[string]string
+		check func(*testing.T, *Config)
+	}{
+		{
+			name: "defaults",
+			check: func(t *testing.T, c *Config) {
+				if len(c.HTTP) != 1 || c.HTTP[0].Address != ":80" || c.API.Address != ":8081" || c.CertDir != "certs" {
+					t.Errorf("defaults not applied: %+v", c)
+				}
+			},
+		},
+		{
+			name: "file over defaults",
+			args: []string{"-config", file},
+			check: func(t *testing.T, c *Config) {
+				if c.HTTP[0].Address != ":8080" || c.API.Address != "127.0.0.1:9000" || c.CertDir != "file-certs" {
+					t.Errorf("file not applied: %+v", c)
+				}
+				if c.Limits.UpstreamTimeout.Duration != 5*time.Second || c.Limits.ReadTimeout.Duration != 60*time.Second {
+					t.Errorf("limits = %+v, want the file value merged into the defaults", c.Limits)
+				}
+				if len(c.HTTPS) != 1 || c.HTTPS[0].Address != ":443" {
+					t.Errorf("HTTPS = %+v, want the default", c.HTTPS)
+				}
+			},
+		},
+		{
+			name: "config file from env",
+			env:  map[string]string{"PROXY_CONFIG": file},
+			check: func(t *testing.T, c *Config) {
+				if c.CertDir != "file-certs" {
+					t.Errorf("CertDir = %q, want the value of the file", c.CertDir)
+				}
+			},
+		},
+		{
+			name: "env over file",
+			args: []string{"-config", file},
+			env:  map[string]string{"PROXY_API_ADDR": "127.0.0.1:9001", "PROXY_CERT_DIR": "env-certs", "PROXY_UPSTREAM_TIMEOUT": "7s"},
+			check: func(t *testing.T, c *Config) {
+				if c.API.Address != "127.0.0.1:9001" || c.CertDir != "env-certs" || c.WWWDir != "file-www" {
+					t.Errorf("env not applied: %+v", c)
+				}
+				if c.Limits.UpstreamTimeout.Duration != 7*time.Second {
+					t.Errorf("UpstreamTimeout = %v", c.Limits.UpstreamTimeout)
+				}
+			},
+		},
+		{
+			name: "flags over env",
+			args: []string{"-config", file, "-cert-dir", "flag-certs", "-http", ":8090, :8091"},
+			env:  map[string]string{"PROXY_CERT_DIR": "env-certs", "PROXY_HTTP_ADDRS": ":8085"},
+			check: func(t *testing.T, c *Config) {
+				if c.CertDir != "flag-certs" {
+					t.Errorf("CertDir = %q, want the flag", c.CertDir)
+				}
+				if len(c.HTTP) != 2 || c.HTTP[0].Address != ":8090" || c.HTTP[1].Address != ":8091" {
+					t.Errorf("HTTP = %+v, want the flag", c.HTTP)
+				}
+			},
+		},
+		{
+			name: "unset flags keep env",
+			args: []string{"-www-dir", "flag-www"},
+			env:  map[string]string{"PROXY_CERT_DIR": "env-certs"},
+			check: func(t *testing.T, c *Config) {
+				if c.CertDir != "env-certs" || c.WWWDir != "flag-www" {
+					t.Errorf("CertDir = %q, WWWDir = %q", c.CertDir, c.WWWDir)
+				}
+			},
+		},
+		{
+			name: "http3 covers flag listeners",
+			args: []string{"-https", ":8443,:9443", "-http3"},
+			check: func(t *testing.T, c *Config) {
+				if len(c.HTTPS) != 2 || !c.HTTPS[0].HTTP3 || !c.HTTPS[1].HTTP3 {
+					t.Errorf("HTTPS = %+v, want HTTP/3 on every listener", c.HTTPS)
+				}
+			},
+		},
+		{
+			name: "sslmode aliases",
+			args: []string{"-db-sslmode", "true"},
+			check: func(t *testing.T, c *Config) {
+				if c.Database.SSLMode != "require" {
+					t.Errorf("SSLMode = %q", c.Database.SSLMode)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			options, err := Load(tt.args)
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			tt.check(t, options.Config)
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want string
+	}{
+		{"unknown field", []string{"-config", writeConfigFile(t, `{"htp": []}`)}, nil, "unknown field"},
+		{"invalid duration", []string{"-config", writeConfigFile(t, `{"limits": {"read_timeout": 30}}`)}, nil, "duration must be a string"},
+		{"missing file", []string{"-config", "missing.json"}, nil, "error reading config file"},
+		{"invalid env", nil, map[string]string{"PROXY_IPV6": "maybe"}, "invalid PROXY_IPV6"},
+		{"invalid env duration", nil, map[string]string{"PROXY_READ_TIMEOUT": "soon"}, "invalid PROXY_READ_TIMEOUT"},
+		{"unknown flag", []string{"-listen", ":80"}, nil, "flag provided but not defined"},
+		{"invalid result", []string{"-cert-dir", ""}, nil, "cert_dir must not be empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			_, err := Load(tt.args)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		want   []string
+	}{
+		{"default", func(c *Config) {}, nil},
+		{"no listeners", func(c *Config) { c.HTTP, c.HTTPS = nil, nil }, []string{"at least one HTTP or HTTPS listener"}},
+		{"duplicate address", func(c *Config) { c.HTTPS = []Listener{{Address: ":80"}} }, []string{`https listener ":80": address is used more than once`}},
+		{"invalid port", func(c *Config) { c.HTTP = []Listener{{Address: ":http"}} }, []string{`invalid port "http"`}},
+		{"host name", func(c *Config) { c.API.Address = "example.com:8081" }, []string{"is not an IP address"}},
+		{"IPv6 disabled", func(c *Config) { c.IPv6 = false; c.API.Address = "[::1]:8081" }, []string{"IPv6 address configured while IPv6 is disabled"}},
+		{"http3 on http", func(c *Config) { c.HTTP[0].HTTP3 = true }, []string{"http3 requires an HTTPS listener"}},
+		{"cert without key", func(c *Config) { c.HTTPS[0].CertFile = "server.crt" }, []string{"cert_file and key_file must be set together"}},
+		{"client CA without TLS", func(c *Config) { c.API.ClientCAFile = "ca.pem" }, []string{"client_ca_file requires cert_file and key_file"}},
+		{"trusted proxy", func(c *Config) { c.TrustedProxies = []string{"10.0.0.0/8", "proxy"} }, []string{`invalid trusted proxy "proxy"`}},
+		{"missing GeoIP database", func(c *Config) { c.GeoIPDatabase = "missing.mmdb" }, []string{"geoip_database"}},
+		{"static dir", func(c *Config) { c.StaticDir = "" }, []string{"static_dir must not be empty"}},
+		{"database port", func(c *Config) { c.Database.Port = "70000" }, []string{`invalid database port "70000"`}},
+		{"sslmode", func(c *Config) { c.Database.SSLMode = "true" }, []string{`invalid database sslmode "true"`}},
+		{"negative timeout", func(c *Config) { c.Limits.IdleTimeout.Duration = -time.Second }, []string{"timeouts must not be negative"}},
+		{"cache storage", func(c *Config) { c.Cache.Storage = "redis" }, []string{`invalid cache storage "redis"`}},
+		{"disk cache without dir", func(c *Config) { c.Cache.Storage, c.Cache.Dir = "disk", "" }, []string{"cache storage disk requires a dir"}},
+		{"access log format", func(c *Config) { c.AccessLog.Format = "xml" }, []string{`invalid access log format "xml"`}},
+		{"disabled access log", func(c *Config) { c.AccessLog.Enabled, c.AccessLog.Format = false, "xml" }, nil},
+		{"access log file", func(c *Config) { c.AccessLog.Output = "file" }, []string{"access log output file requires a file path"}},
+		{"sample rate", func(c *Config) { c.Tracing.Enabled, c.Tracing.SampleRate = true, 2 }, []string{"sample_rate must be between 0 and 1"}},
+		{
+			"all errors reported",
+			func(c *Config) { c.CertDir = ""; c.Database.Port = "x"; c.Cache.Storage = "redis" },
+			[]string{"cert_dir must not be empty", `invalid database port "x"`, `invalid cache storage "redis"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Default()
+			tt.modify(c)
+			err := c.Validate()
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Errorf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Validate() = nil, want %q", tt.want)
+			}
+			lines := strings.Split(err.Error(), "\n")
+			if len(lines) != len(tt.want) {
+				t.Errorf("Validate() = %q, want %d errors", err, len(tt.want))
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Validate() = %q, want %q", err, want)
+				}
+			}
+		})
+	}
+}
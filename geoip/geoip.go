@@ -0,0 +1,54 @@
+package geoip
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Database resolves client IPs to ISO country codes using a local
+// MaxMind-format database such as GeoLite2-Country or GeoLite2-City.
+type Database struct {
+	reader *maxminddb.Reader
+}
+
+type record struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+func Open(path string) (*Database, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening GeoIP database %s: %v", path, err)
+	}
+	return &Database{reader: reader}, nil
+}
+
+// Country returns the upper-case ISO code of the IP, or an empty string if it
+// is unknown.
+func (d *Database) Country(ip string) string {
+	if d == nil {
+		return ""
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+
+	var r record
+	if err := d.reader.Lookup(parsed, &r); err != nil {
+		return ""
+	}
+	return strings.ToUpper(r.Country.ISOCode)
+}
+
+func (d *Database) Close() error {
+	if d == nil {
+		return nil
+	}
+	return d.reader.Close()
+}
@@ -1,47 +1,151 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"time"
 
+	"github.com/secnex/reverse-proxy/accesslog"
 	"github.com/secnex/reverse-proxy/cert"
+	"github.com/secnex/reverse-proxy/config"
+	"github.com/secnex/reverse-proxy/geoip"
+	"github.com/secnex/reverse-proxy/metrics"
+	"github.com/secnex/reverse-proxy/models"
 	"github.com/secnex/reverse-proxy/proxy"
 	"github.com/secnex/reverse-proxy/server"
+	"github.com/secnex/reverse-proxy/templates"
+	"github.com/secnex/reverse-proxy/tracing"
 )
 
 func main() {
-	certDir := "certs"
-	wwwDir := "www"
+	options, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatalf("Error loading configuration: %v", err)
+	}
+	cfg := options.Config
+
+	pages, err := templates.Render(cfg.WWWDir, templates.DefaultBranding())
+	if err != nil {
+		log.Fatalf("Error rendering pages: %v", err)
+	}
+
+	if options.CheckConfig {
+		fmt.Println("Configuration is valid.")
+		return
+	}
 
 	log.Println("Starting reverse proxy...")
 
-	if err := os.MkdirAll(certDir+"/self", 0755); err != nil {
+	if err := os.MkdirAll(filepath.Join(cfg.CertDir, "self"), 0755); err != nil {
 		log.Fatalf("Error creating self-signed certificate directory: %v", err)
 	}
-	if err := os.MkdirAll(certDir+"/acme", 0755); err != nil {
+	if err := os.MkdirAll(filepath.Join(cfg.CertDir, "acme"), 0755); err != nil {
 		log.Fatalf("Error creating ACME certificate directory: %v", err)
 	}
 
-	if err := os.MkdirAll(wwwDir, 0755); err != nil {
-		log.Fatalf("Error creating www directory: %v", err)
-	}
-
-	dbManager, err := proxy.NewDBManager()
+	dbManager, err := proxy.NewDBManager(cfg.Database)
 	if err != nil {
 		log.Fatalf("Error initializing database: %v", err)
 	}
 
-	certManager := cert.NewCertManager(certDir)
+	if options.CreateToken != "" {
+		if err := createToken(dbManager, options); err != nil {
+			log.Fatalf("Error creating API token: %v", err)
+		}
+		return
+	}
+
+	certManager := cert.NewCertManager(cfg.CertDir)
+	certManager.SetAuditRecorder(dbManager)
 	configCache := proxy.NewConfigCache(dbManager, certManager)
-	apiServer := server.NewAPIServer()
-	reverseProxy := proxy.NewReverseProxy(configCache, certManager, apiServer)
+	configCache.SetCountryRulesEnabled(cfg.GeoIPDatabase != "")
+	apiServer := server.NewAPIServer(cfg.API)
+	reverseProxy := proxy.NewReverseProxy(configCache, certManager, apiServer, cfg)
+	reverseProxy.SetPages(pages)
+
+	proxyMetrics := metrics.New()
+	proxyMetrics.RegisterConfigCacheSize(configCache.Len)
+	proxyMetrics.RegisterCertificates(func() []metrics.Certificate {
+		var certificates []metrics.Certificate
+		for _, info := range certManager.Certificates() {
+			certificates = append(certificates, metrics.Certificate{Host: info.Host, Provider: info.Provider, NotAfter: info.NotAfter})
+		}
+		return certificates
+	})
+	configCache.SetMetrics(proxyMetrics)
+	reverseProxy.SetMetrics(proxyMetrics)
+	apiServer.SetMetricsHandler(proxyMetrics.Handler())
 
-	if err := configCache.LoadFromDB(); err != nil {
+	if cfg.Tracing.Enabled {
+		tracer, err := tracing.New(context.Background(), cfg.Tracing, configCache.TraceSampleRate)
+		if err != nil {
+			log.Fatalf("Error initializing tracing: %v", err)
+		}
+		defer tracer.Shutdown(context.Background())
+		reverseProxy.SetTracer(tracer)
+	}
+
+	if cfg.GeoIPDatabase != "" {
+		geoIP, err := geoip.Open(cfg.GeoIPDatabase)
+		if err != nil {
+			log.Fatalf("Error initializing GeoIP: %v", err)
+		}
+		defer geoIP.Close()
+		reverseProxy.SetGeoIP(geoIP)
+	}
+
+	cacheStorage, err := newCacheStorage(cfg.Cache)
+	if err != nil {
+		log.Fatalf("Error initializing cache: %v", err)
+	}
+	responseCache := proxy.NewResponseCache(cacheStorage, int64(cfg.Cache.MaxObjectSizeMB)<<20)
+	reverseProxy.SetCache(responseCache)
+	apiServer.SetCachePurger(responseCache)
+	apiServer.SetStaticDir(cfg.StaticDir)
+	apiServer.SetCountryRulesEnabled(cfg.GeoIPDatabase != "")
+
+	if cfg.AccessLog.Enabled {
+		accessLogger, err := accesslog.NewLogger(cfg.AccessLog)
+		if err != nil {
+			log.Fatalf("Error initializing access log: %v", err)
+		}
+		defer accessLogger.Close()
+		reverseProxy.SetAccessLogger(accessLogger)
+	}
+
+	reload := func() error {
+		if err := configCache.LoadFromDB(); err != nil {
+			return err
+		}
+		for host := range configCache.GetAll() {
+			apiServer.SetDefaultActiveConfig(host)
+		}
+		return nil
+	}
+
+	apiServer.SetTokenStore(dbManager)
+	apiServer.SetWebsiteStore(dbManager)
+	apiServer.SetCertificateManager(certManager)
+	apiServer.SetAuditStore(dbManager)
+	apiServer.SetBasicAuthStore(dbManager)
+	apiServer.SetHeaderRuleStore(dbManager)
+	apiServer.SetRedirectRuleStore(dbManager)
+	apiServer.SetErrorTemplateStore(dbManager)
+	apiServer.SetMaintenanceStore(dbManager)
+	apiServer.SetReloadFunc(reload)
+
+	if err := reload(); err != nil {
 		log.Fatalf("Error loading configurations: %v", err)
 	}
 
+	errs := make(chan error)
+
 	go func() {
-		if err := apiServer.Start(8081); err != nil {
+		log.Printf("Starting API server on %s...", cfg.API.Address)
+		if err := apiServer.Start(cfg.Network()); err != nil {
 			log.Printf("Error starting API server: %v", err)
 		}
 	}()
@@ -55,15 +159,70 @@ func main() {
 	configCache.Set("localserver", localserverConfig)
 	apiServer.SetActiveConfig("localserver", true)
 
-	go func() {
-		log.Println("Starting HTTP server on port 80...")
-		if err := reverseProxy.Start(80, false, "", ""); err != nil {
-			log.Printf("Fehler beim Starten des HTTP-Servers: %v", err)
+	for _, listener := range cfg.HTTP {
+		go func(listener config.Listener) {
+			log.Printf("Starting HTTP server on %s...", listener.Address)
+			if err := reverseProxy.Start(listener, false); err != nil {
+				errs <- fmt.Errorf("error starting HTTP server on %s: %v", listener.Address, err)
+			}
+		}(listener)
+	}
+
+	for _, listener := range cfg.HTTPS {
+		go func(listener config.Listener) {
+			log.Printf("Starting HTTPS server on %s...", listener.Address)
+			if err := reverseProxy.Start(listener, true); err != nil {
+				errs <- fmt.Errorf("error starting HTTPS server on %s: %v", listener.Address, err)
+			}
+		}(listener)
+
+		if listener.HTTP3 {
+			go func(listener config.Listener) {
+				log.Printf("Starting HTTP/3 server on %s...", listener.Address)
+				if err := reverseProxy.StartHTTP3(listener); err != nil {
+					errs <- fmt.Errorf("error starting HTTP/3 server on %s: %v", listener.Address, err)
+				}
+			}(listener)
 		}
-	}()
+	}
+
+	log.Fatal(<-errs)
+}
 
-	log.Println("Starting HTTPS server on port 443...")
-	if err := reverseProxy.Start(443, true, "", ""); err != nil {
-		log.Fatalf("Fehler beim Starten des HTTPS-Servers: %v", err)
+func newCacheStorage(cfg config.CacheConfig) (proxy.CacheStorage, error) {
+	maxBytes := int64(cfg.MaxSizeMB) << 20
+	if cfg.Storage == "disk" {
+		return proxy.NewDiskCacheStorage(cfg.Dir, maxBytes)
 	}
+	return proxy.NewMemoryCacheStorage(maxBytes), nil
+}
+
+func createToken(dbManager *proxy.DBManager, options *config.Options) error {
+	for _, scope := range options.TokenScopes {
+		if !server.ValidScope(scope) {
+			return fmt.Errorf("unknown scope %q, valid scopes are %v", scope, server.Scopes)
+		}
+	}
+
+	token, hash, err := server.GenerateAPIToken()
+	if err != nil {
+		return err
+	}
+
+	apiToken := models.APIToken{
+		Name:      options.CreateToken,
+		TokenHash: hash,
+		Scopes:    options.TokenScopes,
+	}
+	if options.TokenTTL > 0 {
+		expiresAt := time.Now().Add(options.TokenTTL)
+		apiToken.ExpiresAt = &expiresAt
+	}
+
+	if err := dbManager.CreateAPIToken(apiToken); err != nil {
+		return err
+	}
+
+	fmt.Printf("API token %q created with scopes %v:\n%s\n", options.CreateToken, options.TokenScopes, token)
+	return nil
 }
@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type Certificate struct {
+	Host     string
+	Provider string
+	NotAfter time.Time
+}
+
+type certificateCollector struct {
+	desc         *prometheus.Desc
+	certificates func() []Certificate
+}
+
+// RegisterCertificates exports the expiry of all certificates returned by the
+// function, which is evaluated on every scrape.
+func (m *Metrics) RegisterCertificates(certificates func() []Certificate) {
+	m.registry.MustRegister(&certificateCollector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "certificate_expiry_timestamp_seconds"),
+			"Expiry of certificates as Unix timestamp.",
+			[]string{"host", "provider"}, nil,
+		),
+		certificates: certificates,
+	})
+}
+
+func (c *certificateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *certificateCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, cert := range c.certificates() {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(cert.NotAfter.Unix()), cert.Host, cert.Provider)
+	}
+}
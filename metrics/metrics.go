@@ -0,0 +1,154 @@
+package metrics
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "secnex_proxy"
+
+// UnknownSite is used as site label for hosts without configuration, so
+// arbitrary Host headers cannot inflate the number of series.
+const UnknownSite = "unknown"
+
+// Metrics holds all proxy metrics. A nil *Metrics is valid and records nothing.
+type Metrics struct {
+	registry         *prometheus.Registry
+	requests         *prometheus.CounterVec
+	duration         *prometheus.HistogramVec
+	upstreamErrors   *prometheus.CounterVec
+	activeConns      prometheus.Gauge
+	activeWebSockets prometheus.Gauge
+	configReloads    *prometheus.CounterVec
+	reloadDuration   prometheus.Histogram
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "requests_total",
+			Help:      "Proxied requests by site and status class.",
+		}, []string{"site", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "request_duration_seconds",
+			Help:      "Time until the response to a request has been written.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"site"}),
+		upstreamErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "upstream_errors_total",
+			Help:      "Failed requests to upstream targets.",
+		}, []string{"site"}),
+		activeConns: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "active_connections",
+			Help:      "Open client connections on the proxy listeners.",
+		}),
+		activeWebSockets: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "active_websockets",
+			Help:      "Open WebSocket connections.",
+		}),
+		configReloads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "config_reloads_total",
+			Help:      "Configuration reloads by result.",
+		}, []string{"result"}),
+		reloadDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "config_reload_duration_seconds",
+			Help:      "Duration of configuration reloads.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.duration,
+		m.upstreamErrors,
+		m.activeConns,
+		m.activeWebSockets,
+		m.configReloads,
+		m.reloadDuration,
+	)
+	return m
+}
+
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+func (m *Metrics) ObserveRequest(site string, status int, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.requests.WithLabelValues(site, strconv.Itoa(status/100)+"xx").Inc()
+	m.duration.WithLabelValues(site).Observe(duration.Seconds())
+}
+
+func (m *Metrics) UpstreamError(site string) {
+	if m == nil {
+		return
+	}
+	m.upstreamErrors.WithLabelValues(site).Inc()
+}
+
+// ConnState is used as http.Server.ConnState hook to track open connections.
+func (m *Metrics) ConnState(conn net.Conn, state http.ConnState) {
+	if m == nil {
+		return
+	}
+	switch state {
+	case http.StateNew:
+		m.activeConns.Inc()
+	case http.StateHijacked, http.StateClosed:
+		m.activeConns.Dec()
+	}
+}
+
+func (m *Metrics) WebSocketOpened() {
+	if m == nil {
+		return
+	}
+	m.activeWebSockets.Inc()
+}
+
+func (m *Metrics) WebSocketClosed() {
+	if m == nil {
+		return
+	}
+	m.activeWebSockets.Dec()
+}
+
+func (m *Metrics) ObserveReload(duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	m.configReloads.WithLabelValues(result).Inc()
+	m.reloadDuration.Observe(duration.Seconds())
+}
+
+func (m *Metrics) RegisterConfigCacheSize(size func() int) {
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "config_cache_entries",
+		Help:      "Number of websites in the configuration cache.",
+	}, func() float64 {
+		return float64(size())
+	}))
+}
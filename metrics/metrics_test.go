@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+	m.ObserveRequest("a.example", http.StatusOK, time.Second)
+	m.UpstreamError("a.example")
+	m.ConnState(nil, http.StateNew)
+	m.WebSocketOpened()
+	m.WebSocketClosed()
+	m.ObserveReload(time.Second, nil)
+}
+
+func TestObserveRequest(t *testing.T) {
+	m := New()
+	for _, status := range []int{200, 204, 301, 404, 429, 502, 599} {
+		m.ObserveRequest("a.example", status, 10*time.Millisecond)
+	}
+	m.ObserveRequest(UnknownSite, http.StatusMisdirectedRequest, time.Millisecond)
+
+	tests := []struct {
+		site string
+		code string
+		want float64
+	}{
+		{"a.example", "2xx", 2},
+		{"a.example", "3xx", 1},
+		{"a.example", "4xx", 2},
+		{"a.example", "5xx", 2},
+		{UnknownSite, "4xx", 1},
+	}
+	for _, tt := range tests {
+		if got := testutil.ToFloat64(m.requests.WithLabelValues(tt.site, tt.code)); got != tt.want {
+			t.Errorf("requests{site=%q,code=%q} = %v, want %v", tt.site, tt.code, got, tt.want)
+		}
+	}
+	// Only the status classes are used as labels.
+	if got := testutil.CollectAndCount(m.requests); got != len(tests) {
+		t.Errorf("%d request series, want %d", got, len(tests))
+	}
+}
+
+func TestGauges(t *testing.T) {
+	m := New()
+	m.ConnState(nil, http.StateNew)
+	m.ConnState(nil, http.StateNew)
+	m.ConnState(nil, http.StateActive)
+	m.ConnState(nil, http.StateHijacked)
+	if got := testutil.ToFloat64(m.activeConns); got != 1 {
+		t.Errorf("active connections = %v, want 1", got)
+	}
+
+	m.WebSocketOpened()
+	m.WebSocketOpened()
+	m.WebSocketClosed()
+	if got := testutil.ToFloat64(m.activeWebSockets); got != 1 {
+		t.Errorf("active WebSockets = %v, want 1", got)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	m := New()
+	m.ObserveReload(time.Millisecond, nil)
+	m.ObserveReload(time.Millisecond, errors.New("database down"))
+	m.UpstreamError("a.example")
+	m.RegisterConfigCacheSize(func() int { return 3 })
+
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{
+		`secnex_proxy_config_reloads_total{result="success"} 1`,
+		`secnex_proxy_config_reloads_total{result="error"} 1`,
+		`secnex_proxy_upstream_errors_total{site="a.example"} 1`,
+		`secnex_proxy_config_cache_entries 3`,
+		`secnex_proxy_config_reload_duration_seconds_count 2`,
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("metrics output misses %q", want)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type AuditEntry struct {
+	ID           uint            `gorm:"primarykey"`
+	CreatedAt    time.Time       `gorm:"index"`
+	Actor        string          `gorm:"index;not null"`
+	Action       string          `gorm:"index;not null"`
+	ResourceType string          `gorm:"index;not null"`
+	ResourceID   string          `gorm:"index"`
+	Before       json.RawMessage `gorm:"type:jsonb"`
+	After        json.RawMessage `gorm:"type:jsonb"`
+}
+
+type AuditFilter struct {
+	Actor        string
+	Action       string
+	ResourceType string
+	ResourceID   string
+	Since        *time.Time
+	Until        *time.Time
+	Page         int
+	PerPage      int
+}
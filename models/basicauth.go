@@ -0,0 +1,10 @@
+package models
+
+import "gorm.io/gorm"
+
+type BasicAuthUser struct {
+	gorm.Model
+	Domain       string `gorm:"uniqueIndex:idx_basic_auth_users_domain_username;not null"`
+	Username     string `gorm:"uniqueIndex:idx_basic_auth_users_domain_username;not null"`
+	PasswordHash string `gorm:"not null" json:"-"`
+}
@@ -0,0 +1,16 @@
+package models
+
+import "gorm.io/gorm"
+
+// ErrorTemplate replaces the error page of a website for one status code, or
+// for all status codes without their own template if Status is 0.
+type ErrorTemplate struct {
+	gorm.Model
+	Domain string `gorm:"uniqueIndex:idx_error_templates_domain_status_format;not null"`
+	Status int    `gorm:"uniqueIndex:idx_error_templates_domain_status_format"`
+	// Format is "html", "json" or "text", chosen by the Accept header.
+	Format string `gorm:"uniqueIndex:idx_error_templates_domain_status_format;not null"`
+	// Template is a Go template with {{ .Status }}, {{ .Message }},
+	// {{ .Host }} and {{ .RequestID }}. HTML templates escape their values.
+	Template string `gorm:"not null"`
+}
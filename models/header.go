@@ -0,0 +1,20 @@
+package models
+
+import "gorm.io/gorm"
+
+// HeaderRule changes a request header towards the upstream or a response
+// header towards the client. Rules with a PathPrefix only apply to matching
+// request paths.
+type HeaderRule struct {
+	gorm.Model
+	Domain     string `gorm:"index;not null"`
+	PathPrefix string
+	// Direction is "request" or "response".
+	Direction string `gorm:"not null"`
+	// Action is "set", "add" or "remove".
+	Action string `gorm:"not null"`
+	Name   string `gorm:"not null"`
+	// Value may contain the placeholders {client_ip}, {request_id}, {host},
+	// {method}, {path} and {scheme}.
+	Value string
+}
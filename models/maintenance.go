@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MaintenanceWindow answers the requests of a domain with a maintenance page
+// between Start and End. A window without Start begins immediately, one
+// without End lasts until it is removed.
+type MaintenanceWindow struct {
+	gorm.Model
+	Domain  string `gorm:"index;not null"`
+	Start   *time.Time
+	End     *time.Time
+	Message string
+	// RetryAfter is sent in seconds. If it is 0, the time until End is sent.
+	RetryAfter int
+	// BypassIPs are IPs or CIDRs that reach the site during the window.
+	BypassIPs []string `gorm:"column:bypass_ips;serializer:json"`
+	// BypassSecret lets clients with a cookie of this value through. The
+	// cookie is set by visiting /.maintenance/bypass?secret=<secret>. It is
+	// write-only and never returned by the API or written to the audit log.
+	BypassSecret string `json:"-"`
+}
@@ -8,22 +8,147 @@ import (
 
 type Website struct {
 	gorm.Model
-	Domain   string `gorm:"uniqueIndex;not null"`
-	Protocol string `gorm:"not null;default:'http'"`
-	Host     string `gorm:"not null"`
-	Port     int    `gorm:"not null;default:80"`
-	SSL      bool   `gorm:"default:false"`
+	Domain         string   `gorm:"uniqueIndex;not null"`
+	Protocol       string   `gorm:"not null;default:'http'"`
+	Host           string   `gorm:"not null"`
+	Port           int      `gorm:"not null;default:80"`
+	Upstreams      []string `gorm:"serializer:json"`
+	Affinity       string   `gorm:"not null;default:''"`
+	AffinityCookie string
+	// UpstreamTimeout is in seconds, sizes are in bytes. Zero uses the global limit.
+	UpstreamTimeout int
+	MaxBodySize     int64
+	MaxHeaderSize   int
+	SSL             bool `gorm:"default:false"`
+	// HTTPSPolicy is "both" (default), "redirect" or "https-only".
+	HTTPSPolicy           string `gorm:"column:https_policy;not null;default:'both'"`
+	HTTPSRedirectStatus   int    `gorm:"column:https_redirect_status"`
+	HSTSMaxAge            int
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+	AccessLogDisabled     bool
+	// AccessLogSampleRate between 0 and 1, where 0 logs every request.
+	AccessLogSampleRate float64
+	// TraceSampleRate overrides the global trace sample rate when set.
+	TraceSampleRate *float64
+	RateLimits      []RateLimitRule `gorm:"serializer:json"`
+	// Access rules take IPs or CIDRs and ISO country codes. Deny rules win,
+	// if any allow rule is set the client has to match one of them.
+	AllowCIDRs     []string `gorm:"column:allow_cidrs;serializer:json"`
+	DenyCIDRs      []string `gorm:"column:deny_cidrs;serializer:json"`
+	AllowCountries []string `gorm:"serializer:json"`
+	DenyCountries  []string `gorm:"serializer:json"`
+	// AuthMode is "" (none), "basic", "forward" or "oidc". Forward auth sends a
+	// subrequest to ForwardAuthURL and copies ForwardAuthHeaders from its
+	// response into the upstream request.
+	AuthMode           string
+	AuthRealm          string
+	ForwardAuthURL     string
+	ForwardAuthHeaders []string `gorm:"serializer:json"`
+	// OIDC settings for the "oidc" auth mode. Users matching any of the
+	// allow rules are admitted, without rules every user is.
+	OIDCIssuer       string          `gorm:"column:oidc_issuer"`
+	OIDCClientID     string          `gorm:"column:oidc_client_id"`
+	OIDCClientSecret string          `gorm:"column:oidc_client_secret" json:"-"`
+	OIDCScopes       []string        `gorm:"column:oidc_scopes;serializer:json"`
+	OIDCAllowRules   []OIDCAllowRule `gorm:"column:oidc_allow_rules;serializer:json"`
+	// WAFMode is "" (off), "detect" or "block".
+	WAFMode          string
+	WAFDisabledRules []string `gorm:"serializer:json"`
+	// Compression of responses with a type in CompressionTypes (e.g.
+	// "text/*") and at least CompressionMinSize bytes. Zero values use the
+	// defaults, CompressionLevel uses the range of the chosen algorithm.
+	Compression        bool
+	CompressionTypes   []string `gorm:"serializer:json"`
+	CompressionMinSize int
+	CompressionLevel   int
+	// Cache stores responses in the shared response cache according to
+	// their Cache-Control headers. Coalesce lets concurrent misses for the
+	// same URL wait up to CoalesceTimeout seconds for a single upstream fetch,
+	// zero uses the upstream timeout.
+	Cache           bool
+	Coalesce        bool
+	CoalesceTimeout int
+	// Type is "proxy" (default), "static" or "redirect". Static sites serve
+	// the files in StaticRoot instead of forwarding requests upstream.
+	Type               string
+	StaticRoot         string
+	StaticIndexFiles   []string `gorm:"serializer:json"`
+	StaticSPA          bool     `gorm:"column:static_spa"`
+	StaticListing      bool
+	StaticCacheControl string
+	// Redirect sites answer requests that no redirect rule matches with
+	// RedirectStatus (default 301) to RedirectTarget, see RedirectRule.
+	RedirectTarget        string
+	RedirectStatus        int
+	RedirectPreservePath  bool
+	RedirectPreserveQuery bool
+	// InterceptErrors replaces upstream responses with status 400 and above
+	// by the error page of the proxy, see ErrorTemplate.
+	InterceptErrors bool
+	// HTTP3 advertises the HTTP/3 listeners to clients of the site with
+	// Alt-Svc and accepts requests over QUIC.
+	HTTP3    bool   `gorm:"column:http3"`
 	Active   bool   `gorm:"default:true"`
 	Email    string `gorm:"not null"`
 	LastSeen time.Time
 }
 
 type WebsiteConfig struct {
-	Domain   string
-	Protocol string
-	Host     string
-	Port     int
-	SSL      bool
-	Active   bool
-	Email    string
+	Domain                string
+	Protocol              string
+	Host                  string
+	Port                  int
+	Upstreams             []string
+	Affinity              string
+	AffinityCookie        string
+	UpstreamTimeout       int
+	MaxBodySize           int64
+	MaxHeaderSize         int
+	SSL                   bool
+	HTTPSPolicy           string
+	HTTPSRedirectStatus   int
+	HSTSMaxAge            int
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+	AccessLogDisabled     bool
+	AccessLogSampleRate   float64
+	TraceSampleRate       *float64
+	RateLimits            []RateLimitRule
+	AllowCIDRs            []string
+	DenyCIDRs             []string
+	AllowCountries        []string
+	DenyCountries         []string
+	AuthMode              string
+	AuthRealm             string
+	ForwardAuthURL        string
+	ForwardAuthHeaders    []string
+	OIDCIssuer            string
+	OIDCClientID          string
+	OIDCClientSecret      string
+	OIDCScopes            []string
+	OIDCAllowRules        []OIDCAllowRule
+	WAFMode               string
+	WAFDisabledRules      []string
+	Compression           bool
+	CompressionTypes      []string
+	CompressionMinSize    int
+	CompressionLevel      int
+	Cache                 bool
+	Coalesce              bool
+	CoalesceTimeout       int
+	Type                  string
+	StaticRoot            string
+	StaticIndexFiles      []string
+	StaticSPA             bool
+	StaticListing         bool
+	StaticCacheControl    string
+	RedirectTarget        string
+	RedirectStatus        int
+	RedirectPreservePath  bool
+	RedirectPreserveQuery bool
+	InterceptErrors       bool
+	HTTP3                 bool
+	Active                bool
+	Email                 string
 }
@@ -0,0 +1,8 @@
+package models
+
+// OIDCAllowRule admits users whose claim contains one of the values, e.g.
+// {Claim: "groups", Values: ["admins"]}.
+type OIDCAllowRule struct {
+	Claim  string
+	Values []string
+}
@@ -0,0 +1,13 @@
+package models
+
+// RateLimitRule limits requests to a website. Rules with a PathPrefix only
+// apply to matching request paths, an empty prefix applies to every request.
+type RateLimitRule struct {
+	PathPrefix string
+	// Requests per Period seconds, with bursts of up to Burst requests.
+	Requests int
+	Period   int
+	Burst    int
+	// Key is "ip" (default), "header:<name>", "api-key" or "path".
+	Key string
+}
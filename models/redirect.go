@@ -0,0 +1,21 @@
+package models
+
+import "gorm.io/gorm"
+
+// RedirectRule answers requests whose path starts with Source, or matches it
+// as regular expression if Regex is set, with a redirect to Target.
+type RedirectRule struct {
+	gorm.Model
+	Domain string `gorm:"index;not null"`
+	Source string `gorm:"not null"`
+	Regex  bool
+	// Target may contain the placeholders {scheme}, {host}, {path} and
+	// {query}, and $1, ${name} for the captures of a regular expression.
+	Target string `gorm:"not null"`
+	// Status is 301 (default), 302, 307 or 308.
+	Status int
+	// PreservePath appends the rest of the path after the matched part,
+	// PreserveQuery the query string of the request.
+	PreservePath  bool
+	PreserveQuery bool
+}
@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type APIToken struct {
+	gorm.Model
+	Name      string   `gorm:"uniqueIndex;not null"`
+	TokenHash string   `gorm:"uniqueIndex;not null" json:"-"`
+	Scopes    []string `gorm:"serializer:json"`
+	ExpiresAt *time.Time
+	LastUsed  *time.Time
+}
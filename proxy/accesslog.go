@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/secnex/reverse-proxy/accesslog"
+)
+
+func (rp *ReverseProxy) SetAccessLogger(logger *accesslog.Logger) {
+	rp.accessLogger = logger
+}
+
+func (rp *ReverseProxy) accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rp.accessLogger == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		info := getRequestInfo(r)
+		recorder := newResponseRecorder(w)
+		next.ServeHTTP(recorder, r)
+
+		host := hostname(r)
+		if config, exists := rp.configCache.Get(host); exists && !config.sampleAccessLog() {
+			return
+		}
+
+		entry := accesslog.Entry{
+			Time:      start,
+			ClientIP:  info.clientIP,
+			RequestID: info.requestID,
+			Host:      host,
+			Method:    r.Method,
+			Path:      loggedURI(r),
+			Proto:     r.Proto,
+			Status:    recorder.Status(),
+			Bytes:     recorder.bytes,
+			Duration:  time.Since(start),
+			Upstream:  info.upstream,
+			Referer:   r.Referer(),
+			UserAgent: r.UserAgent(),
+		}
+		if r.TLS != nil {
+			entry.TLSVersion = tls.VersionName(r.TLS.Version)
+		}
+		rp.accessLogger.Log(entry)
+	})
+}
+
+// loggedURI returns the request URI for the access log. The query of the
+// maintenance bypass path is dropped, as clients may put the secret there.
+func loggedURI(r *http.Request) string {
+	if r.URL.Path == maintenanceBypassPath {
+		return r.URL.Path
+	}
+	return r.RequestURI
+}
+
+// sampleAccessLog decides whether a request of the site is logged. A sample
+// rate of 0 or 1 logs every request.
+func (c ProxyConfig) sampleAccessLog() bool {
+	if c.AccessLogDisabled {
+		return false
+	}
+	if c.AccessLogSampleRate <= 0 || c.AccessLogSampleRate >= 1 {
+		return true
+	}
+	return rand.Float64() < c.AccessLogSampleRate
+}
@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/secnex/reverse-proxy/geoip"
+)
+
+func (rp *ReverseProxy) SetGeoIP(db *geoip.Database) {
+	rp.geoip = db
+}
+
+// checkAccess evaluates the access rules of the site against the resolved
+// client IP and reports whether the request was rejected.
+func (rp *ReverseProxy) checkAccess(w http.ResponseWriter, r *http.Request, host string, config ProxyConfig) bool {
+	if rp.allowed(getRequestInfo(r).clientIP, config) {
+		return false
+	}
+
+	log.Printf("Access to %s denied for %s", host, getRequestInfo(r).clientIP)
+	rp.serveError(w, r, http.StatusForbidden)
+	return true
+}
+
+// allowed rejects clients matching a deny rule. If allow rules are set, the
+// client has to match at least one of them. Clients whose country is unknown
+// never match a country rule.
+func (rp *ReverseProxy) allowed(clientIP string, config ProxyConfig) bool {
+	hasAllow := len(config.AllowCIDRs) > 0 || len(config.AllowCountries) > 0
+	hasCountryRules := len(config.AllowCountries) > 0 || len(config.DenyCountries) > 0
+	if !hasAllow && len(config.DenyCIDRs) == 0 && !hasCountryRules {
+		return true
+	}
+
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return false
+	}
+
+	var country string
+	if hasCountryRules {
+		country = rp.geoip.Country(clientIP)
+	}
+
+	if containsIP(config.denyNetworks, ip) || matchesCountry(config.DenyCountries, country) {
+		return false
+	}
+	if !hasAllow {
+		return true
+	}
+	return containsIP(config.allowNetworks, ip) || matchesCountry(config.AllowCountries, country)
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesCountry(countries []string, country string) bool {
+	if country == "" {
+		return false
+	}
+	return slices.ContainsFunc(countries, func(c string) bool {
+		return strings.EqualFold(c, country)
+	})
+}
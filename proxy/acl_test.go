@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/secnex/reverse-proxy/models"
+)
+
+func TestAllowed(t *testing.T) {
+	tests := []struct {
+		name           string
+		allow          []string
+		deny           []string
+		allowCountries []string
+		denyCountries  []string
+		clientIP       string
+		want           bool
+	}{
+		{"no rules", nil, nil, nil, nil, "192.0.2.1", true},
+		{"no rules without client IP", nil, nil, nil, nil, "", true},
+		{"allowed network", []string{"192.0.2.0/24"}, nil, nil, nil, "192.0.2.1", true},
+		{"allowed IP", []string{"192.0.2.1"}, nil, nil, nil, "192.0.2.1", true},
+		{"outside allowed network", []string{"192.0.2.0/24"}, nil, nil, nil, "198.51.100.1", false},
+		{"denied network", nil, []string{"192.0.2.0/24"}, nil, nil, "192.0.2.1", false},
+		{"outside denied network", nil, []string{"192.0.2.0/24"}, nil, nil, "198.51.100.1", true},
+		{"deny wins", []string{"192.0.2.0/24"}, []string{"192.0.2.1"}, nil, nil, "192.0.2.1", false},
+		{"allowed next to denied", []string{"192.0.2.0/24"}, []string{"192.0.2.1"}, nil, nil, "192.0.2.2", true},
+		{"IPv6", []string{"2001:db8::/32"}, nil, nil, nil, "2001:db8::1", true},
+		{"IPv6 single address", nil, []string{"2001:db8::1"}, nil, nil, "2001:db8::1", false},
+		{"IPv4 rule does not match IPv6", []string{"192.0.2.0/24"}, nil, nil, nil, "2001:db8::1", false},
+		{"invalid client IP", nil, []string{"192.0.2.0/24"}, nil, nil, "unknown", false},
+		{"invalid rule is ignored", []string{"not-a-network", "192.0.2.0/24"}, nil, nil, nil, "192.0.2.1", true},
+		{"unknown country is not allowed", nil, nil, []string{"DE"}, nil, "192.0.2.1", false},
+		{"unknown country allowed by network", []string{"192.0.2.0/24"}, nil, []string{"DE"}, nil, "192.0.2.1", true},
+		{"unknown country is not denied", nil, nil, nil, []string{"DE"}, "192.0.2.1", true},
+	}
+
+	rp := &ReverseProxy{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := newProxyConfig(models.Website{
+				AllowCIDRs:     tt.allow,
+				DenyCIDRs:      tt.deny,
+				AllowCountries: tt.allowCountries,
+				DenyCountries:  tt.denyCountries,
+			})
+			if got := rp.allowed(tt.clientIP, config); got != tt.want {
+				t.Errorf("allowed(%q) = %v, want %v", tt.clientIP, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchesCountry(t *testing.T) {
+	tests := []struct {
+		countries []string
+		country   string
+		want      bool
+	}{
+		{[]string{"DE", "AT"}, "AT", true},
+		{[]string{"de"}, "DE", true},
+		{[]string{"DE"}, "FR", false},
+		{[]string{"DE"}, "", false},
+		{nil, "DE", false},
+	}
+
+	for _, tt := range tests {
+		if got := matchesCountry(tt.countries, tt.country); got != tt.want {
+			t.Errorf("matchesCountry(%v, %q) = %v, want %v", tt.countries, tt.country, got, tt.want)
+		}
+	}
+}
+
+func TestCheckAccess(t *testing.T) {
+	forwarded := false
+	store := &fakeStore{websites: []models.Website{{Domain: "a.example", DenyCIDRs: []string{"192.0.2.0/24"}}}}
+	rp := newTestProxy(t, store, func(w http.ResponseWriter, r *http.Request) {
+		forwarded = true
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "http://a.example/", nil)
+	r.RemoteAddr = "192.0.2.7:4321"
+	if w := serve(rp, r); w.Code != http.StatusForbidden || forwarded {
+		t.Errorf("denied client: status %d, forwarded %v", w.Code, forwarded)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "http://a.example/", nil)
+	r.RemoteAddr = "198.51.100.7:4321"
+	if w := serve(rp, r); w.Code != http.StatusOK || !forwarded {
+		t.Errorf("other client: status %d, forwarded %v", w.Code, forwarded)
+	}
+}
+
+func TestLoadFromDBReportsCountryRulesWithoutGeoIP(t *testing.T) {
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+
+	newTestProxy(t, &fakeStore{websites: []models.Website{
+		{Domain: "a.example", DenyCountries: []string{"RU"}},
+		{Domain: "b.example", AllowCIDRs: []string{"10.0.0.0/8"}},
+	}}, nil)
+
+	if !strings.Contains(output.String(), "Country rules of a.example never match") {
+		t.Errorf("log = %q, want a warning for a.example", output.String())
+	}
+	if strings.Contains(output.String(), "Country rules of b.example") {
+		t.Errorf("log = %q, want no warning for b.example", output.String())
+	}
+}
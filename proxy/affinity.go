@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	AffinityNone   = ""
+	AffinityCookie = "cookie"
+
+	defaultAffinityCookie = "secnex_affinity"
+)
+
+type affinitySigner struct {
+	key []byte
+}
+
+// newAffinitySigner uses the given secret as HMAC key. Without a secret a
+// random key is generated, which invalidates existing cookies on restart.
+func newAffinitySigner(secret string) *affinitySigner {
+	if secret != "" {
+		return &affinitySigner{key: []byte(secret)}
+	}
+
+	log.Println("No affinity secret configured, generating a random one...")
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Fatalf("Error generating affinity secret: %v", err)
+	}
+	return &affinitySigner{key: key}
+}
+
+func (s *affinitySigner) sign(id string) string {
+	return id + "." + base64.RawURLEncoding.EncodeToString(s.mac(id))
+}
+
+func (s *affinitySigner) verify(value string) (string, bool) {
+	id, signature, found := strings.Cut(value, ".")
+	if !found {
+		return "", false
+	}
+	expected, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return "", false
+	}
+	if !hmac.Equal(expected, s.mac(id)) {
+		return "", false
+	}
+	return id, true
+}
+
+func (s *affinitySigner) mac(id string) []byte {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(id))
+	return h.Sum(nil)
+}
+
+func affinityCookieName(config ProxyConfig) string {
+	if config.AffinityCookie != "" {
+		return config.AffinityCookie
+	}
+	return defaultAffinityCookie
+}
+
+// pinnedTarget returns the target referenced by a valid affinity cookie, as
+// long as that target is still healthy.
+func (rp *ReverseProxy) pinnedTarget(r *http.Request, config ProxyConfig, pool *UpstreamPool) (Target, bool) {
+	if config.Affinity != AffinityCookie {
+		return Target{}, false
+	}
+	cookie, err := r.Cookie(affinityCookieName(config))
+	if err != nil {
+		return Target{}, false
+	}
+	id, ok := rp.affinity.verify(cookie.Value)
+	if !ok {
+		return Target{}, false
+	}
+	return pool.Lookup(id)
+}
+
+func (rp *ReverseProxy) setAffinityCookie(w http.ResponseWriter, r *http.Request, config ProxyConfig, target Target) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     affinityCookieName(config),
+		Value:    rp.affinity.sign(target.ID()),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
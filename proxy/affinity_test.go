@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/secnex/reverse-proxy/models"
+)
+
+func TestAffinitySigner(t *testing.T) {
+	signer := newAffinitySigner("secret")
+	signed := signer.sign("0123456789abcdef")
+	id, signature, _ := strings.Cut(signed, ".")
+
+	tests := []struct {
+		name  string
+		value string
+		ok    bool
+	}{
+		{"signed", signed, true},
+		{"other id", "fedcba9876543210." + signature, false},
+		{"tampered signature", id + "." + strings.Repeat("A", len(signature)), false},
+		{"invalid signature encoding", id + ".!!!", false},
+		{"unsigned", id, false},
+		{"signed with other key", newAffinitySigner("other").sign(id), false},
+	}
+	for _, tt := range tests {
+		got, ok := signer.verify(tt.value)
+		if ok != tt.ok || (ok && got != id) {
+			t.Errorf("%s: verify() = %q, %v, want ok %v", tt.name, got, ok, tt.ok)
+		}
+	}
+}
+
+func TestAffinityCookie(t *testing.T) {
+	second := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("second"))
+	}))
+	defer second.Close()
+
+	store := &fakeStore{websites: []models.Website{{
+		Domain:         "a.example",
+		Upstreams:      []string{second.URL},
+		Affinity:       AffinityCookie,
+		AffinityCookie: "pin",
+	}}}
+	rp := newTestProxy(t, store, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("first"))
+	})
+
+	get := func(cookie *http.Cookie) (string, *http.Cookie) {
+		r := httptest.NewRequest(http.MethodGet, "http://a.example/", nil)
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		w := serve(rp, r)
+		var issued *http.Cookie
+		for _, c := range w.Result().Cookies() {
+			if c.Name == "pin" {
+				issued = c
+			}
+		}
+		return w.Body.String(), issued
+	}
+
+	backend, cookie := get(nil)
+	if cookie == nil || !cookie.HttpOnly {
+		t.Fatalf("affinity cookie = %+v", cookie)
+	}
+
+	// The pool alternates between the targets, requests with the cookie stay
+	// on the pinned one and get no new cookie.
+	for i := 0; i < 3; i++ {
+		got, issued := get(cookie)
+		if got != backend {
+			t.Fatalf("request %d went to %s, want pinned %s", i+1, got, backend)
+		}
+		if issued != nil {
+			t.Errorf("request %d: cookie reissued for a valid pin", i+1)
+		}
+	}
+
+	tampered := &http.Cookie{Name: "pin", Value: "0000000000000000." + strings.SplitN(cookie.Value, ".", 2)[1]}
+	if _, issued := get(tampered); issued == nil || issued.Value == tampered.Value {
+		t.Errorf("tampered cookie accepted, issued %+v", issued)
+	}
+
+	// The pinned target goes down: the request falls back to the other one
+	// and the cookie moves along.
+	config, _ := rp.configCache.Get("a.example")
+	pool := rp.pool("a.example", config)
+	for _, target := range pool.Targets() {
+		if pinned, _ := rp.affinity.verify(cookie.Value); pinned == target.ID() {
+			pool.MarkDown(target)
+		}
+	}
+	got, issued := get(cookie)
+	if got == backend {
+		t.Fatalf("request went to the unhealthy pinned target %s", backend)
+	}
+	if issued == nil || issued.Value == cookie.Value {
+		t.Fatalf("cookie not moved to the fallback target: %+v", issued)
+	}
+	if again, _ := get(issued); again != got {
+		t.Errorf("request with the new cookie went to %s, want %s", again, got)
+	}
+}
@@ -0,0 +1,172 @@
+package proxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	AuthNone    = ""
+	AuthBasic   = "basic"
+	AuthForward = "forward"
+	AuthOIDC    = "oidc"
+
+	forwardedUserHeader = "X-Forwarded-User"
+
+	// Verified credentials are cached, so bcrypt only runs once per user and
+	// password within credentialTTL.
+	credentialTTL      = 5 * time.Minute
+	maxCredentialCache = 10000
+)
+
+// authenticate enforces the auth mode of the site and reports whether the
+// request was answered instead of being proxied.
+func (rp *ReverseProxy) authenticate(w http.ResponseWriter, r *http.Request, host string, config ProxyConfig) bool {
+	var answered bool
+	switch config.AuthMode {
+	case AuthBasic:
+		answered = rp.basicAuth(w, r, host, config)
+	case AuthForward:
+		answered = rp.forwardAuth(w, r, config)
+	case AuthOIDC:
+		answered = rp.oidcAuth(w, r, host, config)
+	case AuthNone:
+		return false
+	default:
+		// A mistyped mode must not publish the site without auth.
+		log.Printf("Unknown auth mode %q for %s", config.AuthMode, host)
+		rp.serveError(w, r, http.StatusInternalServerError)
+		return true
+	}
+	getRequestInfo(r).authenticated = !answered
+	return answered
+}
+
+func (rp *ReverseProxy) basicAuth(w http.ResponseWriter, r *http.Request, host string, config ProxyConfig) bool {
+	r.Header.Del(forwardedUserHeader)
+
+	username, password, ok := r.BasicAuth()
+	if ok {
+		if hash, exists := config.basicAuthUsers[username]; exists && rp.credentials.verify(hash, password) {
+			// The password is for the proxy, the upstream only learns the
+			// username.
+			r.Header.Del("Authorization")
+			r.Header.Set(forwardedUserHeader, username)
+			return false
+		}
+	}
+
+	realm := config.AuthRealm
+	if realm == "" {
+		realm = host
+	}
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, realm))
+	rp.serveError(w, r, http.StatusUnauthorized)
+	return true
+}
+
+// forwardAuth asks the auth service whether the request may pass. On 2xx the
+// configured headers of its response are copied into the upstream request,
+// any other response is returned to the client, e.g. a redirect to a login.
+func (rp *ReverseProxy) forwardAuth(w http.ResponseWriter, r *http.Request, config ProxyConfig) bool {
+	// Clients must not be able to inject the identity headers themselves.
+	for _, name := range config.ForwardAuthHeaders {
+		r.Header.Del(name)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), rp.upstreamTimeout(config))
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, config.ForwardAuthURL, nil)
+	if err != nil {
+		log.Printf("Invalid forward auth URL %q: %v", config.ForwardAuthURL, err)
+		rp.serveError(w, r, http.StatusBadGateway)
+		return true
+	}
+
+	req.Header = r.Header.Clone()
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	req.Header.Set("X-Forwarded-Method", r.Method)
+	req.Header.Set("X-Forwarded-Proto", proto)
+	req.Header.Set("X-Forwarded-Host", r.Host)
+	req.Header.Set("X-Forwarded-Uri", r.URL.RequestURI())
+	req.Header.Set("X-Forwarded-For", getRequestInfo(r).clientIP)
+
+	resp, err := rp.authClient.Do(req)
+	if err != nil {
+		log.Printf("Forward auth %s failed: %v", config.ForwardAuthURL, err)
+		rp.serveError(w, r, http.StatusBadGateway)
+		return true
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		for _, name := range config.ForwardAuthHeaders {
+			for _, value := range resp.Header.Values(name) {
+				r.Header.Add(name, value)
+			}
+		}
+		return false
+	}
+
+	copyHeader(w.Header(), resp.Header)
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+	return true
+}
+
+// newAuthClient returns a client that hands redirects of the auth service,
+// e.g. to a login page, back to the caller instead of following them.
+func newAuthClient() *http.Client {
+	return &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+type credentialCache struct {
+	mu       sync.Mutex
+	verified map[[sha256.Size]byte]time.Time
+}
+
+func newCredentialCache() *credentialCache {
+	return &credentialCache{verified: make(map[[sha256.Size]byte]time.Time)}
+}
+
+// verify compares the password with the bcrypt hash. The cache key includes
+// the hash, so changed passwords are never served from the cache.
+func (c *credentialCache) verify(hash string, password string) bool {
+	key := sha256.Sum256([]byte(hash + "\x00" + password))
+	now := time.Now()
+
+	c.mu.Lock()
+	expires, exists := c.verified[key]
+	c.mu.Unlock()
+	if exists && now.Before(expires) {
+		return true
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.verified) >= maxCredentialCache {
+		c.verified = make(map[[sha256.Size]byte]time.Time)
+	}
+	c.verified[key] = now.Add(credentialTTL)
+	return true
+}
@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/secnex/reverse-proxy/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestBasicAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var upstream http.Header
+	rp := newTestProxy(t, &fakeStore{
+		websites: []models.Website{{Domain: "a.example", AuthMode: AuthBasic, AuthRealm: "Admin"}},
+		users:    []models.BasicAuthUser{{Domain: "a.example", Username: "alice", PasswordHash: string(hash)}},
+	}, func(w http.ResponseWriter, r *http.Request) {
+		upstream = r.Header.Clone()
+	})
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		injected string
+		want     int
+	}{
+		{name: "valid", username: "alice", password: "secret", want: http.StatusOK},
+		{name: "valid with injected user", username: "alice", password: "secret", injected: "root", want: http.StatusOK},
+		{name: "wrong password", username: "alice", password: "guess", want: http.StatusUnauthorized},
+		{name: "unknown user", username: "bob", password: "secret", want: http.StatusUnauthorized},
+		{name: "no credentials", injected: "alice", want: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream = nil
+			r := httptest.NewRequest(http.MethodGet, "http://a.example/", nil)
+			if tt.username != "" {
+				r.SetBasicAuth(tt.username, tt.password)
+			}
+			if tt.injected != "" {
+				r.Header.Set(forwardedUserHeader, tt.injected)
+			}
+
+			w := serve(rp, r)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+			if tt.want == http.StatusUnauthorized {
+				if got := w.Header().Get("WWW-Authenticate"); got != `Basic realm="Admin", charset="UTF-8"` {
+					t.Errorf("WWW-Authenticate = %q", got)
+				}
+				if upstream != nil {
+					t.Error("rejected request reached the upstream")
+				}
+				return
+			}
+			if got := upstream.Get("Authorization"); got != "" {
+				t.Errorf("upstream Authorization = %q, want it removed", got)
+			}
+			if got := upstream.Get(forwardedUserHeader); got != tt.username {
+				t.Errorf("upstream %s = %q, want %q", forwardedUserHeader, got, tt.username)
+			}
+		})
+	}
+}
+
+func TestUnknownAuthMode(t *testing.T) {
+	reached := false
+	rp := newTestProxy(t, &fakeStore{
+		websites: []models.Website{{Domain: "a.example", AuthMode: "Basic"}},
+	}, func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	})
+
+	w := serve(rp, httptest.NewRequest(http.MethodGet, "http://a.example/", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if reached {
+		t.Error("request reached the upstream")
+	}
+}
+
+func TestForwardAuthResponse(t *testing.T) {
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "https://login.example/")
+		w.Header().Set("Connection", "X-Auth-Hop")
+		w.Header().Set("X-Auth-Hop", "1")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.WriteHeader(http.StatusFound)
+	}))
+	defer auth.Close()
+
+	reached := false
+	rp := newTestProxy(t, &fakeStore{
+		websites: []models.Website{{Domain: "a.example", AuthMode: AuthForward, ForwardAuthURL: auth.URL}},
+	}, func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	})
+
+	w := serve(rp, httptest.NewRequest(http.MethodGet, "http://a.example/", nil))
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "https://login.example/" {
+		t.Fatalf("response = %d %q, want the redirect of the auth service", w.Code, w.Header().Get("Location"))
+	}
+	for _, name := range []string{"Connection", "X-Auth-Hop", "Keep-Alive"} {
+		if got := w.Header().Get(name); got != "" {
+			t.Errorf("%s = %q, want it removed", name, got)
+		}
+	}
+	if reached {
+		t.Error("request reached the upstream")
+	}
+}
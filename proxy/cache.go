@@ -0,0 +1,707 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	cacheStatusHeader = "X-Cache-Status"
+
+	cacheHit         = "HIT"
+	cacheMiss        = "MISS"
+	cacheExpired     = "EXPIRED"
+	cacheStale       = "STALE"
+	cacheRevalidated = "REVALIDATED"
+	cacheBypass      = "BYPASS"
+
+	maxCacheVariants      = 8
+	maxHeuristicFreshness = 24 * time.Hour
+)
+
+// heuristicStatuses can be stored without explicit freshness information
+// (RFC 9110 section 15.1).
+var heuristicStatuses = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+}
+
+// ResponseCache is the shared HTTP cache (RFC 9111) of all websites with
+// caching enabled.
+type ResponseCache struct {
+	storage       CacheStorage
+	maxObjectSize int64
+	revalidating  map[string]bool
+	flights       map[string]chan struct{}
+	mu            sync.Mutex
+}
+
+func NewResponseCache(storage CacheStorage, maxObjectSize int64) *ResponseCache {
+	return &ResponseCache{
+		storage:       storage,
+		maxObjectSize: maxObjectSize,
+		revalidating:  make(map[string]bool),
+		flights:       make(map[string]chan struct{}),
+	}
+}
+
+func (rp *ReverseProxy) SetCache(cache *ResponseCache) {
+	rp.cache = cache
+}
+
+func cacheKey(host string, r *http.Request) string {
+	return host + r.URL.RequestURI()
+}
+
+// Purge removes the stored responses of a host. Without a path the whole host
+// is purged, otherwise the path has to match exactly or, with prefix, as a
+// prefix. Query strings are ignored.
+func (c *ResponseCache) Purge(host string, path string, prefix bool) int {
+	count := 0
+	for _, key := range c.storage.Keys() {
+		keyHost, uri, found := strings.Cut(key, "/")
+		if !found || !strings.EqualFold(keyHost, host) {
+			continue
+		}
+		keyPath, _, _ := strings.Cut("/"+uri, "?")
+		switch {
+		case path == "":
+		case prefix && !strings.HasPrefix(keyPath, path):
+			continue
+		case !prefix && keyPath != path:
+			continue
+		}
+		c.storage.Delete(key)
+		count++
+	}
+	return count
+}
+
+// lookup returns the stored variant matching the request headers.
+func (c *ResponseCache) lookup(key string, header http.Header) *CachedResponse {
+	entry, exists := c.storage.Get(key)
+	if !exists {
+		return nil
+	}
+	for _, variant := range entry.Variants {
+		if variant.matches(header) {
+			return variant
+		}
+	}
+	return nil
+}
+
+// store adds the response to the entry of the key and replaces the variant
+// with the same Vary values.
+func (c *ResponseCache) store(key string, response *CachedResponse) {
+	variants := []*CachedResponse{response}
+	if entry, exists := c.storage.Get(key); exists {
+		for _, variant := range entry.Variants {
+			if len(variants) == maxCacheVariants {
+				break
+			}
+			if !sameVary(variant.Vary, response.Vary) {
+				variants = append(variants, variant)
+			}
+		}
+	}
+	c.storage.Set(key, &CacheEntry{Variants: variants})
+}
+
+// invalidate removes the stored responses for the target URI, Location and
+// Content-Location of successful unsafe requests (RFC 9111 section 4.4).
+func (c *ResponseCache) invalidate(r *http.Request, host string, resp *http.Response) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return
+	}
+	if resp.StatusCode >= 400 {
+		return
+	}
+
+	c.storage.Delete(cacheKey(host, r))
+	for _, name := range []string{"Location", "Content-Location"} {
+		value := resp.Header.Get(name)
+		if value == "" {
+			continue
+		}
+		location, err := r.URL.Parse(value)
+		if err != nil || (location.Host != "" && !strings.EqualFold(location.Hostname(), host)) {
+			continue
+		}
+		c.storage.Delete(host + location.RequestURI())
+	}
+}
+
+func (c *ResponseCache) startRevalidation(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.revalidating[key] {
+		return false
+	}
+	c.revalidating[key] = true
+	return true
+}
+
+func (c *ResponseCache) finishRevalidation(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.revalidating, key)
+}
+
+// join registers an upstream fetch for the key. The first caller leads the
+// fetch and gets a function to call once the response has been stored, later
+// callers get a channel that is closed at that point.
+func (c *ResponseCache) join(key string) (func(), <-chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if flight, exists := c.flights[key]; exists {
+		return nil, flight
+	}
+	flight := make(chan struct{})
+	c.flights[key] = flight
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.flights, key)
+		close(flight)
+	}, nil
+}
+
+func (rp *ReverseProxy) cacheEnabled(config ProxyConfig) bool {
+	return config.Cache && rp.cache != nil
+}
+
+func (rp *ReverseProxy) cacheable(r *http.Request, config ProxyConfig) bool {
+	if !rp.cacheEnabled(config) || r.Header.Get("Upgrade") != "" {
+		return false
+	}
+	return r.Method == http.MethodGet || r.Method == http.MethodHead
+}
+
+// serveCached answers a GET or HEAD request from the cache if the stored
+// response may be used, and otherwise forwards it upstream, revalidating the
+// stored response if there is one.
+func (rp *ReverseProxy) serveCached(w http.ResponseWriter, r *http.Request, host string, config ProxyConfig, header http.Header) {
+	key := cacheKey(host, r)
+	directives := requestCacheControl(r)
+	now := time.Now()
+
+	stored := rp.cache.lookup(key, header)
+	if stored != nil {
+		switch stored.usable(directives, now) {
+		case cacheFresh:
+			rp.writeCached(w, r, config, stored, cacheHit, now)
+			return
+		case cacheStaleWhileRevalidate:
+			rp.revalidate(key, r, host, config, header, stored)
+			rp.writeCached(w, r, config, stored, cacheStale, now)
+			return
+		case cacheStaleAllowed:
+			rp.writeCached(w, r, config, stored, cacheStale, now)
+			return
+		}
+	}
+
+	if directives.has("only-if-cached") {
+		rp.serveError(w, r, http.StatusGatewayTimeout)
+		return
+	}
+
+	// Concurrent misses wait for the first one and are served from the cache
+	// once its response has been stored. If it could not be stored or the wait
+	// times out, they go upstream themselves.
+	if config.Coalesce && r.Method == http.MethodGet {
+		done, flight := rp.cache.join(key)
+		if done != nil {
+			defer done()
+		} else {
+			if !rp.awaitFlight(r, config, flight) {
+				return
+			}
+			now = time.Now()
+			stored = rp.cache.lookup(key, header)
+			if stored != nil && stored.usable(directives, now) == cacheFresh {
+				rp.writeCached(w, r, config, stored, cacheHit, now)
+				return
+			}
+		}
+	}
+
+	// Conditionals of the client are evaluated against the cache, upstream
+	// only sees the validators of the stored response.
+	header.Del("If-None-Match")
+	header.Del("If-Modified-Since")
+	if stored != nil {
+		setValidators(header, stored)
+	}
+
+	ctx, cancel := context.WithCancelCause(r.Context())
+	defer cancel(nil)
+
+	requestTime := time.Now()
+	resp, target, pinned, status := rp.roundTrip(ctx, cancel, r, host, config, header, nil)
+	if status != 0 {
+		if stored != nil && stored.staleIfError(directives, now) {
+			rp.writeCached(w, r, config, stored, cacheStale, now)
+			return
+		}
+		rp.serveError(w, r, status)
+		return
+	}
+	defer resp.Body.Close()
+	responseTime := time.Now()
+
+	if config.Affinity == AffinityCookie && !pinned {
+		rp.setAffinityCookie(w, r, config, target)
+	}
+
+	if stored != nil {
+		if resp.StatusCode == http.StatusNotModified {
+			updated := stored.revalidated(resp.Header, requestTime, responseTime)
+			rp.cache.store(key, updated)
+			rp.writeCached(w, r, config, updated, cacheRevalidated, responseTime)
+			return
+		}
+		if resp.StatusCode >= 500 && stored.staleIfError(directives, now) {
+			rp.writeCached(w, r, config, stored, cacheStale, now)
+			return
+		}
+	}
+
+	cacheStatus := cacheMiss
+	if stored != nil {
+		cacheStatus = cacheExpired
+	}
+	switch {
+	case directives.has("no-store"):
+		cacheStatus = cacheBypass
+	case storable(r, resp):
+		if resp.ContentLength <= rp.cache.maxObjectSize {
+			resp.Body = &cacheRecorder{
+				ReadCloser: resp.Body,
+				limit:      rp.cache.maxObjectSize,
+				done: func(body []byte) {
+					rp.cache.store(key, newCachedResponse(resp, header, body, requestTime, responseTime))
+				},
+			}
+		}
+	case stored != nil && r.Method == http.MethodGet:
+		// The resource is no longer cacheable.
+		rp.cache.storage.Delete(key)
+	}
+
+	w.Header().Set(cacheStatusHeader, cacheStatus)
+	rp.writeResponse(w, r, config, resp)
+}
+
+// awaitFlight waits for the leading fetch of a coalesced request and reports
+// false if the client went away in the meantime.
+func (rp *ReverseProxy) awaitFlight(r *http.Request, config ProxyConfig, flight <-chan struct{}) bool {
+	timeout := config.CoalesceTimeout
+	if timeout <= 0 {
+		timeout = rp.upstreamTimeout(config)
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-flight:
+	case <-timer.C:
+	case <-r.Context().Done():
+		return false
+	}
+	return true
+}
+
+// revalidate refreshes a stale response in the background while the stale
+// one is served. Only one revalidation per key runs at a time.
+func (rp *ReverseProxy) revalidate(key string, r *http.Request, host string, config ProxyConfig, header http.Header, stored *CachedResponse) {
+	if !rp.cache.startRevalidation(key) {
+		return
+	}
+
+	// The revalidation outlives the request, it gets its own copy of the
+	// request info.
+	info := *getRequestInfo(r)
+	r = r.Clone(context.WithValue(context.Background(), requestInfoKey{}, &info))
+	r.Method = http.MethodGet
+	r.Body = http.NoBody
+	header = header.Clone()
+	header.Del("If-None-Match")
+	header.Del("If-Modified-Since")
+	setValidators(header, stored)
+
+	go func() {
+		defer rp.cache.finishRevalidation(key)
+
+		ctx, cancel := context.WithCancelCause(r.Context())
+		defer cancel(nil)
+
+		requestTime := time.Now()
+		resp, _, _, status := rp.roundTrip(ctx, cancel, r, host, config, header, nil)
+		if status != 0 {
+			return
+		}
+		defer resp.Body.Close()
+		responseTime := time.Now()
+
+		switch {
+		case resp.StatusCode == http.StatusNotModified:
+			rp.cache.store(key, stored.revalidated(resp.Header, requestTime, responseTime))
+		case storable(r, resp):
+			body, err := io.ReadAll(io.LimitReader(resp.Body, rp.cache.maxObjectSize+1))
+			if err != nil || int64(len(body)) > rp.cache.maxObjectSize {
+				return
+			}
+			rp.cache.store(key, newCachedResponse(resp, header, body, requestTime, responseTime))
+		}
+	}()
+}
+
+// writeCached writes a stored response and answers conditional requests of
+// the client with 304.
+func (rp *ReverseProxy) writeCached(w http.ResponseWriter, r *http.Request, config ProxyConfig, stored *CachedResponse, cacheStatus string, now time.Time) {
+	resp := &http.Response{
+		StatusCode:    stored.StatusCode,
+		Header:        stored.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(stored.Body)),
+		ContentLength: int64(len(stored.Body)),
+	}
+	resp.Header.Set("Age", strconv.FormatInt(int64(stored.age(now)/time.Second), 10))
+
+	if stored.notModified(r) {
+		resp.StatusCode = http.StatusNotModified
+		resp.Body = http.NoBody
+		resp.ContentLength = 0
+		resp.Header.Del("Content-Length")
+	}
+
+	w.Header().Set(cacheStatusHeader, cacheStatus)
+	rp.writeResponse(w, r, config, resp)
+}
+
+// storable reports whether a response may be stored by a shared cache
+// (RFC 9111 section 3). The cache key does not include the user, so responses
+// to authenticated requests are only stored if they are marked as shared.
+func storable(r *http.Request, resp *http.Response) bool {
+	if r.Method != http.MethodGet || resp.StatusCode < 200 || resp.StatusCode == http.StatusPartialContent {
+		return false
+	}
+
+	directives := parseCacheControl(resp.Header.Values("Cache-Control"))
+	if directives.has("no-store") || directives.has("private") {
+		return false
+	}
+	if len(resp.Header.Values("Set-Cookie")) > 0 || headerHasToken(resp.Header, "Vary", "*") {
+		return false
+	}
+
+	explicit := directives.has("public") || directives.has("max-age") || directives.has("s-maxage") || resp.Header.Get("Expires") != ""
+	authenticated := r.Header.Get("Authorization") != "" || getRequestInfo(r).authenticated
+	if authenticated && !directives.has("public") && !directives.has("s-maxage") && !directives.has("must-revalidate") {
+		return false
+	}
+	if !explicit && !heuristicStatuses[resp.StatusCode] {
+		return false
+	}
+
+	// Responses that are stale right away are only worth storing if they
+	// can be revalidated.
+	return explicit || resp.Header.Get("Last-Modified") != "" || resp.Header.Get("ETag") != ""
+}
+
+func setValidators(header http.Header, stored *CachedResponse) {
+	if etag := stored.Header.Get("ETag"); etag != "" {
+		header.Set("If-None-Match", etag)
+	}
+	if lastModified := stored.Header.Get("Last-Modified"); lastModified != "" {
+		header.Set("If-Modified-Since", lastModified)
+	}
+}
+
+func newCachedResponse(resp *http.Response, header http.Header, body []byte, requestTime time.Time, responseTime time.Time) *CachedResponse {
+	vary := make(map[string]string)
+	for _, value := range resp.Header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name != "" {
+				vary[name] = strings.Join(header.Values(name), ", ")
+			}
+		}
+	}
+
+	return &CachedResponse{
+		Vary:         vary,
+		StatusCode:   resp.StatusCode,
+		Header:       resp.Header.Clone(),
+		Body:         bytes.Clone(body),
+		RequestTime:  requestTime,
+		ResponseTime: responseTime,
+	}
+}
+
+func sameVary(a map[string]string, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, value := range a {
+		if other, exists := b[name]; !exists || other != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *CachedResponse) matches(header http.Header) bool {
+	for name, value := range s.Vary {
+		if strings.Join(header.Values(name), ", ") != value {
+			return false
+		}
+	}
+	return true
+}
+
+type cacheUsability int
+
+const (
+	cacheRevalidate cacheUsability = iota
+	cacheFresh
+	cacheStaleWhileRevalidate
+	cacheStaleAllowed
+)
+
+// usable decides whether the stored response can be served as it is, can be
+// served stale or has to be revalidated first.
+func (s *CachedResponse) usable(request cacheControl, now time.Time) cacheUsability {
+	directives := s.cacheControl()
+	if request.has("no-cache") || directives.has("no-cache") {
+		return cacheRevalidate
+	}
+
+	age := s.age(now)
+	if maxAge, ok := request.seconds("max-age"); ok && age > maxAge {
+		return cacheRevalidate
+	}
+
+	lifetime := s.freshnessLifetime()
+	minFresh, _ := request.seconds("min-fresh")
+	if age+minFresh < lifetime {
+		return cacheFresh
+	}
+
+	if directives.has("must-revalidate") || directives.has("proxy-revalidate") {
+		return cacheRevalidate
+	}
+	staleness := age - lifetime
+	if value, ok := request["max-stale"]; ok {
+		if maxStale, ok := request.seconds("max-stale"); value == "" || (ok && staleness <= maxStale) {
+			return cacheStaleAllowed
+		}
+	}
+	if window, ok := directives.seconds("stale-while-revalidate"); ok && staleness <= window {
+		return cacheStaleWhileRevalidate
+	}
+	return cacheRevalidate
+}
+
+// staleIfError reports whether the stored response may be served instead of
+// an upstream error (RFC 5861).
+func (s *CachedResponse) staleIfError(request cacheControl, now time.Time) bool {
+	directives := s.cacheControl()
+	if directives.has("must-revalidate") || directives.has("proxy-revalidate") {
+		return false
+	}
+
+	staleness := s.age(now) - s.freshnessLifetime()
+	for _, source := range []cacheControl{request, directives} {
+		if window, ok := source.seconds("stale-if-error"); ok && staleness <= window {
+			return true
+		}
+	}
+	return false
+}
+
+// freshnessLifetime follows RFC 9111 section 4.2.1 and falls back to 10% of
+// the time since Last-Modified for heuristically cacheable responses.
+func (s *CachedResponse) freshnessLifetime() time.Duration {
+	directives := s.cacheControl()
+	if lifetime, ok := directives.seconds("s-maxage"); ok {
+		return lifetime
+	}
+	if lifetime, ok := directives.seconds("max-age"); ok {
+		return lifetime
+	}
+	if expires := s.Header.Get("Expires"); expires != "" {
+		expiresAt, err := http.ParseTime(expires)
+		if err != nil {
+			return 0
+		}
+		return expiresAt.Sub(s.date())
+	}
+
+	if !heuristicStatuses[s.StatusCode] {
+		return 0
+	}
+	if lastModified, err := http.ParseTime(s.Header.Get("Last-Modified")); err == nil {
+		return min(s.date().Sub(lastModified)/10, maxHeuristicFreshness)
+	}
+	return 0
+}
+
+// age is the current age of the response (RFC 9111 section 4.2.3).
+func (s *CachedResponse) age(now time.Time) time.Duration {
+	apparentAge := max(0, s.ResponseTime.Sub(s.date()))
+
+	var ageValue time.Duration
+	if seconds, err := strconv.ParseInt(s.Header.Get("Age"), 10, 64); err == nil && seconds > 0 {
+		ageValue = time.Duration(seconds) * time.Second
+	}
+	correctedAge := ageValue + s.ResponseTime.Sub(s.RequestTime)
+
+	return max(apparentAge, correctedAge) + now.Sub(s.ResponseTime)
+}
+
+func (s *CachedResponse) date() time.Time {
+	if date, err := http.ParseTime(s.Header.Get("Date")); err == nil {
+		return date
+	}
+	return s.ResponseTime
+}
+
+func (s *CachedResponse) cacheControl() cacheControl {
+	return parseCacheControl(s.Header.Values("Cache-Control"))
+}
+
+// revalidated returns a copy of the response with the headers of a 304
+// response applied (RFC 9111 section 4.3.4).
+func (s *CachedResponse) revalidated(header http.Header, requestTime time.Time, responseTime time.Time) *CachedResponse {
+	updated := *s
+	updated.Header = s.Header.Clone()
+	updated.RequestTime = requestTime
+	updated.ResponseTime = responseTime
+
+	skip := map[string]bool{"Content-Length": true}
+	for _, name := range hopHeaders {
+		skip[name] = true
+	}
+	for name, values := range header {
+		if !skip[name] {
+			updated.Header[name] = append([]string(nil), values...)
+		}
+	}
+	return &updated
+}
+
+// notModified evaluates If-None-Match and If-Modified-Since of the client
+// against the stored response.
+func (s *CachedResponse) notModified(r *http.Request) bool {
+	if s.StatusCode != http.StatusOK {
+		return false
+	}
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		etag := s.Header.Get("ETag")
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || (etag != "" && strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/")) {
+				return true
+			}
+		}
+		return false
+	}
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(s.Header.Get("Last-Modified"))
+	return err == nil && !lastModified.After(since)
+}
+
+type cacheControl map[string]string
+
+func parseCacheControl(values []string) cacheControl {
+	directives := make(cacheControl)
+	for _, value := range values {
+		for _, directive := range strings.Split(value, ",") {
+			name, argument, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name != "" {
+				directives[name] = strings.Trim(strings.TrimSpace(argument), `"`)
+			}
+		}
+	}
+	return directives
+}
+
+// requestCacheControl treats "Pragma: no-cache" like "Cache-Control: no-cache"
+// if the request has no Cache-Control header.
+func requestCacheControl(r *http.Request) cacheControl {
+	directives := parseCacheControl(r.Header.Values("Cache-Control"))
+	if len(directives) == 0 && headerHasToken(r.Header, "Pragma", "no-cache") {
+		directives["no-cache"] = ""
+	}
+	return directives
+}
+
+func (c cacheControl) has(name string) bool {
+	_, exists := c[name]
+	return exists
+}
+
+func (c cacheControl) seconds(name string) (time.Duration, bool) {
+	value, exists := c[name]
+	if !exists {
+		return 0, false
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// cacheRecorder copies the body while it is streamed to the client and stores
+// the response once it has been read completely.
+type cacheRecorder struct {
+	io.ReadCloser
+	buf      bytes.Buffer
+	limit    int64
+	exceeded bool
+	done     func(body []byte)
+}
+
+func (c *cacheRecorder) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if !c.exceeded {
+		if int64(c.buf.Len()+n) > c.limit {
+			c.exceeded = true
+			c.buf = bytes.Buffer{}
+		} else {
+			c.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF && !c.exceeded && c.done != nil {
+		c.done(c.buf.Bytes())
+		c.done = nil
+	}
+	return n, err
+}
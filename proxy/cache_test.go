@@ -0,0 +1,332 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/secnex/reverse-proxy/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var cacheTestNow = time.Date(2025, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+// cachedAt returns a response received at the given offset from
+// cacheTestNow, with a Date header of the same time.
+func cachedAt(offset time.Duration, status int, header http.Header) *CachedResponse {
+	received := cacheTestNow.Add(offset)
+	header = header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	if header.Get("Date") == "" {
+		header.Set("Date", received.Format(http.TimeFormat))
+	}
+	return &CachedResponse{StatusCode: status, Header: header, RequestTime: received, ResponseTime: received}
+}
+
+func TestFreshnessLifetime(t *testing.T) {
+	date := cacheTestNow.Format(http.TimeFormat)
+	tests := []struct {
+		name   string
+		status int
+		header http.Header
+		want   time.Duration
+	}{
+		{"none", http.StatusOK, http.Header{}, 0},
+		{"max-age", http.StatusOK, http.Header{"Cache-Control": {"max-age=60"}}, time.Minute},
+		{"s-maxage wins", http.StatusOK, http.Header{"Cache-Control": {"max-age=60, s-maxage=120"}}, 2 * time.Minute},
+		{"invalid max-age", http.StatusOK, http.Header{"Cache-Control": {"max-age=abc"}}, 0},
+		{"negative max-age", http.StatusOK, http.Header{"Cache-Control": {"max-age=-1"}}, 0},
+		{"max-age wins over Expires", http.StatusOK, http.Header{"Cache-Control": {"max-age=60"}, "Expires": {cacheTestNow.Add(time.Hour).Format(http.TimeFormat)}}, time.Minute},
+		{"Expires", http.StatusOK, http.Header{"Date": {date}, "Expires": {cacheTestNow.Add(time.Hour).Format(http.TimeFormat)}}, time.Hour},
+		{"Expires in the past", http.StatusOK, http.Header{"Date": {date}, "Expires": {cacheTestNow.Add(-time.Hour).Format(http.TimeFormat)}}, -time.Hour},
+		{"invalid Expires", http.StatusOK, http.Header{"Expires": {"0"}}, 0},
+		{"heuristic", http.StatusOK, http.Header{"Last-Modified": {cacheTestNow.Add(-10 * time.Hour).Format(http.TimeFormat)}}, time.Hour},
+		{"heuristic limit", http.StatusOK, http.Header{"Last-Modified": {cacheTestNow.Add(-1000 * time.Hour).Format(http.TimeFormat)}}, maxHeuristicFreshness},
+		{"heuristic 404", http.StatusNotFound, http.Header{"Last-Modified": {cacheTestNow.Add(-10 * time.Hour).Format(http.TimeFormat)}}, time.Hour},
+		{"no heuristic for 302", http.StatusFound, http.Header{"Last-Modified": {cacheTestNow.Add(-10 * time.Hour).Format(http.TimeFormat)}}, 0},
+		{"explicit 302", http.StatusFound, http.Header{"Cache-Control": {"max-age=30"}}, 30 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cachedAt(0, tt.status, tt.header).freshnessLifetime(); got != tt.want {
+				t.Errorf("freshnessLifetime() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCachedResponseAge(t *testing.T) {
+	tests := []struct {
+		name     string
+		response *CachedResponse
+		want     time.Duration
+	}{
+		{"just received", cachedAt(0, http.StatusOK, nil), 0},
+		{"resident time", cachedAt(-30*time.Second, http.StatusOK, nil), 30 * time.Second},
+		{"Age header", cachedAt(-30*time.Second, http.StatusOK, http.Header{"Age": {"100"}}), 130 * time.Second},
+		{"invalid Age header", cachedAt(-30*time.Second, http.StatusOK, http.Header{"Age": {"-5"}}), 30 * time.Second},
+		{"apparent age", cachedAt(0, http.StatusOK, http.Header{"Date": {cacheTestNow.Add(-time.Minute).Format(http.TimeFormat)}}), time.Minute},
+		{"Date in the future", cachedAt(0, http.StatusOK, http.Header{"Date": {cacheTestNow.Add(time.Minute).Format(http.TimeFormat)}}), 0},
+		{"response delay", &CachedResponse{Header: http.Header{}, RequestTime: cacheTestNow.Add(-3 * time.Second), ResponseTime: cacheTestNow}, 3 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.response.age(cacheTestNow); got != tt.want {
+				t.Errorf("age() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCachedResponseUsable(t *testing.T) {
+	tests := []struct {
+		name     string
+		received time.Duration
+		header   http.Header
+		request  string
+		want     cacheUsability
+	}{
+		{"fresh", -30 * time.Second, http.Header{"Cache-Control": {"max-age=60"}}, "", cacheFresh},
+		{"stale", -90 * time.Second, http.Header{"Cache-Control": {"max-age=60"}}, "", cacheRevalidate},
+		{"exactly expired", -60 * time.Second, http.Header{"Cache-Control": {"max-age=60"}}, "", cacheRevalidate},
+		{"response no-cache", -time.Second, http.Header{"Cache-Control": {"max-age=60, no-cache"}}, "", cacheRevalidate},
+		{"request no-cache", -time.Second, http.Header{"Cache-Control": {"max-age=60"}}, "no-cache", cacheRevalidate},
+		{"request max-age", -30 * time.Second, http.Header{"Cache-Control": {"max-age=60"}}, "max-age=10", cacheRevalidate},
+		{"request max-age satisfied", -5 * time.Second, http.Header{"Cache-Control": {"max-age=60"}}, "max-age=10", cacheFresh},
+		{"request min-fresh", -30 * time.Second, http.Header{"Cache-Control": {"max-age=60"}}, "min-fresh=40", cacheRevalidate},
+		{"request min-fresh satisfied", -30 * time.Second, http.Header{"Cache-Control": {"max-age=60"}}, "min-fresh=20", cacheFresh},
+		{"request max-stale", -90 * time.Second, http.Header{"Cache-Control": {"max-age=60"}}, "max-stale=60", cacheStaleAllowed},
+		{"request max-stale exceeded", -200 * time.Second, http.Header{"Cache-Control": {"max-age=60"}}, "max-stale=60", cacheRevalidate},
+		{"request max-stale without limit", -time.Hour, http.Header{"Cache-Control": {"max-age=60"}}, "max-stale", cacheStaleAllowed},
+		{"must-revalidate", -90 * time.Second, http.Header{"Cache-Control": {"max-age=60, must-revalidate"}}, "max-stale", cacheRevalidate},
+		{"proxy-revalidate", -90 * time.Second, http.Header{"Cache-Control": {"max-age=60, proxy-revalidate, stale-while-revalidate=60"}}, "", cacheRevalidate},
+		{"stale-while-revalidate", -90 * time.Second, http.Header{"Cache-Control": {"max-age=60, stale-while-revalidate=60"}}, "", cacheStaleWhileRevalidate},
+		{"stale-while-revalidate exceeded", -200 * time.Second, http.Header{"Cache-Control": {"max-age=60, stale-while-revalidate=60"}}, "", cacheRevalidate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := parseCacheControl([]string{tt.request})
+			if got := cachedAt(tt.received, http.StatusOK, tt.header).usable(request, cacheTestNow); got != tt.want {
+				t.Errorf("usable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCachedResponseStaleIfError(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  http.Header
+		request string
+		want    bool
+	}{
+		{"none", http.Header{"Cache-Control": {"max-age=60"}}, "", false},
+		{"response", http.Header{"Cache-Control": {"max-age=60, stale-if-error=60"}}, "", true},
+		{"response exceeded", http.Header{"Cache-Control": {"max-age=60, stale-if-error=10"}}, "", false},
+		{"request", http.Header{"Cache-Control": {"max-age=60"}}, "stale-if-error=60", true},
+		{"must-revalidate", http.Header{"Cache-Control": {"max-age=60, stale-if-error=60, must-revalidate"}}, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := parseCacheControl([]string{tt.request})
+			if got := cachedAt(-90*time.Second, http.StatusOK, tt.header).staleIfError(request, cacheTestNow); got != tt.want {
+				t.Errorf("staleIfError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStorable(t *testing.T) {
+	lastModified := cacheTestNow.Format(http.TimeFormat)
+	tests := []struct {
+		name          string
+		method        string
+		authorization bool
+		status        int
+		header        http.Header
+		want          bool
+	}{
+		{"max-age", http.MethodGet, false, http.StatusOK, http.Header{"Cache-Control": {"max-age=60"}}, true},
+		{"HEAD", http.MethodHead, false, http.StatusOK, http.Header{"Cache-Control": {"max-age=60"}}, false},
+		{"POST", http.MethodPost, false, http.StatusOK, http.Header{"Cache-Control": {"max-age=60"}}, false},
+		{"partial content", http.MethodGet, false, http.StatusPartialContent, http.Header{"Cache-Control": {"max-age=60"}}, false},
+		{"informational", http.MethodGet, false, http.StatusContinue, http.Header{"Cache-Control": {"max-age=60"}}, false},
+		{"no-store", http.MethodGet, false, http.StatusOK, http.Header{"Cache-Control": {"max-age=60, no-store"}}, false},
+		{"private", http.MethodGet, false, http.StatusOK, http.Header{"Cache-Control": {"private, max-age=60"}}, false},
+		{"Set-Cookie", http.MethodGet, false, http.StatusOK, http.Header{"Cache-Control": {"max-age=60"}, "Set-Cookie": {"a=b"}}, false},
+		{"Vary *", http.MethodGet, false, http.StatusOK, http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"Accept, *"}}, false},
+		{"Vary header", http.MethodGet, false, http.StatusOK, http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"Accept"}}, true},
+		{"Expires", http.MethodGet, false, http.StatusOK, http.Header{"Expires": {lastModified}}, true},
+		{"public", http.MethodGet, false, http.StatusOK, http.Header{"Cache-Control": {"public"}}, true},
+		{"validator only", http.MethodGet, false, http.StatusOK, http.Header{"Etag": {`"v1"`}}, true},
+		{"Last-Modified only", http.MethodGet, false, http.StatusOK, http.Header{"Last-Modified": {lastModified}}, true},
+		{"nothing", http.MethodGet, false, http.StatusOK, http.Header{}, false},
+		{"validator of non-heuristic status", http.MethodGet, false, http.StatusFound, http.Header{"Etag": {`"v1"`}}, false},
+		{"explicit non-heuristic status", http.MethodGet, false, http.StatusFound, http.Header{"Cache-Control": {"max-age=60"}}, true},
+		{"authorization", http.MethodGet, true, http.StatusOK, http.Header{"Cache-Control": {"max-age=60"}}, false},
+		{"authorization public", http.MethodGet, true, http.StatusOK, http.Header{"Cache-Control": {"public, max-age=60"}}, true},
+		{"authorization s-maxage", http.MethodGet, true, http.StatusOK, http.Header{"Cache-Control": {"s-maxage=60"}}, true},
+		{"authorization must-revalidate", http.MethodGet, true, http.StatusOK, http.Header{"Cache-Control": {"max-age=60, must-revalidate"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "http://a.example/", nil)
+			if tt.authorization {
+				r.Header.Set("Authorization", "Bearer token")
+			}
+			resp := &http.Response{StatusCode: tt.status, Header: tt.header}
+			if got := storable(r, resp); got != tt.want {
+				t.Errorf("storable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCachedResponseMatches(t *testing.T) {
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Vary": {"Accept-Encoding, accept-language"}}}
+	stored := newCachedResponse(resp, http.Header{"Accept-Encoding": {"gzip"}, "Accept-Language": {"de", "en"}}, nil, cacheTestNow, cacheTestNow)
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   bool
+	}{
+		{"same", http.Header{"Accept-Encoding": {"gzip"}, "Accept-Language": {"de", "en"}}, true},
+		{"same joined", http.Header{"Accept-Encoding": {"gzip"}, "Accept-Language": {"de, en"}}, true},
+		{"other value", http.Header{"Accept-Encoding": {"br"}, "Accept-Language": {"de", "en"}}, false},
+		{"missing header", http.Header{"Accept-Encoding": {"gzip"}}, false},
+		{"other headers ignored", http.Header{"Accept-Encoding": {"gzip"}, "Accept-Language": {"de, en"}, "Accept": {"text/html"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stored.matches(tt.header); got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResponseCacheStoreVariants(t *testing.T) {
+	cache := NewResponseCache(NewMemoryCacheStorage(1<<20), 1<<20)
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Vary": {"Accept-Encoding"}}}
+	variant := func(encoding string, body string) *CachedResponse {
+		return newCachedResponse(resp, http.Header{"Accept-Encoding": {encoding}}, []byte(body), cacheTestNow, cacheTestNow)
+	}
+
+	cache.store("a.example/", variant("gzip", "gzip v1"))
+	cache.store("a.example/", variant("br", "br v1"))
+	cache.store("a.example/", variant("gzip", "gzip v2"))
+
+	for encoding, want := range map[string]string{"gzip": "gzip v2", "br": "br v1"} {
+		stored := cache.lookup("a.example/", http.Header{"Accept-Encoding": {encoding}})
+		if stored == nil || string(stored.Body) != want {
+			t.Errorf("lookup(%s) = %+v, want %q", encoding, stored, want)
+		}
+	}
+	if stored := cache.lookup("a.example/", http.Header{"Accept-Encoding": {"zstd"}}); stored != nil {
+		t.Errorf("lookup(zstd) = %+v", stored)
+	}
+	entry, _ := cache.storage.Get("a.example/")
+	if len(entry.Variants) != 2 {
+		t.Errorf("%d variants stored, want 2", len(entry.Variants))
+	}
+}
+
+func TestResponseCache(t *testing.T) {
+	requests := 0
+	store := &fakeStore{websites: []models.Website{{Domain: "a.example", Cache: true}}}
+	rp := newTestProxy(t, store, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("cached body"))
+	})
+	rp.SetCache(NewResponseCache(NewMemoryCacheStorage(1<<20), 1<<20))
+
+	tests := []struct {
+		name        string
+		header      http.Header
+		status      int
+		cacheStatus string
+		requests    int
+	}{
+		{"miss", nil, http.StatusOK, cacheMiss, 1},
+		{"hit", nil, http.StatusOK, cacheHit, 1},
+		{"conditional hit", http.Header{"If-None-Match": {`"v1"`}}, http.StatusNotModified, cacheHit, 1},
+		{"no-cache", http.Header{"Cache-Control": {"no-cache"}}, http.StatusOK, cacheExpired, 2},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "http://a.example/page", nil)
+		for name, values := range tt.header {
+			r.Header[name] = values
+		}
+		w := serve(rp, r)
+		if w.Code != tt.status || w.Header().Get(cacheStatusHeader) != tt.cacheStatus || requests != tt.requests {
+			t.Errorf("%s: got %d %s after %d upstream requests, want %d %s after %d", tt.name, w.Code, w.Header().Get(cacheStatusHeader), requests, tt.status, tt.cacheStatus, tt.requests)
+		}
+		if tt.status == http.StatusOK && w.Body.String() != "cached body" {
+			t.Errorf("%s: body = %q", tt.name, w.Body.String())
+		}
+	}
+}
+
+func TestResponseCacheAuthenticatedUsers(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		cacheControl string
+		shared       bool
+	}{
+		{"max-age", "max-age=60", false},
+		{"heuristic", "", false},
+		{"public", "public, max-age=60", true},
+		{"s-maxage", "s-maxage=60", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &fakeStore{
+				websites: []models.Website{{Domain: "a.example", Cache: true, AuthMode: AuthBasic}},
+				users: []models.BasicAuthUser{
+					{Domain: "a.example", Username: "alice", PasswordHash: string(hash)},
+					{Domain: "a.example", Username: "bob", PasswordHash: string(hash)},
+				},
+			}
+			rp := newTestProxy(t, store, func(w http.ResponseWriter, r *http.Request) {
+				if tt.cacheControl != "" {
+					w.Header().Set("Cache-Control", tt.cacheControl)
+				}
+				w.Header().Set("Last-Modified", time.Now().Add(-time.Hour).Format(http.TimeFormat))
+				w.Write([]byte("profile of " + r.Header.Get(forwardedUserHeader)))
+			})
+			rp.SetCache(NewResponseCache(NewMemoryCacheStorage(1<<20), 1<<20))
+
+			for _, user := range []string{"alice", "bob"} {
+				r := httptest.NewRequest(http.MethodGet, "http://a.example/profile", nil)
+				r.SetBasicAuth(user, "secret")
+				w := serve(rp, r)
+
+				want := "profile of " + user
+				if tt.shared {
+					want = "profile of alice"
+				}
+				if w.Body.String() != want {
+					t.Errorf("%s: body = %q, want %q", user, w.Body.String(), want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,356 @@
+package proxy
+
+import (
+	"bufio"
+	"container/list"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheStorage stores cache entries by key. Entries are never modified after
+// they have been stored, updates replace the whole entry.
+type CacheStorage interface {
+	Get(key string) (*CacheEntry, bool)
+	Set(key string, entry *CacheEntry)
+	Delete(key string)
+	Keys() []string
+}
+
+// CacheEntry holds the stored variants of a resource, one per combination of
+// the request headers listed in Vary.
+type CacheEntry struct {
+	Variants []*CachedResponse
+}
+
+// CachedResponse is a stored response together with the times needed for the
+// age calculation of RFC 9111.
+type CachedResponse struct {
+	Vary         map[string]string
+	StatusCode   int
+	Header       http.Header
+	Body         []byte
+	RequestTime  time.Time
+	ResponseTime time.Time
+}
+
+func (e *CacheEntry) size() int64 {
+	var size int64
+	for _, variant := range e.Variants {
+		size += int64(len(variant.Body))
+		for name, values := range variant.Header {
+			size += int64(len(name))
+			for _, value := range values {
+				size += int64(len(value))
+			}
+		}
+	}
+	return size
+}
+
+// MemoryCacheStorage keeps entries in memory and evicts the least recently
+// used ones once maxBytes is exceeded.
+type MemoryCacheStorage struct {
+	maxBytes int64
+	size     int64
+	items    map[string]*list.Element
+	order    *list.List
+	mu       sync.Mutex
+}
+
+type memoryCacheItem struct {
+	key   string
+	entry *CacheEntry
+	size  int64
+}
+
+func NewMemoryCacheStorage(maxBytes int64) *MemoryCacheStorage {
+	return &MemoryCacheStorage{
+		maxBytes: maxBytes,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (s *MemoryCacheStorage) Get(key string) (*CacheEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	element, exists := s.items[key]
+	if !exists {
+		return nil, false
+	}
+	s.order.MoveToFront(element)
+	return element.Value.(*memoryCacheItem).entry, true
+}
+
+func (s *MemoryCacheStorage) Set(key string, entry *CacheEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.remove(key)
+	item := &memoryCacheItem{key: key, entry: entry, size: entry.size() + int64(len(key))}
+	if item.size > s.maxBytes {
+		return
+	}
+	s.items[key] = s.order.PushFront(item)
+	s.size += item.size
+
+	for s.size > s.maxBytes {
+		s.remove(s.order.Back().Value.(*memoryCacheItem).key)
+	}
+}
+
+func (s *MemoryCacheStorage) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(key)
+}
+
+func (s *MemoryCacheStorage) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, 0, len(s.items))
+	for key := range s.items {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (s *MemoryCacheStorage) remove(key string) {
+	element, exists := s.items[key]
+	if !exists {
+		return
+	}
+	s.order.Remove(element)
+	delete(s.items, key)
+	s.size -= element.Value.(*memoryCacheItem).size
+}
+
+// DiskCacheStorage keeps one file per entry in a directory. The key is
+// written on the first line of each file, so the index can be rebuilt on
+// startup without decoding the entries. Files are read and written outside
+// the lock, only the index is guarded by it.
+type DiskCacheStorage struct {
+	dir      string
+	maxBytes int64
+	size     int64
+	items    map[string]*list.Element
+	order    *list.List
+	mu       sync.Mutex
+}
+
+type diskCacheItem struct {
+	key  string
+	file string
+	size int64
+}
+
+func NewDiskCacheStorage(dir string, maxBytes int64) (*DiskCacheStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating cache directory: %v", err)
+	}
+
+	s := &DiskCacheStorage{
+		dir:      dir,
+		maxBytes: maxBytes,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.cache"))
+	if err != nil {
+		return nil, err
+	}
+	type storedFile struct {
+		item    *diskCacheItem
+		modTime time.Time
+	}
+	var stored []storedFile
+	for _, file := range files {
+		key, info, err := readCacheKey(file)
+		if err != nil {
+			log.Printf("Removing unreadable cache file %s: %v", file, err)
+			os.Remove(file)
+			continue
+		}
+		stored = append(stored, storedFile{item: &diskCacheItem{key: key, file: file, size: info.Size()}, modTime: info.ModTime()})
+	}
+
+	// The modification time is the last write, the best guess for the last
+	// use after a restart.
+	sort.Slice(stored, func(i, j int) bool { return stored[i].modTime.Before(stored[j].modTime) })
+	for _, file := range stored {
+		s.items[file.item.key] = s.order.PushFront(file.item)
+		s.size += file.item.size
+	}
+	s.evict()
+	return s, nil
+}
+
+func readCacheKey(file string) (string, os.FileInfo, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", nil, err
+	}
+	key, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil {
+		return "", nil, err
+	}
+	return strings.TrimSuffix(key, "\n"), info, nil
+}
+
+func (s *DiskCacheStorage) Get(key string) (*CacheEntry, bool) {
+	s.mu.Lock()
+	element, exists := s.items[key]
+	if !exists {
+		s.mu.Unlock()
+		return nil, false
+	}
+	s.order.MoveToFront(element)
+	item := element.Value.(*diskCacheItem)
+	s.mu.Unlock()
+
+	entry, err := readCacheFile(item.file)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Error reading cache file %s: %v", item.file, err)
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		// The entry may have been replaced while the file was read.
+		if s.items[key] == element {
+			s.remove(key)
+		}
+		return nil, false
+	}
+	return entry, true
+}
+
+func readCacheFile(file string) (*CacheEntry, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	if _, err := reader.ReadString('\n'); err != nil {
+		return nil, err
+	}
+	var entry CacheEntry
+	if err := gob.NewDecoder(reader).Decode(&entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (s *DiskCacheStorage) Set(key string, entry *CacheEntry) {
+	sum := sha256.Sum256([]byte(key))
+	file := filepath.Join(s.dir, hex.EncodeToString(sum[:])+".cache")
+
+	tmp, size, err := writeCacheFile(s.dir, key, entry)
+	if err != nil {
+		log.Printf("Error writing cache file %s: %v", file, err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.Rename(tmp, file); err != nil {
+		log.Printf("Error writing cache file %s: %v", file, err)
+		os.Remove(tmp)
+		return
+	}
+	if element, exists := s.items[key]; exists {
+		s.size -= element.Value.(*diskCacheItem).size
+		s.order.Remove(element)
+	}
+	s.items[key] = s.order.PushFront(&diskCacheItem{key: key, file: file, size: size})
+	s.size += size
+	s.evict()
+}
+
+// writeCacheFile writes the entry to a temporary file in dir, which is renamed
+// into place afterwards, so readers never see partially written entries.
+func writeCacheFile(dir string, key string, entry *CacheEntry) (string, int64, error) {
+	tmp, err := os.CreateTemp(dir, "tmp-*")
+	if err != nil {
+		return "", 0, err
+	}
+
+	writer := bufio.NewWriter(tmp)
+	writer.WriteString(key + "\n")
+	err = gob.NewEncoder(writer).Encode(entry)
+	if err == nil {
+		err = writer.Flush()
+	}
+	var info os.FileInfo
+	if err == nil {
+		info, err = tmp.Stat()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", 0, err
+	}
+	return tmp.Name(), info.Size(), nil
+}
+
+func (s *DiskCacheStorage) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(key)
+}
+
+func (s *DiskCacheStorage) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, 0, len(s.items))
+	for key := range s.items {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (s *DiskCacheStorage) remove(key string) {
+	element, exists := s.items[key]
+	if !exists {
+		return
+	}
+	item := element.Value.(*diskCacheItem)
+	os.Remove(item.file)
+	s.order.Remove(element)
+	delete(s.items, key)
+	s.size -= item.size
+}
+
+// evict removes the least recently used entries until the storage fits into
+// maxBytes again.
+func (s *DiskCacheStorage) evict() {
+	for s.size > s.maxBytes && s.order.Len() > 0 {
+		s.remove(s.order.Back().Value.(*diskCacheItem).key)
+	}
+}
@@ -0,0 +1,184 @@
+package proxy
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testCacheEntry(body string) *CacheEntry {
+	return &CacheEntry{Variants: []*CachedResponse{{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"text/plain"}},
+		Body:       []byte(body),
+	}}}
+}
+
+// entrySize returns the size the storage accounts for one test entry.
+func entrySize(t *testing.T, newStorage func(t *testing.T, maxBytes int64) CacheStorage) int64 {
+	t.Helper()
+	switch s := newStorage(t, 1<<20).(type) {
+	case *MemoryCacheStorage:
+		s.Set("k0", testCacheEntry("body"))
+		return s.size
+	case *DiskCacheStorage:
+		s.Set("k0", testCacheEntry("body"))
+		return s.size
+	}
+	t.Fatal("unknown storage")
+	return 0
+}
+
+func TestCacheStorage(t *testing.T) {
+	storages := map[string]func(t *testing.T, maxBytes int64) CacheStorage{
+		"memory": func(t *testing.T, maxBytes int64) CacheStorage {
+			return NewMemoryCacheStorage(maxBytes)
+		},
+		"disk": func(t *testing.T, maxBytes int64) CacheStorage {
+			s, err := NewDiskCacheStorage(t.TempDir(), maxBytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return s
+		},
+	}
+
+	for name, newStorage := range storages {
+		t.Run(name, func(t *testing.T) {
+			t.Run("set, get and delete", func(t *testing.T) {
+				s := newStorage(t, 1<<20)
+				if _, exists := s.Get("k1"); exists {
+					t.Fatal("empty storage returned an entry")
+				}
+				s.Set("k1", testCacheEntry("one"))
+				s.Set("k1", testCacheEntry("two"))
+				entry, exists := s.Get("k1")
+				if !exists || string(entry.Variants[0].Body) != "two" || entry.Variants[0].Header.Get("Content-Type") != "text/plain" {
+					t.Fatalf("Get() = %+v, %v", entry, exists)
+				}
+				if keys := s.Keys(); !slices.Equal(keys, []string{"k1"}) {
+					t.Errorf("Keys() = %v", keys)
+				}
+				s.Delete("k1")
+				if _, exists := s.Get("k1"); exists || len(s.Keys()) != 0 {
+					t.Error("entry not deleted")
+				}
+			})
+
+			t.Run("evicts least recently used", func(t *testing.T) {
+				size := entrySize(t, newStorage)
+				s := newStorage(t, 3*size+size/2)
+				s.Set("k1", testCacheEntry("body"))
+				s.Set("k2", testCacheEntry("body"))
+				s.Set("k3", testCacheEntry("body"))
+				s.Get("k1")
+				s.Set("k4", testCacheEntry("body"))
+				keys := s.Keys()
+				slices.Sort(keys)
+				if want := []string{"k1", "k3", "k4"}; !slices.Equal(keys, want) {
+					t.Errorf("Keys() = %v, want %v", keys, want)
+				}
+
+				s.Get("k3")
+				s.Set("k5", testCacheEntry("body"))
+				keys = s.Keys()
+				slices.Sort(keys)
+				if want := []string{"k3", "k4", "k5"}; !slices.Equal(keys, want) {
+					t.Errorf("Keys() = %v, want %v", keys, want)
+				}
+			})
+
+			t.Run("concurrent access", func(t *testing.T) {
+				size := entrySize(t, newStorage)
+				s := newStorage(t, 4*size)
+				var wg sync.WaitGroup
+				for i := 0; i < 8; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						for j := 0; j < 50; j++ {
+							key := "k" + strconv.Itoa((i+j)%6)
+							s.Set(key, testCacheEntry("body"))
+							if entry, exists := s.Get(key); exists && string(entry.Variants[0].Body) != "body" {
+								t.Errorf("Get(%q) = %q", key, entry.Variants[0].Body)
+							}
+							if j%7 == 0 {
+								s.Delete(key)
+							}
+						}
+					}()
+				}
+				wg.Wait()
+				if keys := s.Keys(); len(keys) > 4 {
+					t.Errorf("%d entries stored, at most 4 fit", len(keys))
+				}
+			})
+		})
+	}
+}
+
+func TestDiskCacheStorageReopen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewDiskCacheStorage(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Set("k1", testCacheEntry("one"))
+	s.Set("k2", testCacheEntry("two"))
+	s.Set("k3", testCacheEntry("three"))
+	size := s.items["k1"].Value.(*diskCacheItem).size
+
+	// The oldest file is evicted first after a restart.
+	for i, key := range []string{"k2", "k1", "k3"} {
+		modTime := time.Now().Add(time.Duration(i-3) * time.Hour)
+		if err := os.Chtimes(s.items[key].Value.(*diskCacheItem).file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "broken.cache"), []byte("no key line"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewDiskCacheStorage(dir, 2*size+size/2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := reopened.Keys()
+	slices.Sort(keys)
+	if want := []string{"k1", "k3"}; !slices.Equal(keys, want) {
+		t.Errorf("Keys() = %v, want %v", keys, want)
+	}
+	if entry, exists := reopened.Get("k3"); !exists || string(entry.Variants[0].Body) != "three" {
+		t.Errorf("Get() = %+v, %v", entry, exists)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "broken.cache")); !os.IsNotExist(err) {
+		t.Error("unreadable cache file not removed")
+	}
+}
+
+func TestDiskCacheStorageCorruptFile(t *testing.T) {
+	s, err := NewDiskCacheStorage(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Set("k1", testCacheEntry("one"))
+	file := s.items["k1"].Value.(*diskCacheItem).file
+	if err := os.WriteFile(file, []byte("k1\ngarbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, exists := s.Get("k1"); exists {
+		t.Fatal("corrupt entry returned")
+	}
+	if len(s.Keys()) != 0 || s.size != 0 {
+		t.Errorf("corrupt entry kept: keys %v, size %d", s.Keys(), s.size)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Error("corrupt cache file not removed")
+	}
+}
@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"log"
+	"sync"
+	"time"
+)
+
+// siteCertificateTTL bounds how long a loaded site certificate is reused, so
+// renewed certificates are served without a restart.
+const siteCertificateTTL = time.Minute
+
+type siteCertificate struct {
+	cert   *tls.Certificate
+	loaded time.Time
+}
+
+type siteCertificates struct {
+	mu    sync.Mutex
+	certs map[string]siteCertificate
+}
+
+func newSiteCertificates() *siteCertificates {
+	return &siteCertificates{certs: make(map[string]siteCertificate)}
+}
+
+// getCertificate selects the certificate of the website named by SNI. ACME
+// certificates are preferred over self-signed ones. Unknown hosts and
+// websites without SSL get the certificate of the listener, so clients cannot
+// make the proxy issue certificates for arbitrary names.
+func (rp *ReverseProxy) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	host := hello.ServerName
+	config, exists := rp.configCache.Get(host)
+	if !exists || !config.SSL {
+		return nil, nil
+	}
+
+	now := time.Now()
+	rp.siteCerts.mu.Lock()
+	cached, exists := rp.siteCerts.certs[host]
+	rp.siteCerts.mu.Unlock()
+	if exists && now.Sub(cached.loaded) < siteCertificateTTL {
+		return cached.cert, nil
+	}
+
+	providerType := "self"
+	if rp.certManager.ValidateCertificate(host, "acme") {
+		providerType = "acme"
+	}
+	cert, err := rp.certManager.GetCertificate(host, providerType, config.Email)
+	if err != nil {
+		log.Printf("Error loading %s certificate for %s: %v", providerType, host, err)
+		return nil, nil
+	}
+
+	rp.siteCerts.mu.Lock()
+	defer rp.siteCerts.mu.Unlock()
+	rp.siteCerts.certs[host] = siteCertificate{cert: cert, loaded: now}
+	return cert, nil
+}
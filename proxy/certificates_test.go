@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/quic-go/quic-go/http3"
+	"github.com/secnex/reverse-proxy/config"
+	"github.com/secnex/reverse-proxy/models"
+)
+
+func TestTLSConfigSelectsCertificateBySNI(t *testing.T) {
+	store := &fakeStore{websites: []models.Website{
+		{Domain: "a.example", SSL: true},
+		{Domain: "b.example", SSL: true},
+		{Domain: "plain.example"},
+	}}
+	rp := newTestProxy(t, store, func(w http.ResponseWriter, r *http.Request) {})
+
+	listener := config.Listener{CertFile: filepath.Join(t.TempDir(), "missing.crt"), KeyFile: filepath.Join(t.TempDir(), "missing.key")}
+	tlsConfig, err := rp.tlsConfig(listener)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quicConfig := http3.ConfigureTLSConfig(tlsConfig)
+
+	tests := []struct {
+		serverName string
+		want       string
+	}{
+		{"a.example", "a.example"},
+		{"b.example", "b.example"},
+		{"plain.example", "localhost"},
+		{"unknown.example", "localhost"},
+		{"", "localhost"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.serverName, func(t *testing.T) {
+			if got := handshake(t, tlsConfig, tt.serverName); got != tt.want {
+				t.Errorf("TCP certificate for %q = %q, want %q", tt.serverName, got, tt.want)
+			}
+			if got := handshake(t, quicConfig, tt.serverName); got != tt.want {
+				t.Errorf("QUIC certificate for %q = %q, want %q", tt.serverName, got, tt.want)
+			}
+		})
+	}
+
+	if rp.certManager.ValidateCertificate("unknown.example", "self") {
+		t.Error("certificate issued for an unknown host")
+	}
+}
+
+// handshake connects to a TLS server with the configuration and returns the
+// common name of the certificate it presents.
+func handshake(t *testing.T, serverConfig *tls.Config, serverName string) string {
+	t.Helper()
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	go func() {
+		defer serverConn.Close()
+		tls.Server(serverConn, serverConfig).Handshake()
+	}()
+
+	var certificate *x509.Certificate
+	client := tls.Client(clientConn, &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			certificate = state.PeerCertificates[0]
+			return nil
+		},
+	})
+	if err := client.Handshake(); err != nil {
+		t.Fatalf("handshake for %q: %v", serverName, err)
+	}
+	return certificate.Subject.CommonName
+}
@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// parseNetworks parses IPs and CIDRs, invalid entries are logged and skipped.
+func parseNetworks(entries []string, kind string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Printf("Ignoring invalid %s %q: %v", kind, entry, err)
+			continue
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+func (rp *ReverseProxy) isTrusted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range rp.trustedProxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP resolves the client address. X-Forwarded-For is only evaluated for
+// trusted proxies, from right to left up to the first untrusted address.
+func (rp *ReverseProxy) clientIP(r *http.Request) string {
+	ip := remoteIP(r)
+	if !rp.isTrusted(ip) {
+		return ip
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		candidate := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(candidate) == nil {
+			break
+		}
+		ip = candidate
+		if !rp.isTrusted(candidate) {
+			break
+		}
+	}
+	return ip
+}
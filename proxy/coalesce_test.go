@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/secnex/reverse-proxy/models"
+)
+
+func TestCoalescing(t *testing.T) {
+	const clients = 5
+	tests := []struct {
+		name         string
+		coalesce     bool
+		cacheControl string
+		requests     int32
+	}{
+		{"coalesced", true, "max-age=60", 1},
+		{"not storable", true, "no-store", clients},
+		{"disabled", false, "max-age=60", clients},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			entered := make(chan struct{}, clients)
+			release := make(chan struct{})
+			store := &fakeStore{websites: []models.Website{{Domain: "a.example", Cache: true, Coalesce: tt.coalesce, CoalesceTimeout: 10}}}
+			rp := newTestProxy(t, store, func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				entered <- struct{}{}
+				<-release
+				w.Header().Set("Cache-Control", tt.cacheControl)
+				w.Write([]byte("body"))
+			})
+			rp.SetCache(NewResponseCache(NewMemoryCacheStorage(1<<20), 1<<20))
+
+			var wg sync.WaitGroup
+			responses := make([]*httptest.ResponseRecorder, clients)
+			for i := range responses {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					responses[i] = serve(rp, httptest.NewRequest(http.MethodGet, "http://a.example/page", nil))
+				}()
+			}
+
+			// Without coalescing every client reaches the upstream, with it
+			// only the first one until the response has been stored.
+			<-entered
+			if !tt.coalesce {
+				for i := 1; i < clients; i++ {
+					<-entered
+				}
+			}
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+			wg.Wait()
+
+			if got := requests.Load(); got != tt.requests {
+				t.Errorf("%d upstream requests, want %d", got, tt.requests)
+			}
+			for i, w := range responses {
+				if w.Code != http.StatusOK || w.Body.String() != "body" {
+					t.Errorf("client %d: %d %q", i, w.Code, w.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestCoalescingClientGone(t *testing.T) {
+	release := make(chan struct{})
+	entered := make(chan struct{}, 1)
+	store := &fakeStore{websites: []models.Website{{Domain: "a.example", Cache: true, Coalesce: true, CoalesceTimeout: 10}}}
+	rp := newTestProxy(t, store, func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+		w.Header().Set("Cache-Control", "max-age=60")
+	})
+	rp.SetCache(NewResponseCache(NewMemoryCacheStorage(1<<20), 1<<20))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		serve(rp, httptest.NewRequest(http.MethodGet, "http://a.example/page", nil))
+	}()
+	defer func() {
+		close(release)
+		<-done
+	}()
+	<-entered
+
+	config, _ := rp.configCache.Get("a.example")
+	_, flight := rp.cache.join(cacheKey("a.example", httptest.NewRequest(http.MethodGet, "http://a.example/page", nil)))
+	if flight == nil {
+		t.Fatal("no flight for the leading request")
+	}
+	r := httptest.NewRequest(http.MethodGet, "http://a.example/page", nil)
+	ctx, cancel := context.WithCancel(r.Context())
+	cancel()
+	if rp.awaitFlight(r.WithContext(ctx), config, flight) {
+		t.Error("awaitFlight() = true for a client that went away")
+	}
+}
@@ -0,0 +1,179 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+const defaultCompressionMinSize = 1024
+
+var defaultCompressionTypes = []string{
+	"text/html",
+	"text/css",
+	"text/plain",
+	"text/xml",
+	"text/javascript",
+	"application/javascript",
+	"application/json",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// compressionEncodings in order of preference when the client accepts
+// several encodings with the same quality.
+var compressionEncodings = []string{"br", "zstd", "gzip"}
+
+// compressionEncoding returns the encoding the response should be compressed
+// with, or an empty string. Compressible responses vary on Accept-Encoding
+// even if this client does not accept any of the encodings.
+func compressionEncoding(w http.ResponseWriter, r *http.Request, resp *http.Response, config ProxyConfig) string {
+	if !config.Compression || r.Method == http.MethodHead {
+		return ""
+	}
+	if resp.StatusCode < 200 || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusPartialContent || resp.StatusCode >= 300 {
+		return ""
+	}
+	if encoding := resp.Header.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		return ""
+	}
+	if headerHasToken(resp.Header, "Cache-Control", "no-transform") {
+		return ""
+	}
+	if !compressibleType(resp.Header.Get("Content-Type"), config.CompressionTypes) {
+		return ""
+	}
+
+	minSize := int64(config.CompressionMinSize)
+	if minSize <= 0 {
+		minSize = defaultCompressionMinSize
+	}
+	if resp.ContentLength >= 0 && resp.ContentLength < minSize {
+		return ""
+	}
+
+	addVary(w.Header(), "Accept-Encoding")
+	return negotiateEncoding(r.Header.Get("Accept-Encoding"))
+}
+
+func compressibleType(contentType string, allowed []string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if len(allowed) == 0 {
+		allowed = defaultCompressionTypes
+	}
+	for _, pattern := range allowed {
+		if pattern == mediaType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok && strings.HasPrefix(mediaType, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateEncoding picks the supported encoding with the highest quality in
+// the Accept-Encoding header.
+func negotiateEncoding(acceptEncoding string) string {
+	qualities := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if q, err := strconv.ParseFloat(value, 64); err == nil {
+				quality = q
+			}
+		}
+		qualities[name] = quality
+	}
+
+	best, bestQuality := "", 0.0
+	for _, encoding := range compressionEncodings {
+		quality, exists := qualities[encoding]
+		if !exists {
+			quality, exists = qualities["*"]
+		}
+		if exists && quality > bestQuality {
+			best, bestQuality = encoding, quality
+		}
+	}
+	return best
+}
+
+func addVary(header http.Header, name string) {
+	for _, value := range header.Values("Vary") {
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item == "*" || strings.EqualFold(item, name) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", name)
+}
+
+// writeCompressed writes the response body compressed with the encoding. The
+// ETag becomes weak since the representation changes. If no encoder can be
+// created, nothing is written and false is returned.
+func writeCompressed(w http.ResponseWriter, resp *http.Response, encoding string, level int) bool {
+	encoder, err := newEncoder(w, encoding, level)
+	if err != nil {
+		log.Printf("Error creating %s encoder: %v", encoding, err)
+		return false
+	}
+
+	header := w.Header()
+	header.Del("Content-Length")
+	header.Del("Accept-Ranges")
+	header.Set("Content-Encoding", encoding)
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		header.Set("ETag", "W/"+etag)
+	}
+
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(encoder, resp.Body)
+	encoder.Close()
+	return true
+}
+
+// newEncoder creates a compressor for the encoding. Level 0 selects the
+// default level of the algorithm, other levels are clamped to its range.
+func newEncoder(w io.Writer, encoding string, level int) (io.WriteCloser, error) {
+	switch encoding {
+	case "br":
+		if level == 0 {
+			level = brotli.DefaultCompression
+		}
+		return brotli.NewWriterLevel(w, min(max(level, brotli.BestSpeed), brotli.BestCompression)), nil
+	case "zstd":
+		zstdLevel := zstd.SpeedDefault
+		if level != 0 {
+			zstdLevel = zstd.EncoderLevelFromZstd(level)
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstdLevel), zstd.WithEncoderConcurrency(1))
+	case "gzip":
+		if level == 0 {
+			level = gzip.DefaultCompression
+		} else {
+			level = min(max(level, gzip.BestSpeed), gzip.BestCompression)
+		}
+		return gzip.NewWriterLevel(w, level)
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q", encoding)
+	}
+}
@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", "gzip"},
+		{"gzip, deflate, br", "br"},
+		{"gzip, zstd", "zstd"},
+		{"br;q=0.5, gzip", "gzip"},
+		{"BR", "br"},
+		{"*", "br"},
+		{"*;q=0.5, gzip", "gzip"},
+		{"br;q=0, *", "zstd"},
+		{"gzip;q=0", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			if got := negotiateEncoding(tt.acceptEncoding); got != tt.want {
+				t.Errorf("negotiateEncoding(%q) = %q, want %q", tt.acceptEncoding, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompressibleType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		allowed     []string
+		want        bool
+	}{
+		{"text/html; charset=utf-8", nil, true},
+		{"application/json", nil, true},
+		{"image/png", nil, false},
+		{"", nil, false},
+		{"text/csv", []string{"text/*"}, true},
+		{"application/json", []string{"text/*"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			if got := compressibleType(tt.contentType, tt.allowed); got != tt.want {
+				t.Errorf("compressibleType(%q, %v) = %v, want %v", tt.contentType, tt.allowed, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteCompressed(t *testing.T) {
+	body := strings.Repeat("compressible ", 200)
+	tests := []struct {
+		encoding string
+		level    int
+		decode   func(io.Reader) (io.Reader, error)
+	}{
+		{"gzip", 0, func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+		{"gzip", 100, func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+		{"br", 0, func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil }},
+		{"br", -5, func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil }},
+		{"zstd", 0, func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) }},
+		{"zstd", 22, func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.encoding+"/"+strconv.Itoa(tt.level), func(t *testing.T) {
+			w := httptest.NewRecorder()
+			w.Header().Set("Content-Length", "2600")
+			w.Header().Set("ETag", `"v1"`)
+			resp := &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body))}
+
+			if !writeCompressed(w, resp, tt.encoding, tt.level) {
+				t.Fatal("writeCompressed() = false")
+			}
+			if w.Header().Get("Content-Encoding") != tt.encoding || w.Header().Get("Content-Length") != "" || w.Header().Get("ETag") != `W/"v1"` {
+				t.Errorf("header = %v", w.Header())
+			}
+			reader, err := tt.decode(w.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := io.ReadAll(reader)
+			if err != nil || string(decoded) != body {
+				t.Errorf("decoded body = %q, %v", decoded, err)
+			}
+		})
+	}
+}
+
+func TestWriteCompressedUnsupportedEncoding(t *testing.T) {
+	w := httptest.NewRecorder()
+	w.Header().Set("Content-Length", "4")
+	resp := &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("body"))}
+
+	if writeCompressed(w, resp, "compress", 0) {
+		t.Fatal("writeCompressed() = true")
+	}
+	if w.Header().Get("Content-Encoding") != "" || w.Header().Get("Content-Length") != "4" {
+		t.Errorf("header changed: %v", w.Header())
+	}
+	if w.Body.Len() != 0 || w.Flushed {
+		t.Errorf("response written: %q", w.Body.String())
+	}
+}
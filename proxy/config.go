@@ -2,24 +2,173 @@ package proxy
 
 import (
 	"log"
+	"net"
 	"sync"
+	"time"
 
 	"github.com/secnex/reverse-proxy/cert"
+	"github.com/secnex/reverse-proxy/metrics"
+	"github.com/secnex/reverse-proxy/models"
 )
 
 type ProxyConfig struct {
-	Protocol string
-	Host     string
-	Port     int
-	SSL      bool
-	Email    string
+	Protocol              string
+	Host                  string
+	Port                  int
+	Upstreams             []string
+	Affinity              string
+	AffinityCookie        string
+	UpstreamTimeout       time.Duration
+	MaxBodySize           int64
+	MaxHeaderSize         int
+	SSL                   bool
+	HTTPSPolicy           string
+	HTTPSRedirectStatus   int
+	HSTSMaxAge            int
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+	AccessLogDisabled     bool
+	AccessLogSampleRate   float64
+	TraceSampleRate       *float64
+	RateLimits            []models.RateLimitRule
+	AllowCIDRs            []string
+	DenyCIDRs             []string
+	AllowCountries        []string
+	DenyCountries         []string
+	AuthMode              string
+	AuthRealm             string
+	ForwardAuthURL        string
+	ForwardAuthHeaders    []string
+	OIDCIssuer            string
+	OIDCClientID          string
+	OIDCClientSecret      string
+	OIDCScopes            []string
+	OIDCAllowRules        []models.OIDCAllowRule
+	WAFMode               string
+	WAFDisabledRules      []string
+	Compression           bool
+	CompressionTypes      []string
+	CompressionMinSize    int
+	CompressionLevel      int
+	Cache                 bool
+	Coalesce              bool
+	CoalesceTimeout       time.Duration
+	Type                  string
+	StaticRoot            string
+	StaticIndexFiles      []string
+	StaticSPA             bool
+	StaticListing         bool
+	StaticCacheControl    string
+	RedirectTarget        string
+	RedirectStatus        int
+	RedirectPreservePath  bool
+	RedirectPreserveQuery bool
+	InterceptErrors       bool
+	HTTP3                 bool
+	Email                 string
+	allowNetworks         []*net.IPNet
+	denyNetworks          []*net.IPNet
+	// basicAuthUsers maps usernames to bcrypt hashes.
+	basicAuthUsers map[string]string
+	headerRules    []models.HeaderRule
+	redirectRules  []redirectRule
+	errorTemplates map[errorTemplateKey]errorTemplate
+	maintenance    []maintenanceWindow
+}
+
+func newProxyConfig(website models.Website) ProxyConfig {
+	return ProxyConfig{
+		Protocol:              website.Protocol,
+		Host:                  website.Host,
+		Port:                  website.Port,
+		Upstreams:             website.Upstreams,
+		Affinity:              website.Affinity,
+		AffinityCookie:        website.AffinityCookie,
+		UpstreamTimeout:       time.Duration(website.UpstreamTimeout) * time.Second,
+		MaxBodySize:           website.MaxBodySize,
+		MaxHeaderSize:         website.MaxHeaderSize,
+		SSL:                   website.SSL,
+		HTTPSPolicy:           website.HTTPSPolicy,
+		HTTPSRedirectStatus:   website.HTTPSRedirectStatus,
+		HSTSMaxAge:            website.HSTSMaxAge,
+		HSTSIncludeSubdomains: website.HSTSIncludeSubdomains,
+		HSTSPreload:           website.HSTSPreload,
+		AccessLogDisabled:     website.AccessLogDisabled,
+		AccessLogSampleRate:   website.AccessLogSampleRate,
+		TraceSampleRate:       website.TraceSampleRate,
+		RateLimits:            website.RateLimits,
+		AllowCIDRs:            website.AllowCIDRs,
+		DenyCIDRs:             website.DenyCIDRs,
+		AllowCountries:        website.AllowCountries,
+		DenyCountries:         website.DenyCountries,
+		AuthMode:              website.AuthMode,
+		AuthRealm:             website.AuthRealm,
+		ForwardAuthURL:        website.ForwardAuthURL,
+		ForwardAuthHeaders:    website.ForwardAuthHeaders,
+		OIDCIssuer:            website.OIDCIssuer,
+		OIDCClientID:          website.OIDCClientID,
+		OIDCClientSecret:      website.OIDCClientSecret,
+		OIDCScopes:            website.OIDCScopes,
+		OIDCAllowRules:        website.OIDCAllowRules,
+		WAFMode:               website.WAFMode,
+		WAFDisabledRules:      website.WAFDisabledRules,
+		Compression:           website.Compression,
+		CompressionTypes:      website.CompressionTypes,
+		CompressionMinSize:    website.CompressionMinSize,
+		CompressionLevel:      website.CompressionLevel,
+		Cache:                 website.Cache,
+		Coalesce:              website.Coalesce,
+		CoalesceTimeout:       time.Duration(website.CoalesceTimeout) * time.Second,
+		Type:                  website.Type,
+		StaticRoot:            website.StaticRoot,
+		StaticIndexFiles:      website.StaticIndexFiles,
+		StaticSPA:             website.StaticSPA,
+		StaticListing:         website.StaticListing,
+		StaticCacheControl:    website.StaticCacheControl,
+		RedirectTarget:        website.RedirectTarget,
+		RedirectStatus:        website.RedirectStatus,
+		RedirectPreservePath:  website.RedirectPreservePath,
+		RedirectPreserveQuery: website.RedirectPreserveQuery,
+		InterceptErrors:       website.InterceptErrors,
+		HTTP3:                 website.HTTP3,
+		Email:                 website.Email,
+		allowNetworks:         parseNetworks(website.AllowCIDRs, "allowed network"),
+		denyNetworks:          parseNetworks(website.DenyCIDRs, "denied network"),
+	}
+}
+
+// Targets returns the primary upstream followed by all additional upstreams.
+func (c ProxyConfig) Targets() []Target {
+	targets := []Target{{Protocol: c.Protocol, Host: c.Host, Port: c.Port}}
+	for _, upstream := range c.Upstreams {
+		target, err := ParseTarget(upstream)
+		if err != nil {
+			log.Printf("Ignoring invalid upstream %q: %v", upstream, err)
+			continue
+		}
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+// configStore is the part of DBManager the configuration is loaded from.
+type configStore interface {
+	GetAllWebsites() ([]models.Website, error)
+	GetAllBasicAuthUsers() ([]models.BasicAuthUser, error)
+	GetAllHeaderRules() ([]models.HeaderRule, error)
+	GetAllRedirectRules() ([]models.RedirectRule, error)
+	GetAllErrorTemplates() ([]models.ErrorTemplate, error)
+	GetAllMaintenanceWindows() ([]models.MaintenanceWindow, error)
 }
 
 type ConfigCache struct {
 	configs     map[string]ProxyConfig
 	mu          sync.RWMutex
-	db          *DBManager
+	db          configStore
 	certManager *cert.CertManager
+	metrics     *metrics.Metrics
+	// countryRules is set if a GeoIP database is configured.
+	countryRules bool
 }
 
 func NewConfigCache(db *DBManager, certManager *cert.CertManager) *ConfigCache {
@@ -30,6 +179,22 @@ func NewConfigCache(db *DBManager, certManager *cert.CertManager) *ConfigCache {
 	}
 }
 
+func (cc *ConfigCache) SetMetrics(m *metrics.Metrics) {
+	cc.metrics = m
+}
+
+// SetCountryRulesEnabled tells the cache whether country rules can be
+// evaluated, so rules that cannot are reported on every reload.
+func (cc *ConfigCache) SetCountryRulesEnabled(enabled bool) {
+	cc.countryRules = enabled
+}
+
+func (cc *ConfigCache) Len() int {
+	cc.mu.RLock()
+	defer cc.mu.RUnlock()
+	return len(cc.configs)
+}
+
 func (cc *ConfigCache) Get(host string) (ProxyConfig, bool) {
 	cc.mu.RLock()
 	defer cc.mu.RUnlock()
@@ -71,24 +236,93 @@ func (cc *ConfigCache) Update(newConfigs map[string]ProxyConfig) {
 	cc.configs = newConfigs
 }
 
-func (cc *ConfigCache) LoadFromDB() error {
+func (cc *ConfigCache) LoadFromDB() (err error) {
+	start := time.Now()
+	defer func() {
+		cc.metrics.ObserveReload(time.Since(start), err)
+	}()
+
 	websites, err := cc.db.GetAllWebsites()
 	if err != nil {
 		return err
 	}
 
+	users, err := cc.db.GetAllBasicAuthUsers()
+	if err != nil {
+		return err
+	}
+	usersByDomain := make(map[string]map[string]string)
+	for _, user := range users {
+		if usersByDomain[user.Domain] == nil {
+			usersByDomain[user.Domain] = make(map[string]string)
+		}
+		usersByDomain[user.Domain][user.Username] = user.PasswordHash
+	}
+
+	headerRules, err := cc.db.GetAllHeaderRules()
+	if err != nil {
+		return err
+	}
+	headerRulesByDomain := make(map[string][]models.HeaderRule)
+	for _, rule := range headerRules {
+		headerRulesByDomain[rule.Domain] = append(headerRulesByDomain[rule.Domain], rule)
+	}
+
+	redirectRules, err := cc.db.GetAllRedirectRules()
+	if err != nil {
+		return err
+	}
+	redirectRulesByDomain := make(map[string][]redirectRule)
+	for _, rule := range redirectRules {
+		compiled, err := newRedirectRule(rule)
+		if err != nil {
+			log.Printf("Ignoring redirect rule %d for %s: %v", rule.ID, rule.Domain, err)
+			continue
+		}
+		redirectRulesByDomain[rule.Domain] = append(redirectRulesByDomain[rule.Domain], compiled)
+	}
+
+	errorTemplates, err := cc.db.GetAllErrorTemplates()
+	if err != nil {
+		return err
+	}
+	errorTemplatesByDomain := make(map[string]map[errorTemplateKey]errorTemplate)
+	for _, tmpl := range errorTemplates {
+		compiled, err := parseErrorTemplate(tmpl)
+		if err != nil {
+			log.Printf("Ignoring error template %d for %s: %v", tmpl.ID, tmpl.Domain, err)
+			continue
+		}
+		if errorTemplatesByDomain[tmpl.Domain] == nil {
+			errorTemplatesByDomain[tmpl.Domain] = make(map[errorTemplateKey]errorTemplate)
+		}
+		errorTemplatesByDomain[tmpl.Domain][errorTemplateKey{status: tmpl.Status, format: tmpl.Format}] = compiled
+	}
+
+	maintenanceWindows, err := cc.db.GetAllMaintenanceWindows()
+	if err != nil {
+		return err
+	}
+	maintenanceByDomain := make(map[string][]maintenanceWindow)
+	for _, window := range maintenanceWindows {
+		maintenanceByDomain[window.Domain] = append(maintenanceByDomain[window.Domain], newMaintenanceWindow(window))
+	}
+
 	cc.mu.Lock()
 	defer cc.mu.Unlock()
 	cc.configs = make(map[string]ProxyConfig)
 	for _, website := range websites {
 		if website.Active {
-			cc.configs[website.Domain] = ProxyConfig{
-				Protocol: website.Protocol,
-				Host:     website.Host,
-				Port:     website.Port,
-				SSL:      website.SSL,
-				Email:    website.Email,
+			if !cc.countryRules && (len(website.AllowCountries) > 0 || len(website.DenyCountries) > 0) {
+				log.Printf("Country rules of %s never match without a GeoIP database", website.Domain)
 			}
+			config := newProxyConfig(website)
+			config.basicAuthUsers = usersByDomain[website.Domain]
+			config.headerRules = headerRulesByDomain[website.Domain]
+			config.redirectRules = redirectRulesByDomain[website.Domain]
+			config.errorTemplates = errorTemplatesByDomain[website.Domain]
+			config.maintenance = maintenanceByDomain[website.Domain]
+			cc.configs[website.Domain] = config
 		}
 	}
 	return nil
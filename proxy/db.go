@@ -1,11 +1,15 @@
 package proxy
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"os"
+	"strconv"
 	"time"
 
+	"github.com/secnex/reverse-proxy/audit"
+	"github.com/secnex/reverse-proxy/config"
 	"github.com/secnex/reverse-proxy/models"
 
 	"gorm.io/driver/postgres"
@@ -16,37 +20,15 @@ type DBManager struct {
 	db *gorm.DB
 }
 
-func NewDBManager() (*DBManager, error) {
-	host := os.Getenv("DB_HOST")
-	if host == "" {
-		host = "localhost"
-	}
-	port := os.Getenv("DB_PORT")
-	if port == "" {
-		port = "5432"
-	}
-	user := os.Getenv("DB_USER")
-	if user == "" {
-		user = "postgres"
-	}
-	password := os.Getenv("DB_PASSWORD")
-	if password == "" {
-		password = "postgres"
-	}
-	dbname := os.Getenv("DB_NAME")
-	if dbname == "" {
-		dbname = "secnex"
-	}
-	dbsslmode := os.Getenv("DB_SSLMODE")
-	if dbsslmode == "" {
-		dbsslmode = "disable"
-	} else if dbsslmode == "true" {
-		dbsslmode = "enable"
-	} else if dbsslmode == "false" {
-		dbsslmode = "disable"
-	}
+func NewDBManager(cfg config.DatabaseConfig) (*DBManager, error) {
+	host := cfg.Host
+	port := cfg.Port
+	user := cfg.User
+	password := cfg.Password
+	dbname := cfg.Name
+	dbsslmode := cfg.SSLMode
 
-	if os.Getenv("DB_RESET") == "true" {
+	if cfg.Reset {
 		log.Printf("Connecting to database %s:%s/postgres...", host, port)
 		postgresDSN := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=postgres sslmode=%s",
 			host, port, user, password, dbsslmode)
@@ -83,7 +65,7 @@ func NewDBManager() (*DBManager, error) {
 
 	log.Println("Migrating database...")
 
-	err = db.AutoMigrate(&models.Website{})
+	err = db.AutoMigrate(&models.Website{}, &models.APIToken{}, &models.AuditEntry{}, &models.BasicAuthUser{}, &models.HeaderRule{}, &models.RedirectRule{}, &models.ErrorTemplate{}, &models.MaintenanceWindow{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to migrate database: %v", err)
 	}
@@ -111,33 +93,583 @@ func (dm *DBManager) GetAllWebsites() ([]models.Website, error) {
 	return websites, nil
 }
 
-func (dm *DBManager) CreateWebsite(config models.WebsiteConfig) error {
+func (dm *DBManager) CreateWebsite(actor string, config models.WebsiteConfig) error {
 	website := models.Website{
-		Domain:   config.Domain,
-		Protocol: config.Protocol,
-		Host:     config.Host,
-		Port:     config.Port,
-		SSL:      config.SSL,
-		Active:   config.Active,
-		LastSeen: time.Now(),
-	}
-	result := dm.db.Create(&website)
-	return result.Error
+		Domain:                config.Domain,
+		Protocol:              config.Protocol,
+		Host:                  config.Host,
+		Port:                  config.Port,
+		Upstreams:             config.Upstreams,
+		Affinity:              config.Affinity,
+		AffinityCookie:        config.AffinityCookie,
+		UpstreamTimeout:       config.UpstreamTimeout,
+		MaxBodySize:           config.MaxBodySize,
+		MaxHeaderSize:         config.MaxHeaderSize,
+		SSL:                   config.SSL,
+		HTTPSPolicy:           config.HTTPSPolicy,
+		HTTPSRedirectStatus:   config.HTTPSRedirectStatus,
+		HSTSMaxAge:            config.HSTSMaxAge,
+		HSTSIncludeSubdomains: config.HSTSIncludeSubdomains,
+		HSTSPreload:           config.HSTSPreload,
+		AccessLogDisabled:     config.AccessLogDisabled,
+		AccessLogSampleRate:   config.AccessLogSampleRate,
+		TraceSampleRate:       config.TraceSampleRate,
+		RateLimits:            config.RateLimits,
+		AllowCIDRs:            config.AllowCIDRs,
+		DenyCIDRs:             config.DenyCIDRs,
+		AllowCountries:        config.AllowCountries,
+		DenyCountries:         config.DenyCountries,
+		AuthMode:              config.AuthMode,
+		AuthRealm:             config.AuthRealm,
+		ForwardAuthURL:        config.ForwardAuthURL,
+		ForwardAuthHeaders:    config.ForwardAuthHeaders,
+		OIDCIssuer:            config.OIDCIssuer,
+		OIDCClientID:          config.OIDCClientID,
+		OIDCClientSecret:      config.OIDCClientSecret,
+		OIDCScopes:            config.OIDCScopes,
+		OIDCAllowRules:        config.OIDCAllowRules,
+		WAFMode:               config.WAFMode,
+		WAFDisabledRules:      config.WAFDisabledRules,
+		Compression:           config.Compression,
+		CompressionTypes:      config.CompressionTypes,
+		CompressionMinSize:    config.CompressionMinSize,
+		CompressionLevel:      config.CompressionLevel,
+		Cache:                 config.Cache,
+		Coalesce:              config.Coalesce,
+		CoalesceTimeout:       config.CoalesceTimeout,
+		Type:                  config.Type,
+		StaticRoot:            config.StaticRoot,
+		StaticIndexFiles:      config.StaticIndexFiles,
+		StaticSPA:             config.StaticSPA,
+		StaticListing:         config.StaticListing,
+		StaticCacheControl:    config.StaticCacheControl,
+		RedirectTarget:        config.RedirectTarget,
+		RedirectStatus:        config.RedirectStatus,
+		RedirectPreservePath:  config.RedirectPreservePath,
+		RedirectPreserveQuery: config.RedirectPreserveQuery,
+		InterceptErrors:       config.InterceptErrors,
+		HTTP3:                 config.HTTP3,
+		Active:                config.Active,
+		LastSeen:              time.Now(),
+	}
+
+	return dm.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&website).Error; err != nil {
+			return err
+		}
+		entry := audit.NewEntry(actor, audit.ActionCreate, audit.ResourceWebsite, website.Domain, nil, website)
+		return tx.Create(&entry).Error
+	})
+}
+
+func (dm *DBManager) UpdateWebsite(actor string, domain string, config models.WebsiteConfig) error {
+	return dm.db.Transaction(func(tx *gorm.DB) error {
+		var before models.Website
+		if err := tx.Where("domain = ?", domain).First(&before).Error; err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{
+			"protocol":                config.Protocol,
+			"host":                    config.Host,
+			"port":                    config.Port,
+			"upstreams":               jsonColumn(config.Upstreams),
+			"affinity":                config.Affinity,
+			"affinity_cookie":         config.AffinityCookie,
+			"upstream_timeout":        config.UpstreamTimeout,
+			"max_body_size":           config.MaxBodySize,
+			"max_header_size":         config.MaxHeaderSize,
+			"ssl":                     config.SSL,
+			"https_policy":            config.HTTPSPolicy,
+			"https_redirect_status":   config.HTTPSRedirectStatus,
+			"hsts_max_age":            config.HSTSMaxAge,
+			"hsts_include_subdomains": config.HSTSIncludeSubdomains,
+			"hsts_preload":            config.HSTSPreload,
+			"access_log_disabled":     config.AccessLogDisabled,
+			"access_log_sample_rate":  config.AccessLogSampleRate,
+			"trace_sample_rate":       config.TraceSampleRate,
+			"rate_limits":             jsonColumn(config.RateLimits),
+			"allow_cidrs":             jsonColumn(config.AllowCIDRs),
+			"deny_cidrs":              jsonColumn(config.DenyCIDRs),
+			"allow_countries":         jsonColumn(config.AllowCountries),
+			"deny_countries":          jsonColumn(config.DenyCountries),
+			"auth_mode":               config.AuthMode,
+			"auth_realm":              config.AuthRealm,
+			"forward_auth_url":        config.ForwardAuthURL,
+			"forward_auth_headers":    jsonColumn(config.ForwardAuthHeaders),
+			"oidc_issuer":             config.OIDCIssuer,
+			"oidc_client_id":          config.OIDCClientID,
+			"oidc_client_secret":      config.OIDCClientSecret,
+			"oidc_scopes":             jsonColumn(config.OIDCScopes),
+			"oidc_allow_rules":        jsonColumn(config.OIDCAllowRules),
+			"waf_mode":                config.WAFMode,
+			"waf_disabled_rules":      jsonColumn(config.WAFDisabledRules),
+			"compression":             config.Compression,
+			"compression_types":       jsonColumn(config.CompressionTypes),
+			"compression_min_size":    config.CompressionMinSize,
+			"compression_level":       config.CompressionLevel,
+			"cache":                   config.Cache,
+			"coalesce":                config.Coalesce,
+			"coalesce_timeout":        config.CoalesceTimeout,
+			"type":                    config.Type,
+			"static_root":             config.StaticRoot,
+			"static_index_files":      jsonColumn(config.StaticIndexFiles),
+			"static_spa":              config.StaticSPA,
+			"static_listing":          config.StaticListing,
+			"static_cache_control":    config.StaticCacheControl,
+			"redirect_target":         config.RedirectTarget,
+			"redirect_status":         config.RedirectStatus,
+			"redirect_preserve_path":  config.RedirectPreservePath,
+			"redirect_preserve_query": config.RedirectPreserveQuery,
+			"intercept_errors":        config.InterceptErrors,
+			"http3":                   config.HTTP3,
+			"active":                  config.Active,
+			"last_seen":               time.Now(),
+		}
+		// The client secret is never returned by the API, an empty value
+		// keeps the stored one.
+		if config.OIDCClientSecret == "" {
+			delete(updates, "oidc_client_secret")
+		}
+
+		result := tx.Model(&models.Website{}).Where("domain = ?", domain).Updates(updates)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		var after models.Website
+		if err := tx.Where("domain = ?", domain).First(&after).Error; err != nil {
+			return err
+		}
+		entry := audit.NewEntry(actor, audit.ActionUpdate, audit.ResourceWebsite, domain, before, after)
+		return tx.Create(&entry).Error
+	})
+}
+
+func (dm *DBManager) DeleteWebsite(actor string, domain string) error {
+	return dm.db.Transaction(func(tx *gorm.DB) error {
+		var before models.Website
+		if err := tx.Where("domain = ?", domain).First(&before).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&before).Error; err != nil {
+			return err
+		}
+		entry := audit.NewEntry(actor, audit.ActionDelete, audit.ResourceWebsite, domain, before, nil)
+		return tx.Create(&entry).Error
+	})
+}
+
+func (dm *DBManager) GetAllBasicAuthUsers() ([]models.BasicAuthUser, error) {
+	var users []models.BasicAuthUser
+	if err := dm.db.Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (dm *DBManager) ListBasicAuthUsers(domain string) ([]models.BasicAuthUser, error) {
+	var users []models.BasicAuthUser
+	if err := dm.db.Where("domain = ?", domain).Order("username").Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// SetBasicAuthUser creates the user or replaces its password. Audit entries
+// never contain the password hash.
+func (dm *DBManager) SetBasicAuthUser(actor string, domain string, username string, passwordHash string) error {
+	return dm.db.Transaction(func(tx *gorm.DB) error {
+		state := map[string]string{"domain": domain, "username": username}
+		resourceID := domain + "/" + username
+
+		var user models.BasicAuthUser
+		err := tx.Where("domain = ? AND username = ?", domain, username).First(&user).Error
+		switch {
+		case err == nil:
+			if err := tx.Model(&user).Update("password_hash", passwordHash).Error; err != nil {
+				return err
+			}
+			entry := audit.NewEntry(actor, audit.ActionUpdate, audit.ResourceBasicAuthUser, resourceID, state, state)
+			return tx.Create(&entry).Error
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			user = models.BasicAuthUser{Domain: domain, Username: username, PasswordHash: passwordHash}
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+			entry := audit.NewEntry(actor, audit.ActionCreate, audit.ResourceBasicAuthUser, resourceID, nil, state)
+			return tx.Create(&entry).Error
+		default:
+			return err
+		}
+	})
+}
+
+func (dm *DBManager) DeleteBasicAuthUser(actor string, domain string, username string) error {
+	return dm.db.Transaction(func(tx *gorm.DB) error {
+		var user models.BasicAuthUser
+		if err := tx.Where("domain = ? AND username = ?", domain, username).First(&user).Error; err != nil {
+			return err
+		}
+		// Hard delete, so the user can be added again despite the unique index.
+		if err := tx.Unscoped().Delete(&user).Error; err != nil {
+			return err
+		}
+		state := map[string]string{"domain": domain, "username": username}
+		entry := audit.NewEntry(actor, audit.ActionDelete, audit.ResourceBasicAuthUser, domain+"/"+username, state, nil)
+		return tx.Create(&entry).Error
+	})
+}
+
+func (dm *DBManager) GetAllHeaderRules() ([]models.HeaderRule, error) {
+	var rules []models.HeaderRule
+	if err := dm.db.Order("id").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (dm *DBManager) ListHeaderRules(domain string) ([]models.HeaderRule, error) {
+	var rules []models.HeaderRule
+	if err := dm.db.Where("domain = ?", domain).Order("id").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	return rules, nil
 }
 
-func (dm *DBManager) UpdateWebsite(domain string, config models.WebsiteConfig) error {
-	result := dm.db.Model(&models.Website{}).Where("domain = ?", domain).Updates(map[string]interface{}{
-		"protocol":  config.Protocol,
-		"host":      config.Host,
-		"port":      config.Port,
-		"ssl":       config.SSL,
-		"active":    config.Active,
-		"last_seen": time.Now(),
+func (dm *DBManager) CreateHeaderRule(actor string, rule models.HeaderRule) error {
+	rule.Model = gorm.Model{}
+	return dm.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&rule).Error; err != nil {
+			return err
+		}
+		entry := audit.NewEntry(actor, audit.ActionCreate, audit.ResourceHeaderRule, strconv.FormatUint(uint64(rule.ID), 10), nil, rule)
+		return tx.Create(&entry).Error
+	})
+}
+
+func (dm *DBManager) UpdateHeaderRule(actor string, id uint, rule models.HeaderRule) error {
+	return dm.db.Transaction(func(tx *gorm.DB) error {
+		var before models.HeaderRule
+		if err := tx.First(&before, id).Error; err != nil {
+			return err
+		}
+
+		after := before
+		after.Domain = rule.Domain
+		after.PathPrefix = rule.PathPrefix
+		after.Direction = rule.Direction
+		after.Action = rule.Action
+		after.Name = rule.Name
+		after.Value = rule.Value
+		if err := tx.Save(&after).Error; err != nil {
+			return err
+		}
+		entry := audit.NewEntry(actor, audit.ActionUpdate, audit.ResourceHeaderRule, strconv.FormatUint(uint64(id), 10), before, after)
+		return tx.Create(&entry).Error
 	})
+}
+
+func (dm *DBManager) DeleteHeaderRule(actor string, id uint) error {
+	return dm.db.Transaction(func(tx *gorm.DB) error {
+		var before models.HeaderRule
+		if err := tx.First(&before, id).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&before).Error; err != nil {
+			return err
+		}
+		entry := audit.NewEntry(actor, audit.ActionDelete, audit.ResourceHeaderRule, strconv.FormatUint(uint64(id), 10), before, nil)
+		return tx.Create(&entry).Error
+	})
+}
+
+func (dm *DBManager) GetAllRedirectRules() ([]models.RedirectRule, error) {
+	var rules []models.RedirectRule
+	if err := dm.db.Order("id").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (dm *DBManager) ListRedirectRules(domain string) ([]models.RedirectRule, error) {
+	var rules []models.RedirectRule
+	if err := dm.db.Where("domain = ?", domain).Order("id").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (dm *DBManager) CreateRedirectRule(actor string, rule models.RedirectRule) error {
+	rule.Model = gorm.Model{}
+	return dm.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&rule).Error; err != nil {
+			return err
+		}
+		entry := audit.NewEntry(actor, audit.ActionCreate, audit.ResourceRedirectRule, strconv.FormatUint(uint64(rule.ID), 10), nil, rule)
+		return tx.Create(&entry).Error
+	})
+}
+
+func (dm *DBManager) UpdateRedirectRule(actor string, id uint, rule models.RedirectRule) error {
+	return dm.db.Transaction(func(tx *gorm.DB) error {
+		var before models.RedirectRule
+		if err := tx.First(&before, id).Error; err != nil {
+			return err
+		}
+
+		after := before
+		after.Domain = rule.Domain
+		after.Source = rule.Source
+		after.Regex = rule.Regex
+		after.Target = rule.Target
+		after.Status = rule.Status
+		after.PreservePath = rule.PreservePath
+		after.PreserveQuery = rule.PreserveQuery
+		if err := tx.Save(&after).Error; err != nil {
+			return err
+		}
+		entry := audit.NewEntry(actor, audit.ActionUpdate, audit.ResourceRedirectRule, strconv.FormatUint(uint64(id), 10), before, after)
+		return tx.Create(&entry).Error
+	})
+}
+
+func (dm *DBManager) DeleteRedirectRule(actor string, id uint) error {
+	return dm.db.Transaction(func(tx *gorm.DB) error {
+		var before models.RedirectRule
+		if err := tx.First(&before, id).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&before).Error; err != nil {
+			return err
+		}
+		entry := audit.NewEntry(actor, audit.ActionDelete, audit.ResourceRedirectRule, strconv.FormatUint(uint64(id), 10), before, nil)
+		return tx.Create(&entry).Error
+	})
+}
+
+// ImportRedirectRules adds the rules of a domain in one transaction. With
+// replace, the existing rules of the domain are deleted first.
+func (dm *DBManager) ImportRedirectRules(actor string, domain string, rules []models.RedirectRule, replace bool) error {
+	return dm.db.Transaction(func(tx *gorm.DB) error {
+		var deleted int64
+		if replace {
+			result := tx.Where("domain = ?", domain).Delete(&models.RedirectRule{})
+			if result.Error != nil {
+				return result.Error
+			}
+			deleted = result.RowsAffected
+		}
+		for i := range rules {
+			rules[i].Model = gorm.Model{}
+			rules[i].Domain = domain
+		}
+		if len(rules) > 0 {
+			if err := tx.CreateInBatches(&rules, 500).Error; err != nil {
+				return err
+			}
+		}
+		entry := audit.NewEntry(actor, audit.ActionImport, audit.ResourceRedirectRule, domain, map[string]int64{"deleted": deleted}, map[string]int{"imported": len(rules)})
+		return tx.Create(&entry).Error
+	})
+}
+
+func (dm *DBManager) GetAllErrorTemplates() ([]models.ErrorTemplate, error) {
+	var templates []models.ErrorTemplate
+	if err := dm.db.Find(&templates).Error; err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
+
+func (dm *DBManager) ListErrorTemplates(domain string) ([]models.ErrorTemplate, error) {
+	var templates []models.ErrorTemplate
+	if err := dm.db.Where("domain = ?", domain).Order("status, format").Find(&templates).Error; err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
+
+func (dm *DBManager) SetErrorTemplate(actor string, tmpl models.ErrorTemplate) error {
+	return dm.db.Transaction(func(tx *gorm.DB) error {
+		resourceID := errorTemplateID(tmpl.Domain, tmpl.Status, tmpl.Format)
+
+		var before models.ErrorTemplate
+		err := tx.Where("domain = ? AND status = ? AND format = ?", tmpl.Domain, tmpl.Status, tmpl.Format).First(&before).Error
+		switch {
+		case err == nil:
+			after := before
+			after.Template = tmpl.Template
+			if err := tx.Save(&after).Error; err != nil {
+				return err
+			}
+			entry := audit.NewEntry(actor, audit.ActionUpdate, audit.ResourceErrorTemplate, resourceID, before, after)
+			return tx.Create(&entry).Error
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			tmpl.Model = gorm.Model{}
+			if err := tx.Create(&tmpl).Error; err != nil {
+				return err
+			}
+			entry := audit.NewEntry(actor, audit.ActionCreate, audit.ResourceErrorTemplate, resourceID, nil, tmpl)
+			return tx.Create(&entry).Error
+		default:
+			return err
+		}
+	})
+}
+
+func (dm *DBManager) DeleteErrorTemplate(actor string, domain string, status int, format string) error {
+	return dm.db.Transaction(func(tx *gorm.DB) error {
+		var before models.ErrorTemplate
+		if err := tx.Where("domain = ? AND status = ? AND format = ?", domain, status, format).First(&before).Error; err != nil {
+			return err
+		}
+		// Hard delete, so the template can be set again despite the unique index.
+		if err := tx.Unscoped().Delete(&before).Error; err != nil {
+			return err
+		}
+		entry := audit.NewEntry(actor, audit.ActionDelete, audit.ResourceErrorTemplate, errorTemplateID(domain, status, format), before, nil)
+		return tx.Create(&entry).Error
+	})
+}
+
+func errorTemplateID(domain string, status int, format string) string {
+	return domain + "/" + strconv.Itoa(status) + "/" + format
+}
+
+func jsonColumn(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "null"
+	}
+	return string(data)
+}
+
+func (dm *DBManager) CreateAPIToken(token models.APIToken) error {
+	result := dm.db.Create(&token)
 	return result.Error
 }
 
-func (dm *DBManager) DeleteWebsite(domain string) error {
-	result := dm.db.Where("domain = ?", domain).Delete(&models.Website{})
+func (dm *DBManager) FindAPIToken(hash string) (*models.APIToken, error) {
+	var token models.APIToken
+	result := dm.db.Where("token_hash = ?", hash).First(&token)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &token, nil
+}
+
+func (dm *DBManager) TouchAPIToken(id uint) error {
+	result := dm.db.Model(&models.APIToken{}).Where("id = ?", id).Update("last_used", time.Now())
 	return result.Error
 }
+
+func (dm *DBManager) Record(entry models.AuditEntry) error {
+	result := dm.db.Create(&entry)
+	return result.Error
+}
+
+func (dm *DBManager) ListAuditEntries(filter models.AuditFilter) ([]models.AuditEntry, int64, error) {
+	query := dm.db.Model(&models.AuditEntry{})
+	if filter.Actor != "" {
+		query = query.Where("actor = ?", filter.Actor)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.ResourceType != "" {
+		query = query.Where("resource_type = ?", filter.ResourceType)
+	}
+	if filter.ResourceID != "" {
+		query = query.Where("resource_id = ?", filter.ResourceID)
+	}
+	if filter.Since != nil {
+		query = query.Where("created_at >= ?", *filter.Since)
+	}
+	if filter.Until != nil {
+		query = query.Where("created_at < ?", *filter.Until)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var entries []models.AuditEntry
+	result := query.Order("created_at DESC, id DESC").
+		Offset((filter.Page - 1) * filter.PerPage).
+		Limit(filter.PerPage).
+		Find(&entries)
+	if result.Error != nil {
+		return nil, 0, result.Error
+	}
+	return entries, total, nil
+}
+
+func (dm *DBManager) GetAllMaintenanceWindows() ([]models.MaintenanceWindow, error) {
+	var windows []models.MaintenanceWindow
+	if err := dm.db.Order("id").Find(&windows).Error; err != nil {
+		return nil, err
+	}
+	return windows, nil
+}
+
+func (dm *DBManager) ListMaintenanceWindows(domain string) ([]models.MaintenanceWindow, error) {
+	var windows []models.MaintenanceWindow
+	if err := dm.db.Where("domain = ?", domain).Order("id").Find(&windows).Error; err != nil {
+		return nil, err
+	}
+	return windows, nil
+}
+
+func (dm *DBManager) CreateMaintenanceWindow(actor string, window models.MaintenanceWindow) error {
+	window.Model = gorm.Model{}
+	return dm.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&window).Error; err != nil {
+			return err
+		}
+		entry := audit.NewEntry(actor, audit.ActionCreate, audit.ResourceMaintenance, strconv.FormatUint(uint64(window.ID), 10), nil, window)
+		return tx.Create(&entry).Error
+	})
+}
+
+// UpdateMaintenanceWindow replaces the window. The bypass secret is kept if
+// bypassSecret is nil.
+func (dm *DBManager) UpdateMaintenanceWindow(actor string, id uint, window models.MaintenanceWindow, bypassSecret *string) error {
+	return dm.db.Transaction(func(tx *gorm.DB) error {
+		var before models.MaintenanceWindow
+		if err := tx.First(&before, id).Error; err != nil {
+			return err
+		}
+
+		after := before
+		after.Domain = window.Domain
+		after.Start = window.Start
+		after.End = window.End
+		after.Message = window.Message
+		after.RetryAfter = window.RetryAfter
+		after.BypassIPs = window.BypassIPs
+		if bypassSecret != nil {
+			after.BypassSecret = *bypassSecret
+		}
+		if err := tx.Save(&after).Error; err != nil {
+			return err
+		}
+		entry := audit.NewEntry(actor, audit.ActionUpdate, audit.ResourceMaintenance, strconv.FormatUint(uint64(id), 10), before, after)
+		return tx.Create(&entry).Error
+	})
+}
+
+func (dm *DBManager) DeleteMaintenanceWindow(actor string, id uint) error {
+	return dm.db.Transaction(func(tx *gorm.DB) error {
+		var before models.MaintenanceWindow
+		if err := tx.First(&before, id).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&before).Error; err != nil {
+			return err
+		}
+		entry := audit.NewEntry(actor, audit.ActionDelete, audit.ResourceMaintenance, strconv.FormatUint(uint64(id), 10), before, nil)
+		return tx.Create(&entry).Error
+	})
+}
@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/secnex/reverse-proxy/models"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// recordingDriver is a database/sql driver that logs the statements it gets,
+// so the transaction boundaries of the DBManager can be checked without a
+// database server.
+type recordingDriver struct {
+	mu         sync.Mutex
+	statements []string
+	// fail makes statements containing this string return an error.
+	fail string
+}
+
+func (d *recordingDriver) Open(name string) (driver.Conn, error) {
+	return &recordingConn{driver: d}, nil
+}
+
+func (d *recordingDriver) record(statement string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.statements = append(d.statements, statement)
+	if d.fail != "" && strings.Contains(statement, d.fail) {
+		return errors.New("statement failed")
+	}
+	return nil
+}
+
+func (d *recordingDriver) log() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.statements...)
+}
+
+type recordingConn struct {
+	driver *recordingDriver
+}
+
+func (c *recordingConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements are not supported")
+}
+
+func (c *recordingConn) Close() error { return nil }
+
+func (c *recordingConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *recordingConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if err := c.driver.record("BEGIN"); err != nil {
+		return nil, err
+	}
+	return recordingTx{driver: c.driver}, nil
+}
+
+func (c *recordingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := c.driver.record(query); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(1), nil
+}
+
+// QueryContext answers INSERT ... RETURNING with a new ID and everything else
+// with no rows.
+func (c *recordingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := c.driver.record(query); err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(query, "INSERT") {
+		return &recordingRows{columns: []string{"id"}, values: [][]driver.Value{{int64(1)}}}, nil
+	}
+	return &recordingRows{}, nil
+}
+
+type recordingTx struct {
+	driver *recordingDriver
+}
+
+func (tx recordingTx) Commit() error   { return tx.driver.record("COMMIT") }
+func (tx recordingTx) Rollback() error { return tx.driver.record("ROLLBACK") }
+
+type recordingRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *recordingRows) Columns() []string { return r.columns }
+func (r *recordingRows) Close() error      { return nil }
+
+func (r *recordingRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func newRecordingDBManager(t *testing.T, d *recordingDriver) *DBManager {
+	t.Helper()
+	sql.Register("recording-"+t.Name(), d)
+	conn, err := sql.Open("recording-"+t.Name(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: conn}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &DBManager{db: db}
+}
+
+// statementTables shortens the statements to their verb and table.
+func statementTables(statements []string) []string {
+	var tables []string
+	for _, statement := range statements {
+		fields := strings.Fields(statement)
+		switch {
+		case len(fields) >= 3 && fields[0] == "INSERT":
+			tables = append(tables, "INSERT "+strings.Trim(fields[2], `"`))
+		default:
+			tables = append(tables, fields[0])
+		}
+	}
+	return tables
+}
+
+func TestCreateWebsiteAuditInTransaction(t *testing.T) {
+	tests := []struct {
+		name string
+		fail string
+		want []string
+	}{
+		{"committed together", "", []string{"BEGIN", "INSERT websites", "INSERT audit_entries", "COMMIT"}},
+		{"failed audit entry", `"audit_entries"`, []string{"BEGIN", "INSERT websites", "INSERT audit_entries", "ROLLBACK"}},
+		{"failed change", `"websites"`, []string{"BEGIN", "INSERT websites", "ROLLBACK"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &recordingDriver{fail: tt.fail}
+			dm := newRecordingDBManager(t, d)
+
+			err := dm.CreateWebsite("admin", models.WebsiteConfig{Domain: "a.example", Protocol: "http", Host: "backend", Port: 80})
+			if (err != nil) != (tt.fail != "") {
+				t.Errorf("CreateWebsite() = %v", err)
+			}
+			got := statementTables(d.log())
+			if strings.Join(got, ", ") != strings.Join(tt.want, ", ") {
+				t.Errorf("statements = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
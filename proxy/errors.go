@@ -0,0 +1,229 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/secnex/reverse-proxy/models"
+	"github.com/secnex/reverse-proxy/templates"
+)
+
+const (
+	ErrorFormatHTML = "html"
+	ErrorFormatJSON = "json"
+	ErrorFormatText = "text"
+)
+
+// hostNotFoundMessage is the message of the 404 for hosts without a website.
+const hostNotFoundMessage = "Host not found"
+
+// errorContentTypes are the media types of the error formats, in order of
+// preference if the client accepts several equally.
+var errorContentTypes = []struct {
+	format      string
+	contentType string
+}{
+	{ErrorFormatHTML, "text/html"},
+	{ErrorFormatJSON, "application/json"},
+	{ErrorFormatText, "text/plain"},
+}
+
+// preservedErrorHeaders are kept when an upstream error is intercepted.
+var preservedErrorHeaders = []string{"WWW-Authenticate", "Retry-After", "Allow"}
+
+type errorPage struct {
+	Status  int
+	Message string
+	// Notice is the custom message of the response, shown in addition to
+	// the text of the built-in pages.
+	Notice    string
+	Host      string
+	RequestID string
+}
+
+type errorTemplateKey struct {
+	status int
+	format string
+}
+
+// errorTemplate is implemented by both html/template and text/template.
+type errorTemplate interface {
+	Execute(w io.Writer, data any) error
+}
+
+func parseErrorTemplate(tmpl models.ErrorTemplate) (errorTemplate, error) {
+	if tmpl.Format == ErrorFormatHTML {
+		return htmltemplate.New("error").Parse(tmpl.Template)
+	}
+	return texttemplate.New("error").Parse(tmpl.Template)
+}
+
+// serveError renders the error page for the status code in the format the
+// client accepts. Templates of the website take precedence over the
+// generated pages. Pages are written with the actual status code.
+func (rp *ReverseProxy) serveError(w http.ResponseWriter, r *http.Request, status int) {
+	message := http.StatusText(status)
+	if message == "" {
+		message = "Error"
+	}
+	rp.writeError(w, r, errorPage{Status: status, Message: message})
+}
+
+// serveErrorMessage is serveError with a custom message.
+func (rp *ReverseProxy) serveErrorMessage(w http.ResponseWriter, r *http.Request, status int, message string) {
+	rp.writeError(w, r, errorPage{Status: status, Message: message, Notice: message})
+}
+
+func (rp *ReverseProxy) writeError(w http.ResponseWriter, r *http.Request, page errorPage) {
+	page.Host = hostname(r)
+	page.RequestID = getRequestInfo(r).requestID
+	format := negotiateErrorFormat(r)
+
+	if config, exists := rp.configCache.Get(page.Host); exists {
+		if rp.writeErrorTemplate(w, config, format, page) {
+			return
+		}
+	}
+
+	switch format {
+	case ErrorFormatJSON:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(page.Status)
+		json.NewEncoder(w).Encode(map[string]string{"error": page.Message, "request_id": page.RequestID})
+	case ErrorFormatText:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(page.Status)
+		fmt.Fprintf(w, "%d %s\nRequest ID: %s\n", page.Status, page.Message, page.RequestID)
+	default:
+		rp.writeErrorPage(w, page)
+	}
+}
+
+// writeErrorTemplate renders the template of the website for the status, or
+// its catch-all template, and reports false if there is none.
+func (rp *ReverseProxy) writeErrorTemplate(w http.ResponseWriter, config ProxyConfig, format string, page errorPage) bool {
+	tmpl, exists := config.errorTemplates[errorTemplateKey{status: page.Status, format: format}]
+	if !exists {
+		tmpl, exists = config.errorTemplates[errorTemplateKey{format: format}]
+	}
+	if !exists {
+		return false
+	}
+
+	var output bytes.Buffer
+	if err := tmpl.Execute(&output, page); err != nil {
+		log.Printf("Error rendering error template %d/%s for %s: %v", page.Status, format, page.Host, err)
+		return false
+	}
+
+	for _, contentType := range errorContentTypes {
+		if contentType.format == format {
+			w.Header().Set("Content-Type", contentType.contentType+"; charset=utf-8")
+		}
+	}
+	w.WriteHeader(page.Status)
+	w.Write(output.Bytes())
+	return true
+}
+
+// writeErrorPage writes the rendered page of the status code, or a plain
+// error if there is no page for it.
+func (rp *ReverseProxy) writeErrorPage(w http.ResponseWriter, page errorPage) {
+	var output bytes.Buffer
+	if err := rp.pages.Execute(&output, fmt.Sprintf("%d.html", page.Status), templates.Request{
+		Status:    page.Status,
+		Message:   page.Message,
+		Notice:    page.Notice,
+		Host:      page.Host,
+		RequestID: page.RequestID,
+	}); err != nil {
+		http.Error(w, page.Message, page.Status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(page.Status)
+	w.Write(output.Bytes())
+}
+
+// interceptError replaces an upstream error response by the error page of the
+// proxy if the website asks for it.
+func (rp *ReverseProxy) interceptError(w http.ResponseWriter, r *http.Request, config ProxyConfig, resp *http.Response) bool {
+	if !config.InterceptErrors || resp.StatusCode < 400 {
+		return false
+	}
+	for _, name := range preservedErrorHeaders {
+		if values := resp.Header.Values(name); len(values) > 0 {
+			w.Header()[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+	setHSTS(w, r, config)
+	rp.serveError(w, r, resp.StatusCode)
+	return true
+}
+
+// negotiateErrorFormat picks the error format from the Accept header. Without
+// a preference, JSON requests get JSON errors and everything else HTML.
+func negotiateErrorFormat(r *http.Request) string {
+	accept := r.Header.Get("Accept")
+	if accept == "" || strings.TrimSpace(accept) == "*/*" {
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+			return ErrorFormatJSON
+		}
+		return ErrorFormatHTML
+	}
+
+	best, bestQuality := ErrorFormatHTML, 0.0
+	for _, candidate := range errorContentTypes {
+		if quality := acceptQuality(accept, candidate.contentType); quality > bestQuality {
+			best, bestQuality = candidate.format, quality
+		}
+	}
+	return best
+}
+
+// acceptQuality returns the quality of the most specific media range in the
+// Accept header that matches the content type.
+func acceptQuality(accept string, contentType string) float64 {
+	mainType, _, _ := strings.Cut(contentType, "/")
+	quality, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		mediaRange, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		mediaRange = strings.ToLower(strings.TrimSpace(mediaRange))
+
+		var rangeSpecificity int
+		switch {
+		case mediaRange == contentType:
+			rangeSpecificity = 2
+		case mediaRange == mainType+"/*":
+			rangeSpecificity = 1
+		case mediaRange == "*/*":
+			rangeSpecificity = 0
+		default:
+			continue
+		}
+		if rangeSpecificity <= specificity {
+			continue
+		}
+
+		specificity, quality = rangeSpecificity, 1.0
+		for _, param := range strings.Split(params, ";") {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if q, err := strconv.ParseFloat(value, 64); err == nil {
+					quality = q
+				}
+			}
+		}
+	}
+	return quality
+}
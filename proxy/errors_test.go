@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/secnex/reverse-proxy/models"
+)
+
+func TestAcceptQuality(t *testing.T) {
+	tests := []struct {
+		accept      string
+		contentType string
+		want        float64
+	}{
+		{"text/html", "text/html", 1},
+		{"text/html", "application/json", 0},
+		{"TEXT/HTML", "text/html", 1},
+		{"text/html;q=0.5", "text/html", 0.5},
+		{"text/html; q=0.5", "text/html", 0.5},
+		{"text/*;q=0.3", "text/plain", 0.3},
+		{"*/*;q=0.1", "application/json", 0.1},
+		{"*/*;q=0.1, application/json", "application/json", 1},
+		{"application/json;q=0.2, */*", "application/json", 0.2},
+		{"text/*;q=0.3, text/html;q=0.7, */*;q=0.5", "text/html", 0.7},
+		{"text/*;q=0.3, text/html;q=0.7, */*;q=0.5", "text/plain", 0.3},
+		{"text/*;q=0.3, text/html;q=0.7, */*;q=0.5", "application/json", 0.5},
+		{"text/html;level=1;q=0.4", "text/html", 0.4},
+		{"text/html;q=invalid", "text/html", 1},
+		{"text/html;q=0", "text/html", 0},
+		{"", "text/html", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.accept+" "+tt.contentType, func(t *testing.T) {
+			if got := acceptQuality(tt.accept, tt.contentType); got != tt.want {
+				t.Errorf("acceptQuality(%q, %q) = %v, want %v", tt.accept, tt.contentType, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNegotiateErrorFormat(t *testing.T) {
+	tests := []struct {
+		name        string
+		accept      string
+		contentType string
+		want        string
+	}{
+		{"no preference", "", "", ErrorFormatHTML},
+		{"any", "*/*", "", ErrorFormatHTML},
+		{"JSON request without preference", "", "application/json; charset=utf-8", ErrorFormatJSON},
+		{"JSON request accepting anything", "*/*", "application/json", ErrorFormatJSON},
+		{"JSON request accepting HTML", "text/html", "application/json", ErrorFormatHTML},
+		{"browser", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", "", ErrorFormatHTML},
+		{"JSON", "application/json", "", ErrorFormatJSON},
+		{"text", "text/plain", "", ErrorFormatText},
+		{"weighted", "text/html;q=0.5, application/json;q=0.9", "", ErrorFormatJSON},
+		{"equal weights prefer HTML", "application/json, text/html", "", ErrorFormatHTML},
+		{"text wildcard", "text/*;q=0.5, text/html;q=0.1", "", ErrorFormatText},
+		{"unsupported", "image/png", "", ErrorFormatHTML},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://a.example/", nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			if got := negotiateErrorFormat(r); got != tt.want {
+				t.Errorf("negotiateErrorFormat() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrorMessages(t *testing.T) {
+	store := &fakeStore{websites: []models.Website{
+		{Domain: "a.example", InterceptErrors: true},
+		{Domain: "b.example"},
+	}}
+	rp := newTestProxy(t, store, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			http.Error(w, "upstream page", http.StatusNotFound)
+		case "/limited":
+			w.Header().Set("Retry-After", "30")
+			w.Header().Set("X-Upstream", "1")
+			http.Error(w, "upstream page", http.StatusTooManyRequests)
+		case "/unknown":
+			w.WriteHeader(520)
+		}
+	})
+
+	tests := []struct {
+		name    string
+		url     string
+		status  int
+		message string
+		header  http.Header
+	}{
+		{"unknown host", "http://unknown.example/", http.StatusNotFound, "Host not found", nil},
+		{"intercepted 404", "http://a.example/missing", http.StatusNotFound, "Not Found", nil},
+		{"intercepted 429", "http://a.example/limited", http.StatusTooManyRequests, "Too Many Requests", http.Header{"Retry-After": {"30"}}},
+		{"intercepted non-standard status", "http://a.example/unknown", 520, "Error", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			r.Header.Set("Accept", "application/json")
+			w := serve(rp, r)
+
+			var body map[string]string
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("body %q: %v", w.Body.String(), err)
+			}
+			if w.Code != tt.status || body["error"] != tt.message {
+				t.Errorf("got %d %q, want %d %q", w.Code, body["error"], tt.status, tt.message)
+			}
+			for name := range tt.header {
+				if got := w.Header().Get(name); got != tt.header.Get(name) {
+					t.Errorf("%s = %q, want %q", name, got, tt.header.Get(name))
+				}
+			}
+			if w.Header().Get("X-Upstream") != "" {
+				t.Error("upstream header of the intercepted response is kept")
+			}
+		})
+	}
+
+	w := serve(rp, httptest.NewRequest(http.MethodGet, "http://b.example/missing", nil))
+	if w.Code != http.StatusNotFound || w.Body.String() != "upstream page\n" {
+		t.Errorf("not intercepted: got %d %q", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	HeaderRequest  = "request"
+	HeaderResponse = "response"
+
+	HeaderSet    = "set"
+	HeaderAdd    = "add"
+	HeaderRemove = "remove"
+)
+
+// hopHeaders only apply to a single connection and are never forwarded.
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// copyHeader copies the end-to-end headers of src to dst, without the
+// hop-by-hop headers and those listed in the Connection header.
+func copyHeader(dst http.Header, src http.Header) {
+	skip := make(map[string]bool)
+	for _, name := range hopHeaders {
+		skip[name] = true
+	}
+	for _, value := range src.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			skip[http.CanonicalHeaderKey(strings.TrimSpace(name))] = true
+		}
+	}
+
+	for key, values := range src {
+		if skip[key] {
+			continue
+		}
+		for _, value := range values {
+			dst.Add(key, value)
+		}
+	}
+}
+
+// upstreamHeader returns the headers sent upstream. Protocol upgrades keep
+// their Connection and Upgrade headers, e.g. for WebSockets.
+func upstreamHeader(r *http.Request) http.Header {
+	header := make(http.Header)
+	copyHeader(header, r.Header)
+
+	if upgrade := r.Header.Get("Upgrade"); upgrade != "" && headerHasToken(r.Header, "Connection", "upgrade") {
+		header.Set("Connection", "Upgrade")
+		header.Set("Upgrade", upgrade)
+	}
+	return header
+}
+
+func headerHasToken(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, item := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(item), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// applyHeaderRules applies the rules of the site for the direction in the
+// order they were created.
+func applyHeaderRules(header http.Header, r *http.Request, config ProxyConfig, direction string) {
+	var replacer *strings.Replacer
+	for _, rule := range config.headerRules {
+		if rule.Direction != direction || !strings.HasPrefix(r.URL.Path, rule.PathPrefix) {
+			continue
+		}
+
+		switch rule.Action {
+		case HeaderRemove:
+			header.Del(rule.Name)
+		case HeaderSet, HeaderAdd:
+			if replacer == nil {
+				replacer = headerPlaceholders(r)
+			}
+			value := replacer.Replace(rule.Value)
+			if rule.Action == HeaderSet {
+				header.Set(rule.Name, value)
+			} else {
+				header.Add(rule.Name, value)
+			}
+		}
+	}
+}
+
+func headerPlaceholders(r *http.Request) *strings.Replacer {
+	info := getRequestInfo(r)
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return strings.NewReplacer(
+		"{client_ip}", info.clientIP,
+		"{request_id}", info.requestID,
+		"{host}", hostname(r),
+		"{method}", r.Method,
+		"{path}", r.URL.Path,
+		"{scheme}", scheme,
+	)
+}
@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/secnex/reverse-proxy/models"
+)
+
+func TestUpstreamHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   http.Header
+	}{
+		{
+			name:   "end-to-end headers",
+			header: http.Header{"Accept": {"text/html"}, "X-Custom": {"a", "b"}},
+			want:   http.Header{"Accept": {"text/html"}, "X-Custom": {"a", "b"}},
+		},
+		{
+			name:   "hop-by-hop headers",
+			header: http.Header{"Accept": {"text/html"}, "Keep-Alive": {"timeout=5"}, "Proxy-Authorization": {"Basic x"}, "Te": {"trailers"}},
+			want:   http.Header{"Accept": {"text/html"}},
+		},
+		{
+			name:   "headers listed in Connection",
+			header: http.Header{"Connection": {"close, X-Hop"}, "X-Hop": {"1"}, "X-Other": {"2"}},
+			want:   http.Header{"X-Other": {"2"}},
+		},
+		{
+			name:   "upgrade",
+			header: http.Header{"Connection": {"keep-alive, Upgrade"}, "Upgrade": {"websocket"}, "Sec-Websocket-Key": {"key"}},
+			want:   http.Header{"Connection": {"Upgrade"}, "Upgrade": {"websocket"}, "Sec-Websocket-Key": {"key"}},
+		},
+		{
+			name:   "upgrade without Connection",
+			header: http.Header{"Upgrade": {"websocket"}},
+			want:   http.Header{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://a.example/", nil)
+			r.Header = tt.header
+			if got := upstreamHeader(r); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("upstreamHeader() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHeaderRules(t *testing.T) {
+	rules := []models.HeaderRule{
+		{Direction: HeaderRequest, Action: HeaderSet, Name: "X-Site", Value: "site"},
+		{Direction: HeaderRequest, Action: HeaderSet, Name: "X-Site", Value: "api", PathPrefix: "/api"},
+		{Direction: HeaderRequest, Action: HeaderSet, Name: "X-Route", Value: "api", PathPrefix: "/api"},
+		{Direction: HeaderRequest, Action: HeaderSet, Name: "X-Route", Value: "site"},
+		{Direction: HeaderRequest, Action: HeaderAdd, Name: "X-Tag", Value: "added"},
+		{Direction: HeaderRequest, Action: HeaderRemove, Name: "X-Secret"},
+		{Direction: HeaderRequest, Action: HeaderSet, Name: "X-Context", Value: "{method} {scheme}://{host}{path} from {client_ip} as {request_id}"},
+		{Direction: HeaderResponse, Action: HeaderSet, Name: "X-Frame-Options", Value: "DENY"},
+		{Direction: HeaderResponse, Action: HeaderRemove, Name: "Server"},
+		{Direction: HeaderResponse, Action: HeaderAdd, Name: "X-Powered-By", Value: "proxy", PathPrefix: "/api"},
+	}
+	for i := range rules {
+		rules[i].Domain = "a.example"
+	}
+
+	var upstream http.Header
+	rp := newTestProxy(t, &fakeStore{
+		websites:    []models.Website{{Domain: "a.example"}},
+		headerRules: rules,
+	}, func(w http.ResponseWriter, r *http.Request) {
+		upstream = r.Header.Clone()
+		w.Header().Set("Server", "backend")
+		w.Header().Set("X-Powered-By", "backend")
+	})
+	// The request ID of the test client is kept.
+	rp.trustedProxies = parseNetworks([]string{"192.0.2.0/24"}, "trusted proxy")
+
+	tests := []struct {
+		path     string
+		request  map[string][]string
+		response map[string][]string
+	}{
+		{
+			path: "/page",
+			request: map[string][]string{
+				"X-Site":    {"site"},
+				"X-Route":   {"site"},
+				"X-Tag":     {"client", "added"},
+				"X-Secret":  nil,
+				"X-Context": {"GET http://a.example/page from 192.0.2.1 as req-1"},
+			},
+			response: map[string][]string{
+				"X-Frame-Options": {"DENY"},
+				"Server":          nil,
+				"X-Powered-By":    {"backend"},
+			},
+		},
+		{
+			// Later rules win: the route rule overrides the earlier site rule
+			// for X-Site, the later site rule overrides the route rule for
+			// X-Route.
+			path: "/api/users",
+			request: map[string][]string{
+				"X-Site":  {"api"},
+				"X-Route": {"site"},
+			},
+			response: map[string][]string{
+				"X-Powered-By": {"backend", "proxy"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://a.example"+tt.path, nil)
+			r.Header.Set("X-Tag", "client")
+			r.Header.Set("X-Secret", "token")
+			r.Header.Set(requestIDHeader, "req-1")
+
+			w := serve(rp, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d", w.Code)
+			}
+			for name, want := range tt.request {
+				if got := upstream.Values(name); !reflect.DeepEqual(got, want) {
+					t.Errorf("upstream %s = %q, want %q", name, got, want)
+				}
+			}
+			for name, want := range tt.response {
+				if got := w.Header().Values(name); !reflect.DeepEqual(got, want) {
+					t.Errorf("response %s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
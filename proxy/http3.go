@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/secnex/reverse-proxy/config"
+)
+
+// altSvcMaxAge is how long clients may remember the HTTP/3 listener.
+const altSvcMaxAge = 86400
+
+// StartHTTP3 serves HTTP/3 over QUIC on the UDP port of an HTTPS listener,
+// with the same certificates and handler as the TCP listener. Sites without
+// HTTP3 answer requests over QUIC with 421, so clients retry over TCP.
+func (rp *ReverseProxy) StartHTTP3(listener config.Listener) error {
+	tlsConfig, err := rp.tlsConfig(listener)
+	if err != nil {
+		return err
+	}
+
+	server := &http3.Server{
+		Handler:        rp.Handler(),
+		TLSConfig:      http3.ConfigureTLSConfig(tlsConfig),
+		QUICConfig:     &quic.Config{MaxIdleTimeout: rp.limits.IdleTimeout.Duration},
+		MaxHeaderBytes: rp.limits.MaxHeaderBytes,
+		IdleTimeout:    rp.limits.IdleTimeout.Duration,
+	}
+
+	conn, err := net.ListenPacket(strings.Replace(rp.network, "tcp", "udp", 1), listener.Address)
+	if err != nil {
+		return fmt.Errorf("error listening on %s: %v", listener.Address, err)
+	}
+	return server.Serve(conn)
+}
+
+// advertiseHTTP3 announces the HTTP/3 listener of the same address with
+// Alt-Svc on responses of sites that enable HTTP3.
+func (rp *ReverseProxy) advertiseHTTP3(next http.Handler, listener config.Listener) http.Handler {
+	_, port, _ := net.SplitHostPort(listener.Address)
+	altSvc := fmt.Sprintf(`h3=":%s"; ma=%d`, port, altSvcMaxAge)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config, exists := rp.configCache.Get(hostname(r)); exists && config.HTTP3 {
+			w.Header().Set("Alt-Svc", altSvc)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	HTTPSPolicyBoth     = "both"
+	HTTPSPolicyRedirect = "redirect"
+	HTTPSPolicyOnly     = "https-only"
+
+	acmeChallengePrefix = "/.well-known/acme-challenge/"
+)
+
+// enforceHTTPS applies the HTTPS policy of an SSL website to plain HTTP
+// requests and reports whether the request has been answered.
+func (rp *ReverseProxy) enforceHTTPS(w http.ResponseWriter, r *http.Request, host string, config ProxyConfig) bool {
+	if r.TLS != nil || !config.SSL || strings.HasPrefix(r.URL.Path, acmeChallengePrefix) {
+		return false
+	}
+
+	switch config.HTTPSPolicy {
+	case HTTPSPolicyRedirect:
+		status := config.HTTPSRedirectStatus
+		if !isRedirectStatus(status) {
+			status = http.StatusPermanentRedirect
+		}
+		target := "https://" + host
+		if rp.httpsPort != "" && rp.httpsPort != "443" {
+			target = "https://" + net.JoinHostPort(host, rp.httpsPort)
+		}
+		http.Redirect(w, r, target+r.URL.RequestURI(), status)
+		return true
+	case HTTPSPolicyOnly:
+		rp.serveError(w, r, http.StatusForbidden)
+		return true
+	}
+	return false
+}
+
+func setHSTS(w http.ResponseWriter, r *http.Request, config ProxyConfig) {
+	if r.TLS == nil || config.HSTSMaxAge <= 0 {
+		return
+	}
+
+	value := "max-age=" + strconv.Itoa(config.HSTSMaxAge)
+	if config.HSTSIncludeSubdomains {
+		value += "; includeSubDomains"
+	}
+	if config.HSTSPreload {
+		value += "; preload"
+	}
+	w.Header().Set("Strict-Transport-Security", value)
+}
+
+func isRedirectStatus(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/secnex/reverse-proxy/models"
+)
+
+func TestEnforceHTTPS(t *testing.T) {
+	tests := []struct {
+		name      string
+		website   models.Website
+		httpsPort string
+		url       string
+		status    int
+		location  string
+	}{
+		{name: "redirect", website: models.Website{SSL: true, HTTPSPolicy: HTTPSPolicyRedirect}, url: "http://a.example/page?q=1", status: http.StatusPermanentRedirect, location: "https://a.example/page?q=1"},
+		{name: "redirect status", website: models.Website{SSL: true, HTTPSPolicy: HTTPSPolicyRedirect, HTTPSRedirectStatus: 301}, url: "http://a.example/", status: http.StatusMovedPermanently, location: "https://a.example/"},
+		{name: "invalid redirect status", website: models.Website{SSL: true, HTTPSPolicy: HTTPSPolicyRedirect, HTTPSRedirectStatus: 200}, url: "http://a.example/", status: http.StatusPermanentRedirect, location: "https://a.example/"},
+		{name: "redirect to other port", website: models.Website{SSL: true, HTTPSPolicy: HTTPSPolicyRedirect}, httpsPort: "8443", url: "http://a.example:8080/", status: http.StatusPermanentRedirect, location: "https://a.example:8443/"},
+		{name: "ACME challenge", website: models.Website{SSL: true, HTTPSPolicy: HTTPSPolicyRedirect}, url: "http://a.example/.well-known/acme-challenge/token", status: http.StatusOK},
+		{name: "already HTTPS", website: models.Website{SSL: true, HTTPSPolicy: HTTPSPolicyRedirect}, url: "https://a.example/", status: http.StatusOK},
+		{name: "without SSL", website: models.Website{HTTPSPolicy: HTTPSPolicyRedirect}, url: "http://a.example/", status: http.StatusOK},
+		{name: "both", website: models.Website{SSL: true, HTTPSPolicy: HTTPSPolicyBoth}, url: "http://a.example/", status: http.StatusOK},
+		{name: "https-only", website: models.Website{SSL: true, HTTPSPolicy: HTTPSPolicyOnly}, url: "http://a.example/", status: http.StatusForbidden},
+		{name: "https-only over HTTPS", website: models.Website{SSL: true, HTTPSPolicy: HTTPSPolicyOnly}, url: "https://a.example/", status: http.StatusOK},
+		{name: "https-only ACME challenge", website: models.Website{SSL: true, HTTPSPolicy: HTTPSPolicyOnly}, url: "http://a.example/.well-known/acme-challenge/token", status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.website.Domain = "a.example"
+			rp := newTestProxy(t, &fakeStore{websites: []models.Website{tt.website}}, func(w http.ResponseWriter, r *http.Request) {})
+			if tt.httpsPort != "" {
+				rp.httpsPort = tt.httpsPort
+			}
+
+			w := serve(rp, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("Location"); got != tt.location {
+				t.Errorf("Location = %q, want %q", got, tt.location)
+			}
+		})
+	}
+}
+
+func TestHSTS(t *testing.T) {
+	tests := []struct {
+		name    string
+		website models.Website
+		url     string
+		want    string
+	}{
+		{"max-age", models.Website{HSTSMaxAge: 300}, "https://a.example/", "max-age=300"},
+		{"includeSubDomains", models.Website{HSTSMaxAge: 300, HSTSIncludeSubdomains: true}, "https://a.example/", "max-age=300; includeSubDomains"},
+		{"preload", models.Website{HSTSMaxAge: 63072000, HSTSIncludeSubdomains: true, HSTSPreload: true}, "https://a.example/", "max-age=63072000; includeSubDomains; preload"},
+		{"disabled", models.Website{HSTSIncludeSubdomains: true}, "https://a.example/", ""},
+		{"plain HTTP", models.Website{HSTSMaxAge: 300}, "http://a.example/", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.website.Domain = "a.example"
+			rp := newTestProxy(t, &fakeStore{websites: []models.Website{tt.website}}, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Strict-Transport-Security", "max-age=1")
+			})
+
+			w := serve(rp, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			want := tt.want
+			if want == "" {
+				// Without a policy the header of the upstream passes through.
+				want = "max-age=1"
+			}
+			if got := w.Header().Get("Strict-Transport-Security"); got != want {
+				t.Errorf("Strict-Transport-Security = %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+var errUpstreamTimeout = errors.New("upstream timeout")
+
+func (rp *ReverseProxy) upstreamTimeout(config ProxyConfig) time.Duration {
+	if config.UpstreamTimeout > 0 {
+		return config.UpstreamTimeout
+	}
+	return rp.limits.UpstreamTimeout.Duration
+}
+
+func (rp *ReverseProxy) maxBodyBytes(config ProxyConfig) int64 {
+	if config.MaxBodySize > 0 {
+		return config.MaxBodySize
+	}
+	return rp.limits.MaxBodyBytes
+}
+
+// headerSize approximates the size of the request line and headers as they
+// were received on the wire.
+func headerSize(r *http.Request) int {
+	size := len(r.Method) + len(r.RequestURI) + len(r.Proto) + 4
+	for key, values := range r.Header {
+		for _, value := range values {
+			size += len(key) + len(value) + 4
+		}
+	}
+	return size
+}
+
+// requestBody records the first read error so failed upstream requests can be
+// attributed to the client instead of the backend.
+type requestBody struct {
+	io.ReadCloser
+	err error
+}
+
+func (b *requestBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && b.err == nil {
+		b.err = err
+	}
+	return n, err
+}
+
+// clientErrorStatus maps a failed request body read to the status code the
+// client should see, or 0 if the body was not at fault.
+func clientErrorStatus(body *requestBody) int {
+	if body == nil || body.err == nil {
+		return 0
+	}
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(body.err, &maxBytesErr) {
+		return http.StatusRequestEntityTooLarge
+	}
+	var netErr net.Error
+	if errors.As(body.err, &netErr) && netErr.Timeout() {
+		return http.StatusRequestTimeout
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/secnex/reverse-proxy/models"
+)
+
+// timeoutError is the error of a read deadline, as returned while the client
+// sends the body too slowly.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// slowBody returns some data and then runs into the read deadline.
+type slowBody struct {
+	sent bool
+}
+
+func (b *slowBody) Read(p []byte) (int, error) {
+	if !b.sent {
+		b.sent = true
+		return copy(p, "partial"), nil
+	}
+	return 0, timeoutError{}
+}
+
+func TestRequestLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    func() io.Reader
+		length  int64
+		headers int
+		want    int
+	}{
+		{name: "body within limit", body: func() io.Reader { return strings.NewReader("small") }, length: 5, want: http.StatusOK},
+		{name: "oversize body with length", body: func() io.Reader { return strings.NewReader(strings.Repeat("x", 100)) }, length: 100, want: http.StatusRequestEntityTooLarge},
+		{name: "oversize chunked body", body: func() io.Reader { return strings.NewReader(strings.Repeat("x", 100)) }, length: -1, want: http.StatusRequestEntityTooLarge},
+		{name: "slow body", body: func() io.Reader { return &slowBody{} }, length: -1, want: http.StatusRequestTimeout},
+		{name: "oversize headers", headers: 100, want: http.StatusRequestHeaderFieldsTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &fakeStore{websites: []models.Website{{Domain: "a.example", MaxBodySize: 10, MaxHeaderSize: 1024}}}
+			rp := newTestProxy(t, store, func(w http.ResponseWriter, r *http.Request) {
+				io.Copy(io.Discard, r.Body)
+			})
+
+			var body io.Reader
+			if tt.body != nil {
+				body = tt.body()
+			}
+			r := httptest.NewRequest(http.MethodPost, "http://a.example/", body)
+			r.ContentLength = tt.length
+			for i := 0; i < tt.headers; i++ {
+				r.Header.Add("X-Filler", strings.Repeat("x", 20))
+			}
+
+			if w := serve(rp, r); w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpstreamTimeout(t *testing.T) {
+	store := &fakeStore{websites: []models.Website{{Domain: "a.example"}}}
+	rp := newTestProxy(t, store, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+	rp.limits.UpstreamTimeout.Duration = 50 * time.Millisecond
+
+	start := time.Now()
+	w := serve(rp, httptest.NewRequest(http.MethodGet, "http://a.example/", nil))
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusGatewayTimeout)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("request took %v, want it cut off by the upstream timeout", elapsed)
+	}
+}
@@ -0,0 +1,151 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/secnex/reverse-proxy/models"
+	"github.com/secnex/reverse-proxy/ratelimit"
+)
+
+const (
+	maintenanceBypassPath   = "/.maintenance/bypass"
+	maintenanceBypassCookie = "secnex_maintenance"
+
+	maintenanceBypassForm = `<!DOCTYPE html>
+<html lang="de">
+<head><meta charset="UTF-8"><title>Wartung</title></head>
+<body>
+<form method="post" action="/.maintenance/bypass">
+<input type="password" name="secret" autofocus>
+<button type="submit">Weiter</button>
+</form>
+</body>
+</html>
+`
+)
+
+// maintenanceBypassLimit throttles guessing the bypass secret. The bypass path
+// is handled before the rate limit rules of the site.
+var maintenanceBypassLimit = ratelimit.Limit{Requests: 5, Period: time.Minute, Burst: 5}
+
+type maintenanceWindow struct {
+	models.MaintenanceWindow
+	bypassNetworks []*net.IPNet
+}
+
+func newMaintenanceWindow(window models.MaintenanceWindow) maintenanceWindow {
+	return maintenanceWindow{
+		MaintenanceWindow: window,
+		bypassNetworks:    parseNetworks(window.BypassIPs, "maintenance bypass network"),
+	}
+}
+
+func (m maintenanceWindow) activeAt(t time.Time) bool {
+	if m.Start != nil && t.Before(*m.Start) {
+		return false
+	}
+	return m.End == nil || t.Before(*m.End)
+}
+
+// bypassed reports whether the client IP or the bypass cookie lets the
+// request through the window.
+func (m maintenanceWindow) bypassed(r *http.Request) bool {
+	if ip := net.ParseIP(getRequestInfo(r).clientIP); ip != nil && containsIP(m.bypassNetworks, ip) {
+		return true
+	}
+	if m.BypassSecret == "" {
+		return false
+	}
+	cookie, err := r.Cookie(maintenanceBypassCookie)
+	return err == nil && subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(m.bypassToken())) == 1
+}
+
+// bypassToken is the value of the bypass cookie. It is derived from the secret,
+// so the cookie does not reveal it and changing the secret invalidates it.
+func (m maintenanceWindow) bypassToken() string {
+	mac := hmac.New(sha256.New, []byte(m.BypassSecret))
+	mac.Write([]byte(maintenanceBypassCookie + "|" + m.Domain))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// maintenance answers requests during an active maintenance window with a 503
+// and reports whether the request was handled. Windows are evaluated per
+// request, so scheduled windows start and end without a reload.
+func (rp *ReverseProxy) maintenance(w http.ResponseWriter, r *http.Request, host string, config ProxyConfig) bool {
+	now := time.Now()
+	for _, window := range config.maintenance {
+		if !window.activeAt(now) {
+			continue
+		}
+
+		if r.URL.Path == maintenanceBypassPath && window.BypassSecret != "" {
+			rp.maintenanceBypass(w, r, host, window)
+			return true
+		}
+		if window.bypassed(r) {
+			removeCookie(r, maintenanceBypassCookie)
+			return false
+		}
+
+		if retryAfter := window.retryAfter(now); retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		if window.Message == "" {
+			rp.serveError(w, r, http.StatusServiceUnavailable)
+		} else {
+			rp.serveErrorMessage(w, r, http.StatusServiceUnavailable, window.Message)
+		}
+		return true
+	}
+	return false
+}
+
+// maintenanceBypass shows the form for the bypass secret and sets the bypass
+// cookie if the posted secret is correct. The secret is only accepted in the
+// body, so it does not end up in access logs.
+func (rp *ReverseProxy) maintenanceBypass(w http.ResponseWriter, r *http.Request, host string, window maintenanceWindow) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		io.WriteString(w, maintenanceBypassForm)
+		return
+	}
+
+	clientIP := getRequestInfo(r).clientIP
+	result := rp.rateLimiter.Allow("maintenance|"+host+"|"+clientIP, maintenanceBypassLimit)
+	if !result.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+		rp.serveError(w, r, http.StatusTooManyRequests)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 4096)
+	if subtle.ConstantTimeCompare([]byte(r.PostFormValue("secret")), []byte(window.BypassSecret)) != 1 {
+		log.Printf("Invalid maintenance bypass secret for %s from %s", host, clientIP)
+		rp.serveError(w, r, http.StatusForbidden)
+		return
+	}
+
+	rp.setCookie(w, r, maintenanceBypassCookie, window.bypassToken(), 0)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (m maintenanceWindow) retryAfter(now time.Time) int {
+	if m.RetryAfter > 0 {
+		return m.RetryAfter
+	}
+	if m.End != nil {
+		return ceilSeconds(m.End.Sub(now))
+	}
+	return 0
+}
@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/secnex/reverse-proxy/models"
+)
+
+func TestMaintenance(t *testing.T) {
+	now := time.Now()
+	future, past := now.Add(time.Hour), now.Add(-time.Hour)
+	end := now.Add(90 * time.Second)
+	token := newMaintenanceWindow(models.MaintenanceWindow{Domain: "a.example", BypassSecret: "s3cret"}).bypassToken()
+
+	tests := []struct {
+		name           string
+		window         models.MaintenanceWindow
+		method         string
+		path           string
+		form           string
+		remoteAddr     string
+		cookie         string
+		wantStatus     int
+		wantRetryAfter string
+	}{
+		{name: "immediate", window: models.MaintenanceWindow{RetryAfter: 120}, wantStatus: http.StatusServiceUnavailable, wantRetryAfter: "120"},
+		{name: "retry after end", window: models.MaintenanceWindow{End: &end}, wantStatus: http.StatusServiceUnavailable, wantRetryAfter: "90"},
+		{name: "scheduled", window: models.MaintenanceWindow{Start: &future}, wantStatus: http.StatusOK},
+		{name: "ended", window: models.MaintenanceWindow{Start: &past, End: &past}, wantStatus: http.StatusOK},
+		{name: "bypass ip", window: models.MaintenanceWindow{BypassIPs: []string{"10.1.0.0/16"}}, remoteAddr: "10.1.2.3:1234", wantStatus: http.StatusOK},
+		{name: "other ip", window: models.MaintenanceWindow{BypassIPs: []string{"10.1.0.0/16"}}, remoteAddr: "10.2.0.1:1234", wantStatus: http.StatusServiceUnavailable},
+		{name: "bypass cookie", window: models.MaintenanceWindow{BypassSecret: "s3cret"}, cookie: token, wantStatus: http.StatusOK},
+		{name: "secret as cookie", window: models.MaintenanceWindow{BypassSecret: "s3cret"}, cookie: "s3cret", wantStatus: http.StatusServiceUnavailable},
+		{name: "wrong cookie", window: models.MaintenanceWindow{BypassSecret: "s3cret"}, cookie: "guess", wantStatus: http.StatusServiceUnavailable},
+		{name: "bypass form", window: models.MaintenanceWindow{BypassSecret: "s3cret"}, path: maintenanceBypassPath, wantStatus: http.StatusOK},
+		{name: "bypass secret in query", window: models.MaintenanceWindow{BypassSecret: "s3cret"}, path: maintenanceBypassPath + "?secret=s3cret", wantStatus: http.StatusOK},
+		{name: "bypass", window: models.MaintenanceWindow{BypassSecret: "s3cret"}, method: http.MethodPost, path: maintenanceBypassPath, form: "secret=s3cret", wantStatus: http.StatusSeeOther},
+		{name: "bypass wrong secret", window: models.MaintenanceWindow{BypassSecret: "s3cret"}, method: http.MethodPost, path: maintenanceBypassPath, form: "secret=guess", wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.window.Domain = "a.example"
+			store := &fakeStore{
+				websites:    []models.Website{{Domain: "a.example"}},
+				maintenance: []models.MaintenanceWindow{tt.window},
+			}
+			var upstreamCookie string
+			rp := newTestProxy(t, store, func(w http.ResponseWriter, r *http.Request) {
+				upstreamCookie = r.Header.Get("Cookie")
+			})
+
+			method, path := tt.method, tt.path
+			if method == "" {
+				method = http.MethodGet
+			}
+			if path == "" {
+				path = "/"
+			}
+			r := httptest.NewRequest(method, "http://a.example"+path, strings.NewReader(tt.form))
+			if tt.form != "" {
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			if tt.remoteAddr != "" {
+				r.RemoteAddr = tt.remoteAddr
+			}
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: maintenanceBypassCookie, Value: tt.cookie})
+			}
+
+			w := serve(rp, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, tt.wantRetryAfter)
+			}
+			if upstreamCookie != "" {
+				t.Errorf("upstream Cookie = %q, want the bypass cookie removed", upstreamCookie)
+			}
+			setCookie := w.Header().Get("Set-Cookie")
+			if tt.wantStatus == http.StatusSeeOther && !strings.Contains(setCookie, maintenanceBypassCookie+"="+token) {
+				t.Errorf("Set-Cookie = %q, want the bypass token", setCookie)
+			}
+			if tt.wantStatus != http.StatusSeeOther && setCookie != "" {
+				t.Errorf("Set-Cookie = %q, want none", setCookie)
+			}
+		})
+	}
+}
+
+func TestMaintenanceBypassAttempts(t *testing.T) {
+	store := &fakeStore{
+		websites:    []models.Website{{Domain: "a.example"}},
+		maintenance: []models.MaintenanceWindow{{Domain: "a.example", BypassSecret: "s3cret"}},
+	}
+	rp := newTestProxy(t, store, func(w http.ResponseWriter, r *http.Request) {})
+
+	attempt := func(secret string) int {
+		r := httptest.NewRequest(http.MethodPost, "http://a.example"+maintenanceBypassPath, strings.NewReader("secret="+secret))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return serve(rp, r).Code
+	}
+
+	for i := 0; i < maintenanceBypassLimit.Burst; i++ {
+		if status := attempt("guess"); status != http.StatusForbidden {
+			t.Fatalf("attempt %d: status = %d, want %d", i+1, status, http.StatusForbidden)
+		}
+	}
+	if status := attempt("s3cret"); status != http.StatusTooManyRequests {
+		t.Errorf("status after %d attempts = %d, want %d", maintenanceBypassLimit.Burst, status, http.StatusTooManyRequests)
+	}
+}
+
+func TestLoggedURI(t *testing.T) {
+	tests := []struct {
+		uri  string
+		want string
+	}{
+		{"/search?q=term", "/search?q=term"},
+		{maintenanceBypassPath + "?secret=s3cret", maintenanceBypassPath},
+	}
+	for _, tt := range tests {
+		if got := loggedURI(httptest.NewRequest(http.MethodGet, tt.uri, nil)); got != tt.want {
+			t.Errorf("loggedURI(%q) = %q, want %q", tt.uri, got, tt.want)
+		}
+	}
+}
+
+func TestMaintenanceMessage(t *testing.T) {
+	store := &fakeStore{
+		websites:    []models.Website{{Domain: "a.example"}},
+		maintenance: []models.MaintenanceWindow{{Domain: "a.example", Message: "Back at 4am"}},
+	}
+	rp := newTestProxy(t, store, func(w http.ResponseWriter, r *http.Request) {})
+
+	r := httptest.NewRequest(http.MethodGet, "http://a.example/", nil)
+	r.Header.Set("Accept", "application/json")
+	w := serve(rp, r)
+	if !strings.Contains(w.Body.String(), `"error":"Back at 4am"`) {
+		t.Errorf("body = %s", w.Body.String())
+	}
+	if got := w.Header().Get("Cache-Control"); got != "no-store" {
+		t.Errorf("Cache-Control = %q", got)
+	}
+}
@@ -0,0 +1,34 @@
+package proxy
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/secnex/reverse-proxy/metrics"
+)
+
+func (rp *ReverseProxy) SetMetrics(m *metrics.Metrics) {
+	rp.metrics = m
+}
+
+func (rp *ReverseProxy) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rp.metrics == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		recorder := newResponseRecorder(w)
+		next.ServeHTTP(recorder, r)
+		rp.metrics.ObserveRequest(rp.siteLabel(r), recorder.Status(), time.Since(start))
+	})
+}
+
+func (rp *ReverseProxy) siteLabel(r *http.Request) string {
+	host := hostname(r)
+	if _, exists := rp.configCache.Get(host); exists {
+		return host
+	}
+	return metrics.UnknownSite
+}
@@ -0,0 +1,457 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/secnex/reverse-proxy/models"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcCallbackPath = "/.oidc/callback"
+	oidcLogoutPath   = "/.oidc/logout"
+
+	oidcSessionCookie = "secnex_oidc"
+	oidcStateCookie   = "secnex_oidc_state"
+	oidcStateTTL      = 10 * time.Minute
+
+	// oidcDiscoveryBackoff is how long a failed discovery is served from the
+	// cache before it is retried.
+	oidcDiscoveryBackoff = 30 * time.Second
+
+	forwardedEmailHeader  = "X-Forwarded-Email"
+	forwardedGroupsHeader = "X-Forwarded-Groups"
+)
+
+var errOIDCForbidden = errors.New("user does not match any allow rule")
+
+// oidcHTTPClient is used for discovery, key and token requests. Providers
+// outlive single requests, so their requests must not use a request context.
+var oidcHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+type oidcClient struct {
+	verifier *oidc.IDTokenVerifier
+	oauth2   oauth2.Config
+}
+
+// oidcEntry is the discovery of one issuer and client. Requests arriving
+// while it runs wait for ready instead of starting their own.
+type oidcEntry struct {
+	key     string
+	ready   chan struct{}
+	client  *oidcClient
+	err     error
+	retryAt time.Time
+}
+
+type oidcClients struct {
+	mu      sync.Mutex
+	clients map[string]*oidcEntry
+}
+
+// oidcSession is stored encrypted in the session cookie. It is only issued
+// to users who passed the allow rules.
+type oidcSession struct {
+	Host         string
+	User         string
+	Email        string
+	Groups       []string
+	Expiry       time.Time
+	RefreshToken string
+}
+
+type oidcState struct {
+	State    string
+	Verifier string
+	Nonce    string
+	Redirect string
+	Expiry   time.Time
+}
+
+// client returns the relying party for the site. Discovery runs once per
+// issuer and client without holding the lock, so a slow issuer only delays
+// its own sites. Failed discoveries are retried after oidcDiscoveryBackoff.
+func (c *oidcClients) client(ctx context.Context, config ProxyConfig) (*oidcClient, error) {
+	if config.OIDCIssuer == "" || config.OIDCClientID == "" {
+		return nil, errors.New("OIDC issuer and client ID are required")
+	}
+	secret := sha256.Sum256([]byte(config.OIDCClientSecret))
+	key := strings.Join([]string{config.OIDCIssuer, config.OIDCClientID, hex.EncodeToString(secret[:]), strings.Join(config.OIDCScopes, " ")}, "|")
+	id := config.OIDCIssuer + "|" + config.OIDCClientID
+
+	c.mu.Lock()
+	entry, exists := c.clients[id]
+	if exists && entry.key == key && !entry.expired() {
+		c.mu.Unlock()
+		select {
+		case <-entry.ready:
+			return entry.client, entry.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	entry = &oidcEntry{key: key, ready: make(chan struct{})}
+	c.clients[id] = entry
+	c.mu.Unlock()
+
+	entry.client, entry.err = discoverOIDCClient(config)
+	if entry.err != nil {
+		entry.retryAt = time.Now().Add(oidcDiscoveryBackoff)
+	}
+	close(entry.ready)
+	return entry.client, entry.err
+}
+
+// expired reports whether the entry holds a failed discovery that is due for
+// a retry. It is called with the lock of oidcClients held.
+func (e *oidcEntry) expired() bool {
+	select {
+	case <-e.ready:
+		return e.err != nil && time.Now().After(e.retryAt)
+	default:
+		return false
+	}
+}
+
+func discoverOIDCClient(config ProxyConfig) (*oidcClient, error) {
+	ctx := oidc.ClientContext(context.Background(), oidcHTTPClient)
+	provider, err := oidc.NewProvider(ctx, config.OIDCIssuer)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := []string{oidc.ScopeOpenID, "profile", "email"}
+	if len(config.OIDCScopes) > 0 {
+		scopes = append([]string{oidc.ScopeOpenID}, config.OIDCScopes...)
+	}
+
+	return &oidcClient{
+		verifier: provider.Verifier(&oidc.Config{ClientID: config.OIDCClientID}),
+		oauth2: oauth2.Config{
+			ClientID:     config.OIDCClientID,
+			ClientSecret: config.OIDCClientSecret,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       slices.Compact(scopes),
+		},
+	}, nil
+}
+
+// oauth2Config returns the client configuration with the callback URL of the
+// host the request was made to.
+func (c *oidcClient) oauth2Config(r *http.Request) *oauth2.Config {
+	config := c.oauth2
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	config.RedirectURL = scheme + "://" + r.Host + oidcCallbackPath
+	return &config
+}
+
+// oidcAuth handles the callback and logout paths, refreshes expired sessions
+// and sends users without a valid session to the provider.
+func (rp *ReverseProxy) oidcAuth(w http.ResponseWriter, r *http.Request, host string, config ProxyConfig) bool {
+	client, err := rp.oidc.client(r.Context(), config)
+	if err != nil {
+		log.Printf("OIDC provider %s for %s unavailable: %v", config.OIDCIssuer, host, err)
+		rp.serveError(w, r, http.StatusBadGateway)
+		return true
+	}
+
+	switch r.URL.Path {
+	case oidcCallbackPath:
+		rp.oidcCallback(w, r, host, config, client)
+		return true
+	case oidcLogoutPath:
+		rp.setCookie(w, r, oidcSessionCookie, "", -1)
+		http.Redirect(w, r, "/", http.StatusFound)
+		return true
+	}
+
+	// Clients must not be able to inject the identity headers themselves.
+	r.Header.Del(forwardedUserHeader)
+	r.Header.Del(forwardedEmailHeader)
+	r.Header.Del(forwardedGroupsHeader)
+
+	session, ok := rp.oidcSession(w, r, host, config, client)
+	if !ok {
+		return rp.oidcLogin(w, r, client)
+	}
+
+	removeCookie(r, oidcSessionCookie)
+	r.Header.Set(forwardedUserHeader, session.User)
+	if session.Email != "" {
+		r.Header.Set(forwardedEmailHeader, session.Email)
+	}
+	if len(session.Groups) > 0 {
+		r.Header.Set(forwardedGroupsHeader, strings.Join(session.Groups, ","))
+	}
+	return false
+}
+
+// oidcSession returns the session of the request. Expired sessions are
+// refreshed with the refresh token and the cookie is updated.
+func (rp *ReverseProxy) oidcSession(w http.ResponseWriter, r *http.Request, host string, config ProxyConfig, client *oidcClient) (*oidcSession, bool) {
+	cookie, err := r.Cookie(oidcSessionCookie)
+	if err != nil {
+		return nil, false
+	}
+
+	var session oidcSession
+	if err := rp.sessions.decode(oidcSessionCookie, cookie.Value, &session); err != nil || session.Host != host {
+		return nil, false
+	}
+	if time.Now().Before(session.Expiry) {
+		return &session, true
+	}
+	if session.RefreshToken == "" {
+		return nil, false
+	}
+
+	ctx := oidc.ClientContext(r.Context(), oidcHTTPClient)
+	token, err := client.oauth2Config(r).TokenSource(ctx, &oauth2.Token{RefreshToken: session.RefreshToken}).Token()
+	if err != nil {
+		log.Printf("OIDC refresh for %s failed: %v", host, err)
+		return nil, false
+	}
+
+	refreshed := &session
+	if _, ok := token.Extra("id_token").(string); ok {
+		refreshed, err = rp.newOIDCSession(ctx, host, config, client, token, "")
+		if err != nil {
+			log.Printf("OIDC refresh for %s rejected: %v", host, err)
+			return nil, false
+		}
+	} else {
+		refreshed.Expiry = token.Expiry
+		if token.RefreshToken != "" {
+			refreshed.RefreshToken = token.RefreshToken
+		}
+	}
+	if refreshed.RefreshToken == "" {
+		refreshed.RefreshToken = session.RefreshToken
+	}
+
+	if err := rp.setSessionCookie(w, r, refreshed); err != nil {
+		log.Printf("Error encoding OIDC session for %s: %v", host, err)
+		return nil, false
+	}
+	return refreshed, true
+}
+
+// oidcLogin starts the authorization code flow with PKCE. Only navigations
+// are redirected, other requests are answered with 401.
+func (rp *ReverseProxy) oidcLogin(w http.ResponseWriter, r *http.Request, client *oidcClient) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		rp.serveError(w, r, http.StatusUnauthorized)
+		return true
+	}
+
+	state := oidcState{
+		State:    randomToken(),
+		Verifier: oauth2.GenerateVerifier(),
+		Nonce:    randomToken(),
+		Redirect: r.URL.RequestURI(),
+		Expiry:   time.Now().Add(oidcStateTTL),
+	}
+	value, err := rp.sessions.encode(oidcStateCookie, state)
+	if err != nil {
+		log.Printf("Error encoding OIDC state: %v", err)
+		rp.serveError(w, r, http.StatusInternalServerError)
+		return true
+	}
+	rp.setCookie(w, r, oidcStateCookie, value, int(oidcStateTTL.Seconds()))
+
+	url := client.oauth2Config(r).AuthCodeURL(state.State, oauth2.S256ChallengeOption(state.Verifier), oidc.Nonce(state.Nonce))
+	http.Redirect(w, r, url, http.StatusFound)
+	return true
+}
+
+func (rp *ReverseProxy) oidcCallback(w http.ResponseWriter, r *http.Request, host string, config ProxyConfig, client *oidcClient) {
+	var state oidcState
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || rp.sessions.decode(oidcStateCookie, cookie.Value, &state) != nil || time.Now().After(state.Expiry) {
+		rp.serveError(w, r, http.StatusBadRequest)
+		return
+	}
+	rp.setCookie(w, r, oidcStateCookie, "", -1)
+
+	query := r.URL.Query()
+	if errorCode := query.Get("error"); errorCode != "" {
+		log.Printf("OIDC login for %s failed: %s %s", host, errorCode, query.Get("error_description"))
+		rp.serveError(w, r, http.StatusUnauthorized)
+		return
+	}
+	if query.Get("state") != state.State {
+		rp.serveError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	ctx := oidc.ClientContext(r.Context(), oidcHTTPClient)
+	token, err := client.oauth2Config(r).Exchange(ctx, query.Get("code"), oauth2.VerifierOption(state.Verifier))
+	if err != nil {
+		log.Printf("OIDC code exchange for %s failed: %v", host, err)
+		rp.serveError(w, r, http.StatusBadGateway)
+		return
+	}
+
+	session, err := rp.newOIDCSession(ctx, host, config, client, token, state.Nonce)
+	if err != nil {
+		log.Printf("OIDC login for %s rejected: %v", host, err)
+		if errors.Is(err, errOIDCForbidden) {
+			rp.serveError(w, r, http.StatusForbidden)
+			return
+		}
+		rp.serveError(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	if err := rp.setSessionCookie(w, r, session); err != nil {
+		log.Printf("Error encoding OIDC session for %s: %v", host, err)
+		rp.serveError(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, localRedirect(state.Redirect), http.StatusFound)
+}
+
+// localRedirect returns the path to continue with after the login, or "/" if
+// it could lead to another host. Browsers treat a backslash like a slash, so
+// "/\evil.example" is as dangerous as "//evil.example".
+func localRedirect(redirect string) string {
+	u, err := url.Parse(redirect)
+	if err != nil || u.Scheme != "" || u.Host != "" || !strings.HasPrefix(redirect, "/") {
+		return "/"
+	}
+	if len(redirect) > 1 && (redirect[1] == '/' || redirect[1] == '\\') {
+		return "/"
+	}
+	return redirect
+}
+
+// newOIDCSession verifies the ID token of the token response and checks the
+// allow rules of the site against its claims.
+func (rp *ReverseProxy) newOIDCSession(ctx context.Context, host string, config ProxyConfig, client *oidcClient, token *oauth2.Token, nonce string) (*oidcSession, error) {
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("token response without id_token")
+	}
+	idToken, err := client.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+	if nonce != "" && idToken.Nonce != nonce {
+		return nil, errors.New("invalid nonce")
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+	if !oidcAllowed(config.OIDCAllowRules, claims) {
+		return nil, fmt.Errorf("%s: %w", idToken.Subject, errOIDCForbidden)
+	}
+
+	session := &oidcSession{
+		Host:         host,
+		User:         idToken.Subject,
+		Expiry:       idToken.Expiry,
+		RefreshToken: token.RefreshToken,
+	}
+	if username, ok := claims["preferred_username"].(string); ok && username != "" {
+		session.User = username
+	}
+	if email, ok := claims["email"].(string); ok {
+		session.Email = email
+	}
+	session.Groups = claimValues(claims["groups"])
+	return session, nil
+}
+
+func oidcAllowed(rules []models.OIDCAllowRule, claims map[string]interface{}) bool {
+	if len(rules) == 0 {
+		return true
+	}
+	for _, rule := range rules {
+		for _, value := range claimValues(claims[rule.Claim]) {
+			if slices.Contains(rule.Values, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// claimValues returns a claim as list of strings, both single values and
+// arrays are supported.
+func claimValues(claim interface{}) []string {
+	switch value := claim.(type) {
+	case nil:
+		return nil
+	case string:
+		return []string{value}
+	case []interface{}:
+		var values []string
+		for _, item := range value {
+			values = append(values, fmt.Sprint(item))
+		}
+		return values
+	default:
+		return []string{fmt.Sprint(value)}
+	}
+}
+
+func (rp *ReverseProxy) setSessionCookie(w http.ResponseWriter, r *http.Request, session *oidcSession) error {
+	value, err := rp.sessions.encode(oidcSessionCookie, session)
+	if err != nil {
+		return err
+	}
+	rp.setCookie(w, r, oidcSessionCookie, value, 0)
+	return nil
+}
+
+func (rp *ReverseProxy) setCookie(w http.ResponseWriter, r *http.Request, name string, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// removeCookie drops a cookie of the proxy from the request, so it is not
+// sent upstream.
+func removeCookie(r *http.Request, name string) {
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if cookie.Name != name {
+			r.AddCookie(cookie)
+		}
+	}
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("Error generating random token: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
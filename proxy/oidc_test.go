@@ -0,0 +1,383 @@
+package proxy
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/secnex/reverse-proxy/models"
+)
+
+// testIssuer is a minimal OpenID provider. Codes are registered by the test,
+// which plays the browser, with the PKCE challenge and nonce of the login.
+type testIssuer struct {
+	*httptest.Server
+	key         *rsa.PrivateKey
+	discoveries atomic.Int32
+	failing     bool
+	block       chan struct{}
+
+	mu    sync.Mutex
+	codes map[string]testLogin
+}
+
+type testLogin struct {
+	challenge string
+	nonce     string
+	groups    []string
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &testIssuer{key: key, codes: make(map[string]testLogin)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		issuer.discoveries.Add(1)
+		if issuer.block != nil {
+			<-issuer.block
+		}
+		if issuer.failing {
+			http.Error(w, "unavailable", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                issuer.URL,
+			"authorization_endpoint":                issuer.URL + "/authorize",
+			"token_endpoint":                        issuer.URL + "/token",
+			"jwks_uri":                              issuer.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": "test",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", issuer.token)
+	issuer.Server = httptest.NewServer(mux)
+	t.Cleanup(func() {
+		if issuer.block != nil {
+			select {
+			case <-issuer.block:
+			default:
+				close(issuer.block)
+			}
+		}
+		issuer.Close()
+	})
+	return issuer
+}
+
+func (issuer *testIssuer) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	issuer.mu.Lock()
+	login, exists := issuer.codes[r.PostForm.Get("code")]
+	delete(issuer.codes, r.PostForm.Get("code"))
+	issuer.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !exists || base64.RawURLEncoding.EncodeToString(verifier[:]) != login.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "invalid_grant"}`))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token": issuer.sign(map[string]any{
+			"iss":                issuer.URL,
+			"sub":                "user-1",
+			"aud":                "proxy",
+			"iat":                time.Now().Unix(),
+			"exp":                time.Now().Add(time.Hour).Unix(),
+			"nonce":              login.nonce,
+			"preferred_username": "alice",
+			"email":              "alice@example.com",
+			"groups":             login.groups,
+		}),
+	})
+}
+
+func (issuer *testIssuer) sign(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, _ := rsa.SignPKCS1v15(rand.Reader, issuer.key, crypto.SHA256, digest[:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (issuer *testIssuer) register(code string, login testLogin) {
+	issuer.mu.Lock()
+	defer issuer.mu.Unlock()
+	issuer.codes[code] = login
+}
+
+func oidcWebsite(domain string, issuer string, rules ...models.OIDCAllowRule) models.Website {
+	return models.Website{
+		Domain:           domain,
+		AuthMode:         AuthOIDC,
+		OIDCIssuer:       issuer,
+		OIDCClientID:     "proxy",
+		OIDCClientSecret: "secret",
+		OIDCAllowRules:   rules,
+	}
+}
+
+func responseCookie(w *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+// startLogin requests a protected page and returns the authorization request
+// and the state cookie.
+func startLogin(t *testing.T, rp *ReverseProxy, target string) (url.Values, *http.Cookie) {
+	t.Helper()
+	w := serve(rp, httptest.NewRequest(http.MethodGet, target, nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("login status = %d, want 302", w.Code)
+	}
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stateCookie := responseCookie(w, oidcStateCookie)
+	if stateCookie == nil {
+		t.Fatal("no state cookie")
+	}
+	return location.Query(), stateCookie
+}
+
+func callback(rp *ReverseProxy, host string, code string, state string, stateCookie *http.Cookie) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "http://"+host+oidcCallbackPath+"?code="+code+"&state="+url.QueryEscape(state), nil)
+	if stateCookie != nil {
+		r.AddCookie(stateCookie)
+	}
+	return serve(rp, r)
+}
+
+func TestOIDCLogin(t *testing.T) {
+	issuer := newTestIssuer(t)
+	var upstream http.Header
+	rp := newTestProxy(t, &fakeStore{websites: []models.Website{oidcWebsite("a.example", issuer.URL)}}, func(w http.ResponseWriter, r *http.Request) {
+		upstream = r.Header.Clone()
+	})
+
+	query, stateCookie := startLogin(t, rp, "http://a.example/private?x=1")
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Errorf("authorization request without PKCE: %v", query)
+	}
+	if query.Get("redirect_uri") != "http://a.example"+oidcCallbackPath {
+		t.Errorf("redirect_uri = %q", query.Get("redirect_uri"))
+	}
+
+	issuer.register("code-1", testLogin{challenge: query.Get("code_challenge"), nonce: query.Get("nonce"), groups: []string{"admins"}})
+	w := callback(rp, "a.example", "code-1", query.Get("state"), stateCookie)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/private?x=1" {
+		t.Fatalf("callback = %d %q, want redirect to the original page", w.Code, w.Header().Get("Location"))
+	}
+	session := responseCookie(w, oidcSessionCookie)
+	if session == nil || !session.HttpOnly {
+		t.Fatalf("session cookie = %+v", session)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "http://a.example/private", nil)
+	r.AddCookie(session)
+	r.AddCookie(&http.Cookie{Name: "app", Value: "1"})
+	if w := serve(rp, r); w.Code != http.StatusOK {
+		t.Fatalf("authenticated status = %d", w.Code)
+	}
+	if upstream.Get(forwardedUserHeader) != "alice" || upstream.Get(forwardedEmailHeader) != "alice@example.com" || upstream.Get(forwardedGroupsHeader) != "admins" {
+		t.Errorf("identity headers = %v", upstream)
+	}
+	if cookie := upstream.Get("Cookie"); strings.Contains(cookie, oidcSessionCookie) || !strings.Contains(cookie, "app=1") {
+		t.Errorf("upstream Cookie = %q", cookie)
+	}
+}
+
+func TestOIDCCallbackRejected(t *testing.T) {
+	issuer := newTestIssuer(t)
+	rp := newTestProxy(t, &fakeStore{websites: []models.Website{
+		oidcWebsite("a.example", issuer.URL),
+		oidcWebsite("b.example", issuer.URL, models.OIDCAllowRule{Claim: "groups", Values: []string{"ops"}}),
+	}}, func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name   string
+		host   string
+		code   func(query url.Values) (string, testLogin)
+		state  func(query url.Values) string
+		cookie bool
+		want   int
+	}{
+		{
+			name:   "wrong state",
+			host:   "a.example",
+			state:  func(query url.Values) string { return "forged" },
+			cookie: true,
+			want:   http.StatusBadRequest,
+		},
+		{
+			name:  "missing state cookie",
+			host:  "a.example",
+			state: func(query url.Values) string { return query.Get("state") },
+			want:  http.StatusBadRequest,
+		},
+		{
+			name: "wrong PKCE verifier",
+			host: "a.example",
+			code: func(query url.Values) (string, testLogin) {
+				return "code-pkce", testLogin{challenge: "other", nonce: query.Get("nonce")}
+			},
+			state:  func(query url.Values) string { return query.Get("state") },
+			cookie: true,
+			want:   http.StatusBadGateway,
+		},
+		{
+			name: "wrong nonce",
+			host: "a.example",
+			code: func(query url.Values) (string, testLogin) {
+				return "code-nonce", testLogin{challenge: query.Get("code_challenge"), nonce: "replayed"}
+			},
+			state:  func(query url.Values) string { return query.Get("state") },
+			cookie: true,
+			want:   http.StatusUnauthorized,
+		},
+		{
+			name: "allow rule",
+			host: "b.example",
+			code: func(query url.Values) (string, testLogin) {
+				return "code-rule", testLogin{challenge: query.Get("code_challenge"), nonce: query.Get("nonce"), groups: []string{"admins"}}
+			},
+			state:  func(query url.Values) string { return query.Get("state") },
+			cookie: true,
+			want:   http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, stateCookie := startLogin(t, rp, "http://"+tt.host+"/")
+			code := "unknown"
+			if tt.code != nil {
+				var login testLogin
+				code, login = tt.code(query)
+				issuer.register(code, login)
+			}
+			if !tt.cookie {
+				stateCookie = nil
+			}
+			w := callback(rp, tt.host, code, tt.state(query), stateCookie)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			if responseCookie(w, oidcSessionCookie) != nil {
+				t.Error("session cookie issued")
+			}
+		})
+	}
+}
+
+func TestOIDCDiscoveryFailureIsCached(t *testing.T) {
+	issuer := newTestIssuer(t)
+	issuer.failing = true
+	rp := newTestProxy(t, &fakeStore{websites: []models.Website{oidcWebsite("a.example", issuer.URL)}}, func(w http.ResponseWriter, r *http.Request) {})
+
+	for i := 0; i < 3; i++ {
+		if w := serve(rp, httptest.NewRequest(http.MethodGet, "http://a.example/", nil)); w.Code != http.StatusBadGateway {
+			t.Fatalf("status = %d, want 502", w.Code)
+		}
+	}
+	if got := issuer.discoveries.Load(); got != 1 {
+		t.Errorf("discoveries = %d, want 1 within the backoff", got)
+	}
+}
+
+func TestOIDCSlowIssuerDoesNotBlockOtherSites(t *testing.T) {
+	slow := newTestIssuer(t)
+	slow.block = make(chan struct{})
+	fast := newTestIssuer(t)
+	rp := newTestProxy(t, &fakeStore{websites: []models.Website{
+		oidcWebsite("slow.example", slow.URL),
+		oidcWebsite("fast.example", fast.URL),
+	}}, func(w http.ResponseWriter, r *http.Request) {})
+
+	done := make(chan int)
+	go func() {
+		done <- serve(rp, httptest.NewRequest(http.MethodGet, "http://slow.example/", nil)).Code
+	}()
+	for slow.discoveries.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	fastDone := make(chan int)
+	go func() {
+		fastDone <- serve(rp, httptest.NewRequest(http.MethodGet, "http://fast.example/", nil)).Code
+	}()
+	select {
+	case code := <-fastDone:
+		if code != http.StatusFound {
+			t.Errorf("fast site status = %d, want 302", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("fast site waits for the discovery of the slow issuer")
+	}
+
+	close(slow.block)
+	if code := <-done; code != http.StatusFound {
+		t.Errorf("slow site status = %d, want 302", code)
+	}
+}
+
+func TestLocalRedirect(t *testing.T) {
+	tests := []struct {
+		redirect string
+		want     string
+	}{
+		{"/private?x=1", "/private?x=1"},
+		{"/", "/"},
+		{"", "/"},
+		{"//evil.example", "/"},
+		{`/\evil.example`, "/"},
+		{`/\/evil.example`, "/"},
+		{"https://evil.example/", "/"},
+		{"evil.example", "/"},
+		{"javascript:alert(1)", "/"},
+		{"/a//b", "/a//b"},
+	}
+	for _, tt := range tests {
+		if got := localRedirect(tt.redirect); got != tt.want {
+			t.Errorf("localRedirect(%q) = %q, want %q", tt.redirect, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"github.com/secnex/reverse-proxy/templates"
+)
+
+func (rp *ReverseProxy) SetPages(pages *templates.Pages) {
+	rp.pages = pages
+}
+
+// serveIndex serves the start page of the proxy for requests to localhost.
+func (rp *ReverseProxy) serveIndex(w http.ResponseWriter, r *http.Request) {
+	content, exists := rp.pages.Rendered("index.html")
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeContent(w, r, "index.html", time.Time{}, bytes.NewReader(content))
+}
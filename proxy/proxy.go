@@ -1,36 +1,121 @@
 package proxy
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/secnex/reverse-proxy/accesslog"
 	"github.com/secnex/reverse-proxy/cert"
+	"github.com/secnex/reverse-proxy/config"
+	"github.com/secnex/reverse-proxy/geoip"
+	"github.com/secnex/reverse-proxy/metrics"
+	"github.com/secnex/reverse-proxy/ratelimit"
 	"github.com/secnex/reverse-proxy/server"
+	"github.com/secnex/reverse-proxy/templates"
+	"github.com/secnex/reverse-proxy/tracing"
+	"github.com/secnex/reverse-proxy/waf"
 )
 
 type ReverseProxy struct {
-	configCache *ConfigCache
-	certManager *cert.CertManager
-	apiServer   *server.APIServer
+	configCache    *ConfigCache
+	certManager    *cert.CertManager
+	siteCerts      *siteCertificates
+	apiServer      *server.APIServer
+	client         *http.Client
+	affinity       *affinitySigner
+	limits         config.Limits
+	pages          *templates.Pages
+	staticDir      string
+	network        string
+	httpsPort      string
+	accessLogger   *accesslog.Logger
+	metrics        *metrics.Metrics
+	tracer         *tracing.Tracer
+	trustedProxies []*net.IPNet
+	rateLimiter    *ratelimit.Limiter
+	geoip          *geoip.Database
+	credentials    *credentialCache
+	authClient     *http.Client
+	sessions       *sessionCodec
+	oidc           *oidcClients
+	inspector      waf.Inspector
+	cache          *ResponseCache
+	pools          map[string]*poolEntry
+	poolsMu        sync.Mutex
 }
 
-func NewReverseProxy(configCache *ConfigCache, certManager *cert.CertManager, apiServer *server.APIServer) *ReverseProxy {
+type poolEntry struct {
+	key  string
+	pool *UpstreamPool
+}
+
+func NewReverseProxy(configCache *ConfigCache, certManager *cert.CertManager, apiServer *server.APIServer, cfg *config.Config) *ReverseProxy {
 	return &ReverseProxy{
-		configCache: configCache,
-		certManager: certManager,
-		apiServer:   apiServer,
+		configCache:    configCache,
+		certManager:    certManager,
+		siteCerts:      newSiteCertificates(),
+		apiServer:      apiServer,
+		client:         &http.Client{},
+		affinity:       newAffinitySigner(cfg.AffinitySecret),
+		limits:         cfg.Limits,
+		staticDir:      cfg.StaticDir,
+		network:        cfg.Network(),
+		httpsPort:      cfg.HTTPSPort(),
+		trustedProxies: parseNetworks(cfg.TrustedProxies, "trusted proxy"),
+		rateLimiter:    ratelimit.New(),
+		credentials:    newCredentialCache(),
+		authClient:     newAuthClient(),
+		sessions:       newSessionCodec(cfg.SessionSecret),
+		oidc:           &oidcClients{clients: make(map[string]*oidcEntry)},
+		inspector:      waf.DefaultRules(),
+		pools:          make(map[string]*poolEntry),
+	}
+}
+
+// pool returns the upstream pool for a host and rebuilds it whenever the
+// configured targets change. Health state survives as long as the targets do.
+func (rp *ReverseProxy) pool(host string, config ProxyConfig) *UpstreamPool {
+	key := config.Protocol + "://" + config.Host + ":" + strconv.Itoa(config.Port) + "|" + strings.Join(config.Upstreams, ",")
+
+	rp.poolsMu.Lock()
+	defer rp.poolsMu.Unlock()
+
+	if entry, exists := rp.pools[host]; exists && entry.key == key {
+		return entry.pool
 	}
+
+	pool := NewUpstreamPool(config.Targets())
+	rp.pools[host] = &poolEntry{key: key, pool: pool}
+	return pool
+}
+
+// Handler returns the proxy wrapped in its middlewares.
+func (rp *ReverseProxy) Handler() http.Handler {
+	handler := rp.trace(rp.instrument(rp.accessLog(rp)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, info := withRequestInfo(r)
+		info.requestID = rp.requestID(r)
+		info.clientIP = rp.clientIP(r)
+
+		r.Header.Set(requestIDHeader, info.requestID)
+		w.Header().Set(requestIDHeader, info.requestID)
+		handler.ServeHTTP(w, r)
+	})
 }
 
 func (rp *ReverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	host := r.Host
+	host := hostname(r)
 	if strings.Contains(host, "%") {
 		host = strings.Split(host, "%")[0]
 	}
@@ -41,69 +126,199 @@ func (rp *ReverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if host == "localhost" || host == "127.0.0.1" {
-		http.ServeFile(w, r, "www/index.html")
+		rp.serveIndex(w, r)
 		return
 	}
 
 	config, exists := rp.configCache.Get(host)
 	if !exists {
-		contentType := r.Header.Get("Content-Type")
-		if contentType != "application/json" {
-			http.ServeFile(w, r, "www/404.html")
-		} else {
-			w.WriteHeader(http.StatusNotFound)
-			w.Write([]byte(`{"error": "Host not found"}`))
-		}
+		rp.writeError(w, r, errorPage{Status: http.StatusNotFound, Message: hostNotFoundMessage})
 		return
 	}
 
 	if !rp.apiServer.IsActiveConfig(host) {
-		contentType := r.Header.Get("Content-Type")
-		if contentType != "application/json" {
-			http.ServeFile(w, r, "www/503.html")
-		} else {
-			w.WriteHeader(http.StatusServiceUnavailable)
-			w.Write([]byte(`{"error": "Service Unavailable"}`))
-		}
+		rp.serveError(w, r, http.StatusServiceUnavailable)
 		return
 	}
 
-	req, err := http.NewRequest(r.Method, config.Protocol+"://"+config.Host+":"+strconv.Itoa(config.Port)+r.URL.Path, r.Body)
-	if err != nil {
-		contentType := r.Header.Get("Content-Type")
-		if contentType != "application/json" {
-			http.ServeFile(w, r, "www/503.html")
-		} else {
-			w.WriteHeader(http.StatusServiceUnavailable)
-			w.Write([]byte(`{"error": "Service Unavailable"}`))
-		}
+	if r.ProtoMajor == 3 && !config.HTTP3 {
+		rp.serveError(w, r, http.StatusMisdirectedRequest)
 		return
 	}
 
-	for key, values := range r.Header {
-		for _, value := range values {
-			req.Header.Add(key, value)
-		}
+	if rp.checkAccess(w, r, host, config) {
+		return
 	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		contentType := r.Header.Get("Content-Type")
-		if contentType != "application/json" {
-			http.ServeFile(w, r, "www/502.html")
-		} else {
-			w.WriteHeader(http.StatusBadGateway)
-			w.Write([]byte(`{"error": "Bad Gateway"}`))
+	if rp.enforceHTTPS(w, r, host, config) {
+		return
+	}
+
+	if rp.maintenance(w, r, host, config) {
+		return
+	}
+
+	if config.MaxHeaderSize > 0 && headerSize(r) > config.MaxHeaderSize {
+		rp.serveError(w, r, http.StatusRequestHeaderFieldsTooLarge)
+		return
+	}
+
+	if rp.checkRateLimit(w, r, host, config) {
+		return
+	}
+
+	if rp.redirect(w, r, config) {
+		return
+	}
+
+	if rp.authenticate(w, r, host, config) {
+		return
+	}
+
+	var body *requestBody
+	if r.Body != http.NoBody {
+		if maxBody := rp.maxBodyBytes(config); maxBody > 0 {
+			if r.ContentLength > maxBody {
+				rp.serveError(w, r, http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, maxBody)
 		}
+		body = &requestBody{ReadCloser: r.Body}
+		r.Body = body
+	}
+
+	if rp.inspect(w, r, host, config) {
+		return
+	}
+
+	if config.Type == SiteStatic {
+		rp.serveStatic(w, r, config)
+		return
+	}
+
+	header := upstreamHeader(r)
+	applyHeaderRules(header, r, config, HeaderRequest)
+
+	if rp.cacheable(r, config) {
+		rp.serveCached(w, r, host, config, header)
+		return
+	}
+	rp.forward(w, r, host, config, header, body)
+}
+
+// forward proxies the request upstream and streams the response back.
+func (rp *ReverseProxy) forward(w http.ResponseWriter, r *http.Request, host string, config ProxyConfig, header http.Header, body *requestBody) {
+	ctx, cancel := context.WithCancelCause(r.Context())
+	defer cancel(nil)
+
+	resp, target, pinned, status := rp.roundTrip(ctx, cancel, r, host, config, header, body)
+	if status != 0 {
+		rp.serveError(w, r, status)
 		return
 	}
 	defer resp.Body.Close()
 
-	for key, values := range resp.Header {
-		for _, value := range values {
-			w.Header().Add(key, value)
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		rp.serveUpgrade(w, r, resp)
+		return
+	}
+
+	if rp.cacheEnabled(config) {
+		w.Header().Set(cacheStatusHeader, cacheBypass)
+		rp.cache.invalidate(r, host, resp)
+	}
+
+	if config.Affinity == AffinityCookie && !pinned {
+		rp.setAffinityCookie(w, r, config, target)
+	}
+	rp.writeResponse(w, r, config, resp)
+}
+
+// roundTrip sends the request to the pinned or next upstream target. Requests
+// without a body are retried on other targets if retrying is safe, see
+// retryable. If no response was received, the status of the error page to
+// serve is returned instead.
+func (rp *ReverseProxy) roundTrip(ctx context.Context, cancel context.CancelCauseFunc, r *http.Request, host string, config ProxyConfig, header http.Header, body *requestBody) (*http.Response, Target, bool, int) {
+	pool := rp.pool(host, config)
+	target, pinned := rp.pinnedTarget(r, config, pool)
+	if !pinned {
+		target, _ = pool.Next(nil)
+	}
+
+	info := getRequestInfo(r)
+	tried := make(map[string]bool)
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, r.Method, target.URL()+r.URL.RequestURI(), r.Body)
+		if err != nil {
+			return nil, target, false, http.StatusServiceUnavailable
+		}
+
+		req.Header = header.Clone()
+
+		// The upstream timeout only covers waiting for the response headers,
+		// streaming the body afterwards is bounded by the server write timeout.
+		timer := time.AfterFunc(rp.upstreamTimeout(config), func() {
+			cancel(errUpstreamTimeout)
+		})
+		info.upstream = target.URL()
+		span := rp.tracer.StartClient(ctx, req, attempt)
+		resp, err := rp.client.Do(req)
+		timer.Stop()
+		tracing.EndClient(span, resp, err)
+		if err == nil {
+			return resp, target, pinned, 0
+		}
+
+		if status := clientErrorStatus(body); status != 0 {
+			return nil, target, false, status
+		}
+
+		log.Printf("Upstream %s for %s failed: %v", target.URL(), host, err)
+		rp.metrics.UpstreamError(host)
+		pool.MarkDown(target)
+
+		if errors.Is(context.Cause(ctx), errUpstreamTimeout) {
+			return nil, target, false, http.StatusGatewayTimeout
+		}
+
+		tried[target.ID()] = true
+		next, ok := pool.Next(tried)
+		if body != nil || !ok || !retryable(r.Method, err) {
+			return nil, target, false, http.StatusBadGateway
 		}
+		target = next
+		pinned = false
+		info.retries++
+	}
+}
+
+// retryable reports whether a failed request may be sent to another target.
+// Requests with safe methods can always be repeated. Any other request is only
+// retried if the connection could not be established, so the upstream never
+// received it.
+func retryable(method string, err error) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// writeResponse writes an upstream or cached response to the client.
+func (rp *ReverseProxy) writeResponse(w http.ResponseWriter, r *http.Request, config ProxyConfig, resp *http.Response) {
+	if rp.interceptError(w, r, config, resp) {
+		return
+	}
+
+	copyHeader(w.Header(), resp.Header)
+	w.Header().Set(requestIDHeader, getRequestInfo(r).requestID)
+	setHSTS(w, r, config)
+	applyHeaderRules(w.Header(), r, config, HeaderResponse)
+
+	if encoding := compressionEncoding(w, r, resp, config); encoding != "" && writeCompressed(w, resp, encoding, config.CompressionLevel) {
+		return
 	}
 
 	w.WriteHeader(resp.StatusCode)
@@ -123,42 +338,76 @@ func isValidHost(host string) bool {
 	return false
 }
 
-func (rp *ReverseProxy) Start(port int, useSSL bool, certFile, keyFile string) error {
+func (rp *ReverseProxy) Start(listener config.Listener, useSSL bool) error {
+	handler := rp.Handler()
+	if useSSL && listener.HTTP3 {
+		handler = rp.advertiseHTTP3(handler, listener)
+	}
+
 	server := &http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
-		Handler: rp,
+		Handler:           handler,
+		ReadHeaderTimeout: rp.limits.ReadHeaderTimeout.Duration,
+		ReadTimeout:       rp.limits.ReadTimeout.Duration,
+		WriteTimeout:      rp.limits.WriteTimeout.Duration,
+		IdleTimeout:       rp.limits.IdleTimeout.Duration,
+		MaxHeaderBytes:    rp.limits.MaxHeaderBytes,
+		ConnState:         rp.metrics.ConnState,
 	}
 
 	if useSSL {
-		if cert, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil {
-			if len(cert.Certificate) > 0 {
-				if x509Cert, err := x509.ParseCertificate(cert.Certificate[0]); err == nil {
-					if time.Now().Before(x509Cert.NotAfter) {
-						server.TLSConfig = &tls.Config{
-							Certificates: []tls.Certificate{cert},
-						}
-						return server.ListenAndServeTLS("", "")
-					}
-				}
-			}
-		}
-
-		var err error
-		certFile, keyFile, err = rp.certManager.GenerateSelfSignedCert("localhost", "ssl@example.local")
+		tlsConfig, err := rp.tlsConfig(listener)
 		if err != nil {
-			return fmt.Errorf("error generating self-signed certificate: %v", err)
+			return err
 		}
+		server.TLSConfig = tlsConfig
+	}
 
-		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
-		if err != nil {
-			return fmt.Errorf("error loading certificates: %v", err)
-		}
+	ln, err := net.Listen(rp.network, listener.Address)
+	if err != nil {
+		return fmt.Errorf("error listening on %s: %v", listener.Address, err)
+	}
 
-		server.TLSConfig = &tls.Config{
-			Certificates: []tls.Certificate{cert},
+	if useSSL {
+		return server.ServeTLS(ln, "", "")
+	}
+	return server.Serve(ln)
+}
+
+// tlsConfig returns the TLS configuration of an HTTPS listener. The HTTP/3
+// listener on the same address uses it as well. Websites get their own
+// certificate by SNI, the listener certificate is the fallback.
+func (rp *ReverseProxy) tlsConfig(listener config.Listener) (*tls.Config, error) {
+	cert, err := rp.loadListenerCertificate(listener)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates:   []tls.Certificate{cert},
+		GetCertificate: rp.getCertificate,
+	}, nil
+}
+
+// loadListenerCertificate loads the configured certificate of the listener and
+// falls back to a self-signed localhost certificate if it is missing or expired.
+func (rp *ReverseProxy) loadListenerCertificate(listener config.Listener) (tls.Certificate, error) {
+	if cert, err := tls.LoadX509KeyPair(listener.CertFile, listener.KeyFile); err == nil {
+		if len(cert.Certificate) > 0 {
+			if x509Cert, err := x509.ParseCertificate(cert.Certificate[0]); err == nil {
+				if time.Now().Before(x509Cert.NotAfter) {
+					return cert, nil
+				}
+			}
 		}
-		return server.ListenAndServeTLS("", "")
 	}
 
-	return server.ListenAndServe()
+	certFile, keyFile, err := rp.certManager.GenerateSelfSignedCert("localhost", "ssl@example.local")
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("error generating self-signed certificate: %v", err)
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("error loading certificates: %v", err)
+	}
+	return cert, nil
 }
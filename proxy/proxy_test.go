@@ -0,0 +1,205 @@
+package proxy
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/secnex/reverse-proxy/cert"
+	"github.com/secnex/reverse-proxy/config"
+	"github.com/secnex/reverse-proxy/models"
+	"github.com/secnex/reverse-proxy/server"
+)
+
+// fakeStore serves the configuration of the tests instead of the database.
+type fakeStore struct {
+	websites       []models.Website
+	users          []models.BasicAuthUser
+	headerRules    []models.HeaderRule
+	redirectRules  []models.RedirectRule
+	errorTemplates []models.ErrorTemplate
+	maintenance    []models.MaintenanceWindow
+}
+
+func (s *fakeStore) GetAllWebsites() ([]models.Website, error) { return s.websites, nil }
+
+func (s *fakeStore) GetAllBasicAuthUsers() ([]models.BasicAuthUser, error) { return s.users, nil }
+
+func (s *fakeStore) GetAllHeaderRules() ([]models.HeaderRule, error) { return s.headerRules, nil }
+
+func (s *fakeStore) GetAllRedirectRules() ([]models.RedirectRule, error) {
+	return s.redirectRules, nil
+}
+
+func (s *fakeStore) GetAllErrorTemplates() ([]models.ErrorTemplate, error) {
+	return s.errorTemplates, nil
+}
+
+func (s *fakeStore) GetAllMaintenanceWindows() ([]models.MaintenanceWindow, error) {
+	return s.maintenance, nil
+}
+
+// newTestProxy loads the websites of the store, pointing those without host
+// at the upstream handler, and activates them.
+func newTestProxy(t *testing.T, store *fakeStore, upstream http.HandlerFunc) *ReverseProxy {
+	t.Helper()
+
+	backend := httptest.NewServer(upstream)
+	t.Cleanup(backend.Close)
+	backendURL, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, _ := strconv.Atoi(backendURL.Port())
+	for i := range store.websites {
+		if store.websites[i].Host == "" {
+			store.websites[i].Protocol = "http"
+			store.websites[i].Host = backendURL.Hostname()
+			store.websites[i].Port = port
+		}
+		store.websites[i].Active = true
+	}
+
+	certDir := t.TempDir()
+	for _, dir := range []string{"self", "acme"} {
+		if err := os.Mkdir(filepath.Join(certDir, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	certManager := cert.NewCertManager(certDir)
+	configCache := NewConfigCache(nil, certManager)
+	configCache.db = store
+	if err := configCache.LoadFromDB(); err != nil {
+		t.Fatalf("LoadFromDB: %v", err)
+	}
+
+	apiServer := server.NewAPIServer(config.APIConfig{})
+	for host := range configCache.GetAll() {
+		apiServer.SetActiveConfig(host, true)
+	}
+	return NewReverseProxy(configCache, certManager, apiServer, config.Default())
+}
+
+// serve sends the request through the middlewares of the proxy.
+func serve(rp *ReverseProxy, r *http.Request) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	rp.Handler().ServeHTTP(recorder, r)
+	return recorder
+}
+
+func TestLoadFromDBAttachesSiteRules(t *testing.T) {
+	store := &fakeStore{
+		websites:       []models.Website{{Domain: "a.example"}, {Domain: "b.example"}},
+		users:          []models.BasicAuthUser{{Domain: "a.example", Username: "alice", PasswordHash: "hash"}},
+		headerRules:    []models.HeaderRule{{Domain: "a.example", Direction: "response", Action: "set", Name: "X-Test", Value: "1"}},
+		redirectRules:  []models.RedirectRule{{Domain: "a.example", Source: "/old", Target: "/new"}},
+		errorTemplates: []models.ErrorTemplate{{Domain: "a.example", Status: 404, Format: ErrorFormatHTML, Template: "missing"}},
+		maintenance:    []models.MaintenanceWindow{{Domain: "a.example", Message: "down"}},
+	}
+	rp := newTestProxy(t, store, func(w http.ResponseWriter, r *http.Request) {})
+
+	a, exists := rp.configCache.Get("a.example")
+	if !exists {
+		t.Fatal("a.example not loaded")
+	}
+	if a.basicAuthUsers["alice"] != "hash" {
+		t.Errorf("basicAuthUsers = %v", a.basicAuthUsers)
+	}
+	if len(a.headerRules) != 1 || len(a.redirectRules) != 1 || len(a.errorTemplates) != 1 || len(a.maintenance) != 1 {
+		t.Errorf("rules not attached: headers %d, redirects %d, error templates %d, maintenance %d",
+			len(a.headerRules), len(a.redirectRules), len(a.errorTemplates), len(a.maintenance))
+	}
+
+	b, _ := rp.configCache.Get("b.example")
+	if b.basicAuthUsers != nil || b.headerRules != nil || b.redirectRules != nil || b.errorTemplates != nil || b.maintenance != nil {
+		t.Errorf("rules of a.example attached to b.example: %+v", b)
+	}
+}
+
+// droppingUpstream reads one request per connection and closes it without an
+// answer, so the request has reached the upstream when the proxy sees the error.
+func droppingUpstream(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			http.ReadRequest(bufio.NewReader(conn))
+			conn.Close()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// closedPort returns an address nothing listens on.
+func closedPort(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	return addr
+}
+
+func TestRoundTripRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		failing  func(*testing.T) string
+		status   int
+		attempts int32
+	}{
+		{"GET after the request was sent", http.MethodGet, droppingUpstream, http.StatusOK, 1},
+		{"HEAD after the request was sent", http.MethodHead, droppingUpstream, http.StatusOK, 1},
+		{"POST after the request was sent", http.MethodPost, droppingUpstream, http.StatusBadGateway, 0},
+		{"DELETE after the request was sent", http.MethodDelete, droppingUpstream, http.StatusBadGateway, 0},
+		{"PATCH after the request was sent", http.MethodPatch, droppingUpstream, http.StatusBadGateway, 0},
+		{"POST after the connection was refused", http.MethodPost, closedPort, http.StatusOK, 1},
+		{"DELETE after the connection was refused", http.MethodDelete, closedPort, http.StatusOK, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			backup := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts.Add(1)
+			}))
+			defer backup.Close()
+
+			host, portValue, _ := net.SplitHostPort(tt.failing(t))
+			port, _ := strconv.Atoi(portValue)
+			store := &fakeStore{websites: []models.Website{{
+				Domain:    "a.example",
+				Protocol:  "http",
+				Host:      host,
+				Port:      port,
+				Upstreams: []string{backup.URL},
+			}}}
+			rp := newTestProxy(t, store, nil)
+
+			w := serve(rp, httptest.NewRequest(tt.method, "http://a.example/", nil))
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if got := attempts.Load(); got != tt.attempts {
+				t.Errorf("requests to the second upstream = %d, want %d", got, tt.attempts)
+			}
+		})
+	}
+}
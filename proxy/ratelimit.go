@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/secnex/reverse-proxy/models"
+	"github.com/secnex/reverse-proxy/ratelimit"
+)
+
+// checkRateLimit applies all rate limit rules of the site that match the
+// request path and reports whether the request was rejected. The headers
+// describe the most restrictive rule.
+func (rp *ReverseProxy) checkRateLimit(w http.ResponseWriter, r *http.Request, host string, config ProxyConfig) bool {
+	var result *ratelimit.Result
+	for i, rule := range config.RateLimits {
+		if !strings.HasPrefix(r.URL.Path, rule.PathPrefix) {
+			continue
+		}
+		limit := ratelimit.Limit{
+			Requests: rule.Requests,
+			Period:   time.Duration(rule.Period) * time.Second,
+			Burst:    rule.Burst,
+		}
+		if !limit.Valid() {
+			continue
+		}
+
+		key := host + "|" + strconv.Itoa(i) + "|" + rule.PathPrefix + "|" + rateLimitKey(r, rule)
+		current := rp.rateLimiter.Allow(key, limit)
+		if result == nil || moreRestrictive(current, *result) {
+			result = &current
+		}
+	}
+	if result == nil {
+		return false
+	}
+
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+	if result.Allowed {
+		return false
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+	rp.serveError(w, r, http.StatusTooManyRequests)
+	return true
+}
+
+func moreRestrictive(a, b ratelimit.Result) bool {
+	if a.Allowed != b.Allowed {
+		return !a.Allowed
+	}
+	if !a.Allowed {
+		return a.RetryAfter > b.RetryAfter
+	}
+	return a.Remaining < b.Remaining
+}
+
+// rateLimitKey identifies the client a rule counts requests for. Requests
+// without the configured header or API key are counted per client IP.
+func rateLimitKey(r *http.Request, rule models.RateLimitRule) string {
+	clientIP := getRequestInfo(r).clientIP
+
+	switch {
+	case rule.Key == "path":
+		return "path:" + r.URL.Path
+	case rule.Key == "api-key":
+		key := r.Header.Get("X-API-Key")
+		if key == "" {
+			key = r.Header.Get("Authorization")
+		}
+		if key == "" {
+			return "ip:" + clientIP
+		}
+		sum := sha256.Sum256([]byte(key))
+		return "api-key:" + hex.EncodeToString(sum[:])
+	case strings.HasPrefix(rule.Key, "header:"):
+		value := r.Header.Get(strings.TrimPrefix(rule.Key, "header:"))
+		if value == "" {
+			return "ip:" + clientIP
+		}
+		return "header:" + value
+	default:
+		return "ip:" + clientIP
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/secnex/reverse-proxy/models"
+	"github.com/secnex/reverse-proxy/ratelimit"
+)
+
+func hashed(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestRateLimitKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    string
+		header http.Header
+		want   string
+	}{
+		{"ip", "", nil, "ip:192.0.2.1"},
+		{"explicit ip", "ip", nil, "ip:192.0.2.1"},
+		{"path", "path", nil, "path:/api/items"},
+		{"header", "header:X-User", http.Header{"X-User": {"alice"}}, "header:alice"},
+		{"missing header", "header:X-User", nil, "ip:192.0.2.1"},
+		{"api key", "api-key", http.Header{"X-Api-Key": {"secret"}}, "api-key:" + hashed("secret")},
+		{"authorization as api key", "api-key", http.Header{"Authorization": {"Bearer secret"}}, "api-key:" + hashed("Bearer secret")},
+		{"missing api key", "api-key", nil, "ip:192.0.2.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://a.example/api/items", nil)
+			for name, values := range tt.header {
+				r.Header[name] = values
+			}
+			r, info := withRequestInfo(r)
+			info.clientIP = "192.0.2.1"
+
+			if got := rateLimitKey(r, models.RateLimitRule{Key: tt.key}); got != tt.want {
+				t.Errorf("rateLimitKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoreRestrictive(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b ratelimit.Result
+		want bool
+	}{
+		{"denied over allowed", ratelimit.Result{}, ratelimit.Result{Allowed: true}, true},
+		{"allowed over denied", ratelimit.Result{Allowed: true}, ratelimit.Result{}, false},
+		{"longer retry", ratelimit.Result{RetryAfter: 2 * time.Second}, ratelimit.Result{RetryAfter: time.Second}, true},
+		{"shorter retry", ratelimit.Result{RetryAfter: time.Second}, ratelimit.Result{RetryAfter: 2 * time.Second}, false},
+		{"fewer remaining", ratelimit.Result{Allowed: true, Remaining: 1}, ratelimit.Result{Allowed: true, Remaining: 5}, true},
+		{"more remaining", ratelimit.Result{Allowed: true, Remaining: 5}, ratelimit.Result{Allowed: true, Remaining: 1}, false},
+	}
+
+	for _, tt := range tests {
+		if got := moreRestrictive(tt.a, tt.b); got != tt.want {
+			t.Errorf("%s: moreRestrictive() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	store := &fakeStore{websites: []models.Website{{
+		Domain: "a.example",
+		RateLimits: []models.RateLimitRule{
+			{PathPrefix: "/api", Requests: 2, Period: 60},
+			{PathPrefix: "/api/search", Requests: 10, Period: 60, Burst: 1},
+		},
+	}}}
+	rp := newTestProxy(t, store, func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		path       string
+		remoteAddr string
+		status     int
+		remaining  string
+		retryAfter string
+	}{
+		{"/api/items", "192.0.2.1:1234", http.StatusOK, "1", ""},
+		{"/api/items", "192.0.2.1:1234", http.StatusOK, "0", ""},
+		{"/api/items", "192.0.2.1:1234", http.StatusTooManyRequests, "0", "30"},
+		{"/api/items", "192.0.2.2:1234", http.StatusOK, "1", ""},
+		{"/api/search", "192.0.2.3:1234", http.StatusOK, "0", ""},
+		{"/api/search", "192.0.2.3:1234", http.StatusTooManyRequests, "0", "6"},
+		{"/static", "192.0.2.1:1234", http.StatusOK, "", ""},
+	}
+	for i, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "http://a.example"+tt.path, nil)
+		r.RemoteAddr = tt.remoteAddr
+		w := serve(rp, r)
+		if w.Code != tt.status || w.Header().Get("RateLimit-Remaining") != tt.remaining || w.Header().Get("Retry-After") != tt.retryAfter {
+			t.Errorf("request %d: got %d, remaining %q, retry after %q, want %d, %q, %q", i, w.Code,
+				w.Header().Get("RateLimit-Remaining"), w.Header().Get("Retry-After"), tt.status, tt.remaining, tt.retryAfter)
+		}
+	}
+}
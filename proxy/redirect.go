@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/secnex/reverse-proxy/models"
+)
+
+const SiteRedirect = "redirect"
+
+type redirectRule struct {
+	models.RedirectRule
+	pattern *regexp.Regexp
+}
+
+func newRedirectRule(rule models.RedirectRule) (redirectRule, error) {
+	compiled := redirectRule{RedirectRule: rule}
+	if rule.Regex {
+		pattern, err := regexp.Compile(rule.Source)
+		if err != nil {
+			return compiled, err
+		}
+		compiled.pattern = pattern
+	}
+	return compiled, nil
+}
+
+// match returns the expanded target and the rest of the path after the
+// matched part. Prefixes only match whole path segments, so /old matches
+// /old and /old/page but not /older.
+func (rule redirectRule) match(path string) (string, string, bool) {
+	if rule.pattern == nil {
+		prefix := strings.TrimSuffix(rule.Source, "/")
+		if path != rule.Source && !strings.HasPrefix(path, prefix+"/") {
+			return "", "", false
+		}
+		return rule.Target, strings.TrimPrefix(path, prefix), true
+	}
+
+	match := rule.pattern.FindStringSubmatchIndex(path)
+	if match == nil {
+		return "", "", false
+	}
+	return string(rule.pattern.ExpandString(nil, rule.Target, path, match)), path[match[1]:], true
+}
+
+// redirect answers the request if a redirect rule matches. Redirect sites
+// fall back to their target, or a 404 without one.
+func (rp *ReverseProxy) redirect(w http.ResponseWriter, r *http.Request, config ProxyConfig) bool {
+	for _, rule := range config.redirectRules {
+		target, rest, ok := rule.match(r.URL.Path)
+		if ok {
+			http.Redirect(w, r, redirectTarget(r, target, rest, rule.PreservePath, rule.PreserveQuery), redirectStatus(rule.Status))
+			return true
+		}
+	}
+
+	if config.Type != SiteRedirect {
+		return false
+	}
+	if config.RedirectTarget == "" {
+		rp.serveError(w, r, http.StatusNotFound)
+		return true
+	}
+	target := redirectTarget(r, config.RedirectTarget, r.URL.Path, config.RedirectPreservePath, config.RedirectPreserveQuery)
+	http.Redirect(w, r, target, redirectStatus(config.RedirectStatus))
+	return true
+}
+
+// redirectTarget fills in the placeholders of the target and appends the
+// preserved path and query.
+func redirectTarget(r *http.Request, target string, rest string, preservePath bool, preserveQuery bool) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	target = strings.NewReplacer(
+		"{scheme}", scheme,
+		"{host}", hostname(r),
+		"{path}", r.URL.Path,
+		"{query}", r.URL.RawQuery,
+	).Replace(target)
+
+	target, query, _ := strings.Cut(target, "?")
+	if preservePath && rest != "" {
+		target = strings.TrimSuffix(target, "/") + "/" + strings.TrimPrefix(rest, "/")
+	}
+	if preserveQuery && r.URL.RawQuery != "" {
+		if query != "" {
+			query += "&"
+		}
+		query += r.URL.RawQuery
+	}
+	if query != "" {
+		target += "?" + query
+	}
+	return target
+}
+
+func redirectStatus(status int) int {
+	switch status {
+	case http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return status
+	}
+	return http.StatusMovedPermanently
+}
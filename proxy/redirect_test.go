@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/secnex/reverse-proxy/models"
+)
+
+func TestRedirectRuleMatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   models.RedirectRule
+		path   string
+		target string
+		rest   string
+		ok     bool
+	}{
+		{"exact prefix", models.RedirectRule{Source: "/old", Target: "/new"}, "/old", "/new", "", true},
+		{"prefix with subpath", models.RedirectRule{Source: "/old", Target: "/new"}, "/old/page", "/new", "/page", true},
+		{"prefix with trailing slash", models.RedirectRule{Source: "/old", Target: "/new"}, "/old/", "/new", "/", true},
+		{"longer segment", models.RedirectRule{Source: "/old", Target: "/new"}, "/older", "", "", false},
+		{"other path", models.RedirectRule{Source: "/old", Target: "/new"}, "/new/old", "", "", false},
+		{"source with trailing slash", models.RedirectRule{Source: "/old/", Target: "/new"}, "/old/page", "/new", "/page", true},
+		{"source with trailing slash exact", models.RedirectRule{Source: "/old/", Target: "/new"}, "/old/", "/new", "/", true},
+		{"source with trailing slash without it", models.RedirectRule{Source: "/old/", Target: "/new"}, "/old", "", "", false},
+		{"root", models.RedirectRule{Source: "/", Target: "/new"}, "/any/page", "/new", "/any/page", true},
+		{"regex capture", models.RedirectRule{Source: `^/blog/(\d+)`, Regex: true, Target: "/posts/$1"}, "/blog/42/comments", "/posts/42", "/comments", true},
+		{"regex named capture", models.RedirectRule{Source: `^/u/(?P<name>\w+)$`, Regex: true, Target: "/users/${name}"}, "/u/alice", "/users/alice", "", true},
+		{"regex no match", models.RedirectRule{Source: `^/blog/(\d+)$`, Regex: true, Target: "/posts/$1"}, "/blog/latest", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := newRedirectRule(tt.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			target, rest, ok := rule.match(tt.path)
+			if target != tt.target || rest != tt.rest || ok != tt.ok {
+				t.Errorf("match(%q) = %q, %q, %v, want %q, %q, %v", tt.path, target, rest, ok, tt.target, tt.rest, tt.ok)
+			}
+		})
+	}
+}
+
+func TestRedirectTarget(t *testing.T) {
+	tests := []struct {
+		name          string
+		url           string
+		tls           bool
+		target        string
+		rest          string
+		preservePath  bool
+		preserveQuery bool
+		want          string
+	}{
+		{"plain", "http://example.com/old?a=1", false, "https://example.org/new", "", false, false, "https://example.org/new"},
+		{"placeholders", "http://example.com:8080/old?a=1", true, "{scheme}://www.{host}{path}?{query}", "", false, false, "https://www.example.com/old?a=1"},
+		{"preserve path", "http://example.com/old/page", false, "/new", "/page", true, false, "/new/page"},
+		{"preserve path with trailing slash", "http://example.com/old/page", false, "/new/", "/page", true, false, "/new/page"},
+		{"preserve path without rest", "http://example.com/old", false, "/new", "", true, false, "/new"},
+		{"rest ignored", "http://example.com/old/page", false, "/new", "/page", false, false, "/new"},
+		{"preserve query", "http://example.com/old?a=1", false, "/new", "", false, true, "/new?a=1"},
+		{"preserve query with target query", "http://example.com/old?a=1", false, "/new?b=2", "", false, true, "/new?b=2&a=1"},
+		{"preserve path and query", "http://example.com/old/page?a=1", false, "/new?b=2", "/page", true, true, "/new/page?b=2&a=1"},
+		{"preserve query without query", "http://example.com/old", false, "/new?b=2", "", false, true, "/new?b=2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			if got := redirectTarget(r, tt.target, tt.rest, tt.preservePath, tt.preserveQuery); got != tt.want {
+				t.Errorf("redirectTarget() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedirectRules(t *testing.T) {
+	store := &fakeStore{
+		websites: []models.Website{{Domain: "a.example"}},
+		redirectRules: []models.RedirectRule{
+			{Domain: "a.example", Source: "/old", Target: "/new", PreservePath: true, Status: http.StatusPermanentRedirect},
+		},
+	}
+	rp := newTestProxy(t, store, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("upstream"))
+	})
+
+	tests := []struct {
+		path     string
+		status   int
+		location string
+	}{
+		{"/old", http.StatusPermanentRedirect, "/new"},
+		{"/old/page", http.StatusPermanentRedirect, "/new/page"},
+		{"/older", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := serve(rp, httptest.NewRequest(http.MethodGet, "http://a.example"+tt.path, nil))
+			if w.Code != tt.status || w.Header().Get("Location") != tt.location {
+				t.Errorf("status = %d, Location = %q, want %d, %q", w.Code, w.Header().Get("Location"), tt.status, tt.location)
+			}
+		})
+	}
+}
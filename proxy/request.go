@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+)
+
+type requestInfoKey struct{}
+
+// requestInfo collects details while a request is proxied, so middlewares
+// can report them once the response has been written.
+type requestInfo struct {
+	requestID string
+	clientIP  string
+	upstream  string
+	retries   int
+	// authenticated is set once the auth mode of the site let the request
+	// pass. Responses to it may be personalized, see storable.
+	authenticated bool
+}
+
+func withRequestInfo(r *http.Request) (*http.Request, *requestInfo) {
+	info := &requestInfo{}
+	return r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)), info
+}
+
+func getRequestInfo(r *http.Request) *requestInfo {
+	if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		return info
+	}
+	return &requestInfo{}
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// hostname returns the request host without port and IPv6 zone.
+func hostname(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return host
+}
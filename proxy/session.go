@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+)
+
+// sessionCodec encrypts cookie values with AES-GCM. The cookie name is
+// authenticated as well, so values cannot be moved between cookies.
+type sessionCodec struct {
+	aead cipher.AEAD
+}
+
+// newSessionCodec derives the key from the given secret. Without a secret a
+// random key is generated, which invalidates existing sessions on restart.
+func newSessionCodec(secret string) *sessionCodec {
+	var key []byte
+	if secret != "" {
+		sum := sha256.Sum256([]byte(secret))
+		key = sum[:]
+	} else {
+		log.Println("No session secret configured, generating a random one...")
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			log.Fatalf("Error generating session secret: %v", err)
+		}
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		log.Fatalf("Error initializing session cipher: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		log.Fatalf("Error initializing session cipher: %v", err)
+	}
+	return &sessionCodec{aead: aead}
+}
+
+func (c *sessionCodec) encode(name string, value interface{}) (string, error) {
+	plaintext, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := c.aead.Seal(nonce, nonce, plaintext, []byte(name))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func (c *sessionCodec) decode(name string, value string, target interface{}) error {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return err
+	}
+	if len(sealed) < c.aead.NonceSize() {
+		return errors.New("session value too short")
+	}
+
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, ciphertext, []byte(name))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(plaintext, target)
+}
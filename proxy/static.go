@@ -0,0 +1,38 @@
+package proxy
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/secnex/reverse-proxy/server"
+)
+
+const (
+	SiteProxy  = "proxy"
+	SiteStatic = "static"
+)
+
+// serveStatic serves the files of a static site. The site passes through the
+// same access, auth and inspection stages as proxied sites before. The root is
+// checked on every request, as symlinks may change after it was saved.
+func (rp *ReverseProxy) serveStatic(w http.ResponseWriter, r *http.Request, config ProxyConfig) {
+	root, err := server.ResolveStaticRoot(rp.staticDir, config.StaticRoot)
+	if err != nil {
+		log.Printf("Static root of %s rejected: %v", hostname(r), err)
+		rp.serveError(w, r, http.StatusInternalServerError)
+		return
+	}
+
+	setHSTS(w, r, config)
+	applyHeaderRules(w.Header(), r, config, HeaderResponse)
+
+	server.NewStaticServer(root, server.StaticOptions{
+		IndexFiles:       config.StaticIndexFiles,
+		SPA:              config.StaticSPA,
+		DirectoryListing: config.StaticListing,
+		CacheControl:     config.StaticCacheControl,
+		NotFound: func(w http.ResponseWriter, r *http.Request) {
+			rp.serveError(w, r, http.StatusNotFound)
+		},
+	}).ServeHTTP(w, r)
+}
@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/secnex/reverse-proxy/models"
+)
+
+func TestServeStatic(t *testing.T) {
+	staticDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(staticDir, "site"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(staticDir, "site", "index.html"), []byte("home"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		root   string
+		status int
+	}{
+		{"inside", "site", http.StatusOK},
+		{"outside", "/etc", http.StatusInternalServerError},
+		{"traversal", "site/../..", http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rp := newTestProxy(t, &fakeStore{
+				websites: []models.Website{{Domain: "a.example", Type: SiteStatic, StaticRoot: tt.root}},
+			}, nil)
+			rp.staticDir = staticDir
+
+			w := serve(rp, httptest.NewRequest(http.MethodGet, "http://a.example/", nil))
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
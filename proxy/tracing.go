@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/secnex/reverse-proxy/tracing"
+)
+
+func (rp *ReverseProxy) SetTracer(tracer *tracing.Tracer) {
+	rp.tracer = tracer
+}
+
+// TraceSampleRate returns the sample rate of a site for the tracing sampler.
+func (cc *ConfigCache) TraceSampleRate(host string) (float64, bool) {
+	config, exists := cc.Get(host)
+	if !exists || config.TraceSampleRate == nil {
+		return 0, false
+	}
+	return *config.TraceSampleRate, true
+}
+
+func (rp *ReverseProxy) trace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rp.tracer == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, span := rp.tracer.StartServer(r, hostname(r))
+		r = r.WithContext(ctx)
+		recorder := newResponseRecorder(w)
+		next.ServeHTTP(recorder, r)
+
+		info := getRequestInfo(r)
+		tracing.EndServer(span, recorder.Status(), info.upstream, info.retries)
+	})
+}
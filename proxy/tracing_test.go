@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/secnex/reverse-proxy/config"
+	"github.com/secnex/reverse-proxy/models"
+	"github.com/secnex/reverse-proxy/tracing"
+)
+
+func TestTraceContextPropagation(t *testing.T) {
+	const (
+		traceID  = "4bf92f3577b34da6a3ce929d0e0e4736"
+		parentID = "00f067aa0ba902b7"
+	)
+	sampled := 1.0
+
+	tests := []struct {
+		name        string
+		website     models.Website
+		traceparent string
+		traceID     string
+		flags       string
+	}{
+		{name: "sampled parent", traceparent: "00-" + traceID + "-" + parentID + "-01", traceID: traceID, flags: "01"},
+		{name: "unsampled parent", traceparent: "00-" + traceID + "-" + parentID + "-00", traceID: traceID, flags: "00"},
+		{name: "new trace", website: models.Website{TraceSampleRate: &sampled}, flags: "01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.website.Domain = "a.example"
+			var upstream string
+			rp := newTestProxy(t, &fakeStore{websites: []models.Website{tt.website}}, func(w http.ResponseWriter, r *http.Request) {
+				upstream = r.Header.Get("Traceparent")
+			})
+			// The exporter is never flushed, the test only looks at the
+			// headers sent upstream.
+			tracer, err := tracing.New(context.Background(), config.TracingConfig{
+				Enabled:     true,
+				Exporter:    tracing.ExporterStdout,
+				ServiceName: "test",
+			}, rp.configCache.TraceSampleRate)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rp.SetTracer(tracer)
+
+			r := httptest.NewRequest(http.MethodGet, "http://a.example/", nil)
+			if tt.traceparent != "" {
+				r.Header.Set("Traceparent", tt.traceparent)
+			}
+			if w := serve(rp, r); w.Code != http.StatusOK {
+				t.Fatalf("status = %d", w.Code)
+			}
+
+			parts := strings.Split(upstream, "-")
+			if len(parts) != 4 {
+				t.Fatalf("upstream traceparent = %q", upstream)
+			}
+			if tt.traceID != "" && parts[1] != tt.traceID {
+				t.Errorf("trace ID = %s, want %s", parts[1], tt.traceID)
+			}
+			if parts[2] == parentID {
+				t.Error("upstream request carries the span of the client, want the span of the proxy")
+			}
+			if parts[3] != tt.flags {
+				t.Errorf("flags = %s, want %s", parts[3], tt.flags)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const unhealthyCooldown = 30 * time.Second
+
+type Target struct {
+	Protocol string
+	Host     string
+	Port     int
+}
+
+func ParseTarget(raw string) (Target, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return Target{}, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return Target{}, fmt.Errorf("unsupported upstream scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return Target{}, fmt.Errorf("upstream %q has no host", raw)
+	}
+
+	port := 80
+	if u.Scheme == "https" {
+		port = 443
+	}
+	if u.Port() != "" {
+		port, err = strconv.Atoi(u.Port())
+		if err != nil {
+			return Target{}, fmt.Errorf("invalid upstream port %q", u.Port())
+		}
+	}
+
+	return Target{
+		Protocol: u.Scheme,
+		Host:     u.Hostname(),
+		Port:     port,
+	}, nil
+}
+
+func (t Target) URL() string {
+	return t.Protocol + "://" + net.JoinHostPort(t.Host, strconv.Itoa(t.Port))
+}
+
+// ID identifies the target in affinity cookies without exposing its address.
+func (t Target) ID() string {
+	sum := sha256.Sum256([]byte(t.URL()))
+	return hex.EncodeToString(sum[:8])
+}
+
+type UpstreamPool struct {
+	targets   []Target
+	mu        sync.Mutex
+	next      int
+	downUntil map[string]time.Time
+}
+
+func NewUpstreamPool(targets []Target) *UpstreamPool {
+	return &UpstreamPool{
+		targets:   targets,
+		downUntil: make(map[string]time.Time),
+	}
+}
+
+func (p *UpstreamPool) Targets() []Target {
+	return p.targets
+}
+
+// Next returns the next healthy target in round-robin order, skipping the
+// excluded IDs. If every remaining target is unhealthy it still returns one,
+// so a fully failed pool keeps being probed instead of rejecting all traffic.
+func (p *UpstreamPool) Next(exclude map[string]bool) (Target, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	fallback := -1
+	for i := 0; i < len(p.targets); i++ {
+		idx := (p.next + i) % len(p.targets)
+		target := p.targets[idx]
+		if exclude[target.ID()] {
+			continue
+		}
+		if p.healthy(target) {
+			p.next = idx + 1
+			return target, true
+		}
+		if fallback < 0 {
+			fallback = idx
+		}
+	}
+
+	if fallback < 0 {
+		return Target{}, false
+	}
+	p.next = fallback + 1
+	return p.targets[fallback], true
+}
+
+// Lookup returns the target with the given ID if it is part of the pool and healthy.
+func (p *UpstreamPool) Lookup(id string) (Target, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, target := range p.targets {
+		if target.ID() == id && p.healthy(target) {
+			return target, true
+		}
+	}
+	return Target{}, false
+}
+
+func (p *UpstreamPool) MarkDown(target Target) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.downUntil[target.ID()] = time.Now().Add(unhealthyCooldown)
+}
+
+func (p *UpstreamPool) healthy(target Target) bool {
+	until, exists := p.downUntil[target.ID()]
+	if !exists {
+		return true
+	}
+	if time.Now().After(until) {
+		delete(p.downUntil, target.ID())
+		return true
+	}
+	return false
+}
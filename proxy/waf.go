@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/secnex/reverse-proxy/waf"
+)
+
+// wafBodyLimit is the number of leading body bytes that are inspected.
+const wafBodyLimit = 64 << 10
+
+// SetInspector replaces the default WAF rules.
+func (rp *ReverseProxy) SetInspector(inspector waf.Inspector) {
+	rp.inspector = inspector
+}
+
+// inspect runs the WAF in the mode of the site. Hits are always logged with
+// their rule IDs, in block mode the request is rejected with 403.
+func (rp *ReverseProxy) inspect(w http.ResponseWriter, r *http.Request, host string, config ProxyConfig) bool {
+	if config.WAFMode == waf.ModeOff || rp.inspector == nil {
+		return false
+	}
+
+	uri := r.RequestURI
+	if uri == "" {
+		uri = r.URL.RequestURI()
+	}
+	req := &waf.Request{
+		Method: r.Method,
+		URI:    uri,
+		Query:  r.URL.Query(),
+		Header: r.Header,
+	}
+	if inspectableBody(r.Header.Get("Content-Type")) {
+		req.Body = peekBody(r, wafBodyLimit)
+	}
+
+	matches := rp.inspector.Inspect(req, config.WAFDisabledRules)
+	if len(matches) == 0 {
+		return false
+	}
+
+	info := getRequestInfo(r)
+	log.Printf("WAF (%s) rules %s matched %s %s on %s from %s, request %s", config.WAFMode, waf.RuleIDs(matches), r.Method, uri, host, info.clientIP, info.requestID)
+	if config.WAFMode != waf.ModeBlock {
+		return false
+	}
+
+	rp.serveError(w, r, http.StatusForbidden)
+	return true
+}
+
+func inspectableBody(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch mediaType {
+	case "application/x-www-form-urlencoded", "multipart/form-data", "application/json", "application/xml":
+		return true
+	}
+	return strings.HasPrefix(mediaType, "text/")
+}
+
+// peekBody reads up to limit bytes of the body and puts them back in front of
+// the remaining body.
+func peekBody(r *http.Request, limit int64) []byte {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+
+	data, _ := io.ReadAll(io.LimitReader(r.Body, limit))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(data), r.Body), r.Body}
+	return data
+}
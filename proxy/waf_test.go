@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/secnex/reverse-proxy/models"
+	"github.com/secnex/reverse-proxy/waf"
+)
+
+func TestWAF(t *testing.T) {
+	attack := "name=x' OR '1'='1"
+	tests := []struct {
+		name        string
+		mode        string
+		disabled    []string
+		contentType string
+		body        string
+		status      int
+		forwarded   bool
+	}{
+		{"block", waf.ModeBlock, nil, "application/x-www-form-urlencoded", attack, http.StatusForbidden, false},
+		{"block clean request", waf.ModeBlock, nil, "application/x-www-form-urlencoded", "name=alice", http.StatusOK, true},
+		{"block with the rule disabled", waf.ModeBlock, []string{"942110"}, "application/x-www-form-urlencoded", attack, http.StatusOK, true},
+		{"binary body is not inspected", waf.ModeBlock, nil, "application/octet-stream", attack, http.StatusOK, true},
+		{"detect", waf.ModeDetect, nil, "application/x-www-form-urlencoded", attack, http.StatusOK, true},
+		{"off", waf.ModeOff, nil, "application/x-www-form-urlencoded", attack, http.StatusOK, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received string
+			store := &fakeStore{websites: []models.Website{{Domain: "a.example", WAFMode: tt.mode, WAFDisabledRules: tt.disabled}}}
+			rp := newTestProxy(t, store, func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				received = string(body)
+			})
+
+			r := httptest.NewRequest(http.MethodPost, "http://a.example/form", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			w := serve(rp, r)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			// The inspected part of the body must still reach the upstream.
+			if forwarded := received != ""; forwarded != tt.forwarded || (forwarded && received != tt.body) {
+				t.Errorf("upstream received %q", received)
+			}
+		})
+	}
+}
+
+func TestPeekBody(t *testing.T) {
+	body := strings.Repeat("a", 100) + strings.Repeat("b", 100)
+	r := httptest.NewRequest(http.MethodPost, "http://a.example/", strings.NewReader(body))
+
+	if peeked := peekBody(r, 100); string(peeked) != strings.Repeat("a", 100) {
+		t.Errorf("peekBody() = %q", peeked)
+	}
+	rest, _ := io.ReadAll(r.Body)
+	if string(rest) != body {
+		t.Errorf("body after peeking = %q", rest)
+	}
+}
@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// serveUpgrade hands the client connection over to the upstream after it
+// agreed to switch protocols, e.g. for WebSockets.
+func (rp *ReverseProxy) serveUpgrade(w http.ResponseWriter, r *http.Request, resp *http.Response) {
+	backend, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		rp.serveError(w, r, http.StatusBadGateway)
+		return
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		log.Printf("Error hijacking connection for %s: %v", r.Host, err)
+		rp.serveError(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+
+	// The server timeouts must not end long-lived upgraded connections.
+	conn.SetDeadline(time.Time{})
+
+	resp.Body = nil
+	if err := resp.Write(brw); err != nil {
+		return
+	}
+	if err := brw.Flush(); err != nil {
+		return
+	}
+
+	rp.metrics.WebSocketOpened()
+	defer rp.metrics.WebSocketClosed()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(conn, backend)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(backend, brw)
+		done <- struct{}{}
+	}()
+	<-done
+	backend.Close()
+}
@@ -0,0 +1,128 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// elapse moves the bucket of the key back in time, as if d had passed since
+// its last request.
+func elapse(l *Limiter, key string, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if b, exists := l.buckets[key]; exists {
+		b.last = b.last.Add(-d)
+		b.full = b.full.Add(-d)
+	}
+	l.nextSweep = l.nextSweep.Add(-d)
+}
+
+func near(got time.Duration, want time.Duration) bool {
+	diff := got - want
+	return diff > -50*time.Millisecond && diff < 50*time.Millisecond
+}
+
+func TestLimitValid(t *testing.T) {
+	tests := []struct {
+		limit Limit
+		want  bool
+	}{
+		{Limit{Requests: 10, Period: time.Minute}, true},
+		{Limit{Requests: 10, Period: time.Minute, Burst: 20}, true},
+		{Limit{Requests: 0, Period: time.Minute}, false},
+		{Limit{Requests: 10}, false},
+		{Limit{Requests: 10, Period: time.Minute, Burst: -1}, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.limit.Valid(); got != tt.want {
+			t.Errorf("%+v.Valid() = %v, want %v", tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestAllow(t *testing.T) {
+	// One token every 6 seconds, up to 3 at once.
+	limit := Limit{Requests: 10, Period: time.Minute, Burst: 3}
+
+	type step struct {
+		elapse     time.Duration
+		allowed    bool
+		remaining  int
+		reset      time.Duration
+		retryAfter time.Duration
+	}
+	tests := []struct {
+		name  string
+		limit Limit
+		steps []step
+	}{
+		{"burst", limit, []step{
+			{0, true, 2, 6 * time.Second, 0},
+			{0, true, 1, 12 * time.Second, 0},
+			{0, true, 0, 18 * time.Second, 0},
+			{0, false, 0, 18 * time.Second, 6 * time.Second},
+			{2 * time.Second, false, 0, 16 * time.Second, 4 * time.Second},
+		}},
+		{"refill", limit, []step{
+			{0, true, 2, 6 * time.Second, 0},
+			{0, true, 1, 12 * time.Second, 0},
+			{0, true, 0, 18 * time.Second, 0},
+			{6 * time.Second, true, 0, 18 * time.Second, 0},
+			{0, false, 0, 18 * time.Second, 6 * time.Second},
+		}},
+		{"refill is capped at the burst", limit, []step{
+			{0, true, 2, 6 * time.Second, 0},
+			{time.Hour, true, 2, 6 * time.Second, 0},
+		}},
+		{"burst defaults to requests", Limit{Requests: 2, Period: 10 * time.Second}, []step{
+			{0, true, 1, 5 * time.Second, 0},
+			{0, true, 0, 10 * time.Second, 0},
+			{0, false, 0, 10 * time.Second, 5 * time.Second},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New()
+			for i, step := range tt.steps {
+				elapse(l, "client", step.elapse)
+				result := l.Allow("client", tt.limit)
+				if result.Allowed != step.allowed || result.Remaining != step.remaining || result.Limit != int(tt.limit.capacity()) {
+					t.Errorf("step %d: %+v, want allowed %v, remaining %d", i, result, step.allowed, step.remaining)
+				}
+				if !near(result.Reset, step.reset) || !near(result.RetryAfter, step.retryAfter) {
+					t.Errorf("step %d: reset %v, retry after %v, want %v, %v", i, result.Reset, result.RetryAfter, step.reset, step.retryAfter)
+				}
+			}
+		})
+	}
+}
+
+func TestAllowSeparatesKeys(t *testing.T) {
+	l := New()
+	limit := Limit{Requests: 1, Period: time.Minute}
+	if !l.Allow("a", limit).Allowed || !l.Allow("b", limit).Allowed {
+		t.Fatal("first request of a key denied")
+	}
+	if l.Allow("a", limit).Allowed {
+		t.Error("second request of a allowed")
+	}
+}
+
+func TestSweepRemovesFullBuckets(t *testing.T) {
+	l := New()
+	limit := Limit{Requests: 1, Period: time.Minute}
+	l.Allow("a", limit)
+	l.Allow("b", limit)
+	elapse(l, "a", 2*time.Minute)
+	elapse(l, "b", 30*time.Second)
+
+	l.Allow("c", limit)
+	if l.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", l.Len())
+	}
+	if _, exists := l.buckets["a"]; exists {
+		t.Error("full bucket of a not removed")
+	}
+}
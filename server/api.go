@@ -1,40 +1,182 @@
 package server
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
 	"sync"
 	"time"
+
+	"github.com/secnex/reverse-proxy/config"
+	"github.com/secnex/reverse-proxy/ratelimit"
 )
 
 type APIServer struct {
-	activeConfigs map[string]bool
-	mu            sync.RWMutex
-	rateLimiter   map[string]time.Time
-	rateLimit     time.Duration
+	activeConfigs  map[string]bool
+	mu             sync.RWMutex
+	rateLimiter    *ratelimit.Limiter
+	rateLimit      ratelimit.Limit
+	mux            *http.ServeMux
+	config         config.APIConfig
+	corsOrigins    []string
+	tokens         TokenStore
+	websites       WebsiteStore
+	certificates   CertificateManager
+	audit          AuditStore
+	basicAuth      BasicAuthStore
+	headerRules    HeaderRuleStore
+	redirectRules  RedirectRuleStore
+	errorTemplates ErrorTemplateStore
+	maintenance    MaintenanceStore
+	cache          CachePurger
+	staticDir      string
+	countryRules   bool
+	reload         func() error
 }
 
-func NewAPIServer() *APIServer {
-	return &APIServer{
+func NewAPIServer(cfg config.APIConfig) *APIServer {
+	s := &APIServer{
 		activeConfigs: make(map[string]bool),
-		rateLimiter:   make(map[string]time.Time),
-		rateLimit:     time.Second * 1,
+		rateLimiter:   ratelimit.New(),
+		rateLimit:     ratelimit.Limit{Requests: 10, Period: time.Second, Burst: 20},
+		mux:           http.NewServeMux(),
+		config:        cfg,
+		corsOrigins:   cfg.CORSOrigins,
 	}
+
+	s.mux.HandleFunc("/api/status", s.authorize(ScopeReadOnly, s.handleStatus))
+	s.mux.HandleFunc("/api/refresh", s.authorize(ScopeSiteAdmin, s.handleRefresh))
+	s.mux.HandleFunc("/api/websites", s.authorize(ScopeSiteAdmin, s.handleWebsites))
+	s.mux.HandleFunc("/api/websites/active", s.authorize(ScopeSiteAdmin, s.handleWebsiteActive))
+	s.mux.HandleFunc("/api/websites/users", s.authorize(ScopeSiteAdmin, s.handleBasicAuthUsers))
+	s.mux.HandleFunc("/api/websites/headers", s.authorize(ScopeSiteAdmin, s.handleHeaderRules))
+	s.mux.HandleFunc("/api/websites/redirects", s.authorize(ScopeSiteAdmin, s.handleRedirectRules))
+	s.mux.HandleFunc("/api/websites/redirects/import", s.authorize(ScopeSiteAdmin, s.handleRedirectImport))
+	s.mux.HandleFunc("/api/websites/errors", s.authorize(ScopeSiteAdmin, s.handleErrorTemplates))
+	s.mux.HandleFunc("/api/websites/maintenance", s.authorize(ScopeSiteAdmin, s.handleMaintenance))
+	s.mux.HandleFunc("/api/cache/purge", s.authorize(ScopeSiteAdmin, s.handleCachePurge))
+	s.mux.HandleFunc("/api/certificates/renew", s.authorize(ScopeCertAdmin, s.handleCertificateRenew))
+	s.mux.HandleFunc("/api/audit", s.authorize(ScopeReadOnly, s.handleAudit))
+
+	return s
 }
 
-func (s *APIServer) Start(port int) error {
-	http.HandleFunc("/api/status", s.handleStatus)
-	http.HandleFunc("/api/refresh", s.handleRefresh)
-	return http.ListenAndServe(fmt.Sprintf(":%d", port), nil)
+func (s *APIServer) SetTokenStore(tokens TokenStore) {
+	s.tokens = tokens
 }
 
-func (s *APIServer) handleStatus(w http.ResponseWriter, r *http.Request) {
-	if !s.checkRateLimit(r) {
-		http.Error(w, "Zu viele Anfragen", http.StatusTooManyRequests)
-		return
+func (s *APIServer) SetWebsiteStore(websites WebsiteStore) {
+	s.websites = websites
+}
+
+func (s *APIServer) SetCertificateManager(certificates CertificateManager) {
+	s.certificates = certificates
+}
+
+func (s *APIServer) SetAuditStore(audit AuditStore) {
+	s.audit = audit
+}
+
+func (s *APIServer) SetBasicAuthStore(basicAuth BasicAuthStore) {
+	s.basicAuth = basicAuth
+}
+
+func (s *APIServer) SetHeaderRuleStore(headerRules HeaderRuleStore) {
+	s.headerRules = headerRules
+}
+
+func (s *APIServer) SetRedirectRuleStore(redirectRules RedirectRuleStore) {
+	s.redirectRules = redirectRules
+}
+
+func (s *APIServer) SetErrorTemplateStore(errorTemplates ErrorTemplateStore) {
+	s.errorTemplates = errorTemplates
+}
+
+func (s *APIServer) SetMaintenanceStore(maintenance MaintenanceStore) {
+	s.maintenance = maintenance
+}
+
+func (s *APIServer) SetCachePurger(cache CachePurger) {
+	s.cache = cache
+}
+
+// SetCountryRulesEnabled allows country rules on websites, which need a GeoIP
+// database.
+func (s *APIServer) SetCountryRulesEnabled(enabled bool) {
+	s.countryRules = enabled
+}
+
+// SetStaticDir sets the directory the roots of static websites must be in.
+func (s *APIServer) SetStaticDir(dir string) {
+	s.staticDir = dir
+}
+
+// SetMetricsHandler exposes the Prometheus metrics on /metrics. Scrapes need
+// a token like every other API request.
+func (s *APIServer) SetMetricsHandler(handler http.Handler) {
+	s.mux.HandleFunc("/metrics", s.authorize(ScopeReadOnly, handler.ServeHTTP))
+}
+
+// SetReloadFunc registers the function that reloads the proxy configuration
+// after changes made through the API.
+func (s *APIServer) SetReloadFunc(reload func() error) {
+	s.reload = reload
+}
+
+func (s *APIServer) Start(network string) error {
+	server := &http.Server{
+		Handler:           s.mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       120 * time.Second,
 	}
 
+	ln, err := net.Listen(network, s.config.Address)
+	if err != nil {
+		return fmt.Errorf("error listening on %s: %v", s.config.Address, err)
+	}
+
+	if s.config.CertFile == "" {
+		return server.Serve(ln)
+	}
+
+	tlsConfig, err := s.tlsConfig()
+	if err != nil {
+		ln.Close()
+		return err
+	}
+	server.TLSConfig = tlsConfig
+	return server.ServeTLS(ln, s.config.CertFile, s.config.KeyFile)
+}
+
+// tlsConfig requires client certificates signed by the configured CA when
+// mTLS is enabled for the admin listener.
+func (s *APIServer) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if s.config.ClientCAFile == "" {
+		return tlsConfig, nil
+	}
+
+	data, err := os.ReadFile(s.config.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading client CA: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in client CA %s", s.config.ClientCAFile)
+	}
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	return tlsConfig, nil
+}
+
+func (s *APIServer) handleStatus(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Methode nicht erlaubt", http.StatusMethodNotAllowed)
 		return
@@ -44,8 +186,10 @@ func (s *APIServer) handleStatus(w http.ResponseWriter, r *http.Request) {
 	defer s.mu.RUnlock()
 
 	activeSites := make([]string, 0, len(s.activeConfigs))
-	for site := range s.activeConfigs {
-		activeSites = append(activeSites, site)
+	for site, active := range s.activeConfigs {
+		if active {
+			activeSites = append(activeSites, site)
+		}
 	}
 
 	response := struct {
@@ -55,21 +199,22 @@ func (s *APIServer) handleStatus(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 	json.NewEncoder(w).Encode(response)
 }
 
 func (s *APIServer) handleRefresh(w http.ResponseWriter, r *http.Request) {
-	if !s.checkRateLimit(r) {
-		http.Error(w, "Zu viele Anfragen", http.StatusTooManyRequests)
-		return
-	}
-
 	if r.Method != http.MethodPost {
 		http.Error(w, "Methode nicht erlaubt", http.StatusMethodNotAllowed)
 		return
 	}
 
+	if s.reload != nil {
+		if err := s.reload(); err != nil {
+			http.Error(w, fmt.Sprintf("Aktualisierung fehlgeschlagen: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
 	response := struct {
 		Message string `json:"message"`
 	}{
@@ -77,25 +222,19 @@ func (s *APIServer) handleRefresh(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 	json.NewEncoder(w).Encode(response)
 }
 
-func (s *APIServer) checkRateLimit(r *http.Request) bool {
-	ip := r.RemoteAddr
-	now := time.Now()
-
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if lastRequest, exists := s.rateLimiter[ip]; exists {
-		if now.Sub(lastRequest) < s.rateLimit {
-			return false
-		}
+// checkRateLimit limits requests per client IP and returns the time until
+// the next request is allowed.
+func (s *APIServer) checkRateLimit(r *http.Request) (time.Duration, bool) {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
 	}
 
-	s.rateLimiter[ip] = now
-	return true
+	result := s.rateLimiter.Allow(ip, s.rateLimit)
+	return result.RetryAfter, result.Allowed
 }
 
 func (s *APIServer) SetActiveConfig(site string, active bool) {
@@ -104,6 +243,16 @@ func (s *APIServer) SetActiveConfig(site string, active bool) {
 	s.activeConfigs[site] = active
 }
 
+// SetDefaultActiveConfig activates a site unless its state was set before,
+// so reloads do not undo a deactivation made through the API.
+func (s *APIServer) SetDefaultActiveConfig(site string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.activeConfigs[site]; !exists {
+		s.activeConfigs[site] = true
+	}
+}
+
 func (s *APIServer) IsActiveConfig(site string) bool {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
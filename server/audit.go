@@ -0,0 +1,88 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/secnex/reverse-proxy/audit"
+	"github.com/secnex/reverse-proxy/models"
+)
+
+const (
+	defaultAuditPerPage = 50
+	maxAuditPerPage     = 500
+)
+
+type AuditStore interface {
+	audit.Recorder
+	ListAuditEntries(filter models.AuditFilter) ([]models.AuditEntry, int64, error)
+}
+
+func (s *APIServer) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Methode nicht erlaubt", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.audit == nil {
+		http.Error(w, "Keine Datenbank konfiguriert", http.StatusServiceUnavailable)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := models.AuditFilter{
+		Actor:        query.Get("actor"),
+		Action:       query.Get("action"),
+		ResourceType: query.Get("resource_type"),
+		ResourceID:   query.Get("resource_id"),
+		Page:         1,
+		PerPage:      defaultAuditPerPage,
+	}
+
+	if value := query.Get("page"); value != "" {
+		page, err := strconv.Atoi(value)
+		if err != nil || page < 1 {
+			http.Error(w, "Ungültige Seite", http.StatusBadRequest)
+			return
+		}
+		filter.Page = page
+	}
+	if value := query.Get("per_page"); value != "" {
+		perPage, err := strconv.Atoi(value)
+		if err != nil || perPage < 1 || perPage > maxAuditPerPage {
+			http.Error(w, "Ungültige Seitengröße", http.StatusBadRequest)
+			return
+		}
+		filter.PerPage = perPage
+	}
+	for key, target := range map[string]**time.Time{"since": &filter.Since, "until": &filter.Until} {
+		value := query.Get(key)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			http.Error(w, "Ungültiger Zeitpunkt für "+key, http.StatusBadRequest)
+			return
+		}
+		*target = &parsed
+	}
+
+	entries, total, err := s.audit.ListAuditEntries(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, struct {
+		Entries []models.AuditEntry `json:"entries"`
+		Page    int                 `json:"page"`
+		PerPage int                 `json:"per_page"`
+		Total   int64               `json:"total"`
+	}{
+		Entries: entries,
+		Page:    filter.Page,
+		PerPage: filter.PerPage,
+		Total:   total,
+	})
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/secnex/reverse-proxy/models"
+)
+
+const (
+	ScopeReadOnly  = "read-only"
+	ScopeSiteAdmin = "site-admin"
+	ScopeCertAdmin = "cert-admin"
+
+	tokenPrefix = "rp_"
+)
+
+var Scopes = []string{ScopeReadOnly, ScopeSiteAdmin, ScopeCertAdmin}
+
+type TokenStore interface {
+	FindAPIToken(hash string) (*models.APIToken, error)
+	TouchAPIToken(id uint) error
+}
+
+type contextKey string
+
+const actorKey contextKey = "actor"
+
+// GenerateAPIToken returns a new random token and the hash that is stored in
+// the database. The token itself is only shown once.
+func GenerateAPIToken() (string, string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	token := tokenPrefix + hex.EncodeToString(secret)
+	return token, HashAPIToken(token), nil
+}
+
+func HashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func ValidScope(scope string) bool {
+	return slices.Contains(Scopes, scope)
+}
+
+// Actor returns the name of the token that authenticated the request.
+func Actor(r *http.Request) string {
+	actor, _ := r.Context().Value(actorKey).(string)
+	return actor
+}
+
+// authorize wraps a handler with CORS, rate limiting and token authentication.
+// Read requests need any scope, other methods need the given scope.
+func (s *APIServer) authorize(scope string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.setCORSHeaders(w, r)
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if retryAfter, ok := s.checkRateLimit(r); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			http.Error(w, "Zu viele Anfragen", http.StatusTooManyRequests)
+			return
+		}
+
+		token, ok := s.authenticate(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="secnex-reverse-proxy"`)
+			http.Error(w, "Nicht autorisiert", http.StatusUnauthorized)
+			return
+		}
+
+		required := scope
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			required = ScopeReadOnly
+		}
+		if !hasScope(token, required) {
+			http.Error(w, "Keine Berechtigung", http.StatusForbidden)
+			return
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), actorKey, token.Name))
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			handler(w, r)
+			return
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler(recorder, r)
+		log.Printf("API: %s %s by %s returned %d", r.Method, r.URL.Path, token.Name, recorder.status)
+	}
+}
+
+func (s *APIServer) authenticate(r *http.Request) (*models.APIToken, bool) {
+	if s.tokens == nil {
+		return nil, false
+	}
+
+	header := r.Header.Get("Authorization")
+	raw, found := strings.CutPrefix(header, "Bearer ")
+	if !found || raw == "" {
+		return nil, false
+	}
+
+	token, err := s.tokens.FindAPIToken(HashAPIToken(strings.TrimSpace(raw)))
+	if err != nil {
+		return nil, false
+	}
+	if token.ExpiresAt != nil && time.Now().After(*token.ExpiresAt) {
+		return nil, false
+	}
+
+	if token.LastUsed == nil || time.Since(*token.LastUsed) > time.Minute {
+		if err := s.tokens.TouchAPIToken(token.ID); err != nil {
+			log.Printf("Error updating token usage for %s: %v", token.Name, err)
+		}
+	}
+	return token, true
+}
+
+// hasScope treats every scope as including read access.
+func hasScope(token *models.APIToken, scope string) bool {
+	if scope == ScopeReadOnly {
+		return len(token.Scopes) > 0
+	}
+	return slices.Contains(token.Scopes, scope)
+}
+
+func (s *APIServer) setCORSHeaders(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if origin == "" || len(s.corsOrigins) == 0 {
+		return
+	}
+
+	if slices.Contains(s.corsOrigins, "*") {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if slices.Contains(s.corsOrigins, origin) {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Add("Vary", "Origin")
+	} else {
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/secnex/reverse-proxy/models"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+type BasicAuthStore interface {
+	ListBasicAuthUsers(domain string) ([]models.BasicAuthUser, error)
+	SetBasicAuthUser(actor string, domain string, username string, passwordHash string) error
+	DeleteBasicAuthUser(actor string, domain string, username string) error
+}
+
+func (s *APIServer) handleBasicAuthUsers(w http.ResponseWriter, r *http.Request) {
+	if s.basicAuth == nil {
+		http.Error(w, "Keine Datenbank konfiguriert", http.StatusServiceUnavailable)
+		return
+	}
+
+	domain := r.URL.Query().Get("domain")
+
+	switch r.Method {
+	case http.MethodGet:
+		if domain == "" {
+			http.Error(w, "Domain fehlt", http.StatusBadRequest)
+			return
+		}
+		users, err := s.basicAuth.ListBasicAuthUsers(domain)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, users)
+	case http.MethodPost:
+		var request struct {
+			Domain   string `json:"domain"`
+			Username string `json:"username"`
+			Password string `json:"password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Domain == "" || request.Username == "" || request.Password == "" {
+			http.Error(w, "Ungültige Anfrage", http.StatusBadRequest)
+			return
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(request.Password), bcrypt.DefaultCost)
+		if err != nil {
+			http.Error(w, "Ungültiges Passwort", http.StatusBadRequest)
+			return
+		}
+		if err := s.basicAuth.SetBasicAuthUser(Actor(r), request.Domain, request.Username, string(hash)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.reloadAfterChange(w, http.StatusOK)
+	case http.MethodDelete:
+		username := r.URL.Query().Get("username")
+		if domain == "" || username == "" {
+			http.Error(w, "Domain oder Benutzername fehlt", http.StatusBadRequest)
+			return
+		}
+		if err := s.basicAuth.DeleteBasicAuthUser(Actor(r), domain, username); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				http.Error(w, "Benutzer nicht gefunden", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.reloadAfterChange(w, http.StatusOK)
+	default:
+		http.Error(w, "Methode nicht erlaubt", http.StatusMethodNotAllowed)
+	}
+}
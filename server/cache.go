@@ -0,0 +1,42 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/secnex/reverse-proxy/audit"
+)
+
+type CachePurger interface {
+	Purge(host string, path string, prefix bool) int
+}
+
+func (s *APIServer) handleCachePurge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Methode nicht erlaubt", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.cache == nil {
+		http.Error(w, "Kein Cache konfiguriert", http.StatusServiceUnavailable)
+		return
+	}
+
+	var request struct {
+		Host   string `json:"host"`
+		Path   string `json:"path"`
+		Prefix bool   `json:"prefix"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Host == "" {
+		http.Error(w, "Ungültige Anfrage", http.StatusBadRequest)
+		return
+	}
+	if request.Path != "" && !strings.HasPrefix(request.Path, "/") {
+		http.Error(w, "Ungültiger Pfad", http.StatusBadRequest)
+		return
+	}
+
+	purged := s.cache.Purge(request.Host, request.Path, request.Prefix)
+	audit.Record(s.audit, audit.NewEntry(Actor(r), audit.ActionPurge, audit.ResourceCache, request.Host, nil, map[string]any{"path": request.Path, "prefix": request.Prefix, "purged": purged}))
+	writeJSON(w, http.StatusOK, map[string]int{"purged": purged})
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/json"
+	htmltemplate "html/template"
+	"net/http"
+	"strconv"
+	texttemplate "text/template"
+
+	"github.com/secnex/reverse-proxy/models"
+)
+
+type ErrorTemplateStore interface {
+	ListErrorTemplates(domain string) ([]models.ErrorTemplate, error)
+	SetErrorTemplate(actor string, tmpl models.ErrorTemplate) error
+	DeleteErrorTemplate(actor string, domain string, status int, format string) error
+}
+
+func (s *APIServer) handleErrorTemplates(w http.ResponseWriter, r *http.Request) {
+	if s.errorTemplates == nil {
+		http.Error(w, "Keine Datenbank konfiguriert", http.StatusServiceUnavailable)
+		return
+	}
+
+	domain := r.URL.Query().Get("domain")
+
+	switch r.Method {
+	case http.MethodGet:
+		if domain == "" {
+			http.Error(w, "Domain fehlt", http.StatusBadRequest)
+			return
+		}
+		templates, err := s.errorTemplates.ListErrorTemplates(domain)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, templates)
+	case http.MethodPut:
+		var tmpl models.ErrorTemplate
+		if err := json.NewDecoder(r.Body).Decode(&tmpl); err != nil || tmpl.Domain == "" || !validErrorTemplate(tmpl) {
+			http.Error(w, "Ungültige Vorlage", http.StatusBadRequest)
+			return
+		}
+		if err := s.errorTemplates.SetErrorTemplate(Actor(r), tmpl); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.reloadAfterChange(w, http.StatusOK)
+	case http.MethodDelete:
+		status, err := strconv.Atoi(r.URL.Query().Get("status"))
+		format := r.URL.Query().Get("format")
+		if domain == "" || err != nil || format == "" {
+			http.Error(w, "Domain, Status oder Format fehlt", http.StatusBadRequest)
+			return
+		}
+		if err := s.errorTemplates.DeleteErrorTemplate(Actor(r), domain, status, format); err != nil {
+			writeStoreError(w, err, "Vorlage nicht gefunden")
+			return
+		}
+		s.reloadAfterChange(w, http.StatusOK)
+	default:
+		http.Error(w, "Methode nicht erlaubt", http.StatusMethodNotAllowed)
+	}
+}
+
+// validErrorTemplate checks the status and format and that the template
+// parses, status 0 is the catch-all template.
+func validErrorTemplate(tmpl models.ErrorTemplate) bool {
+	if tmpl.Status != 0 && (tmpl.Status < 400 || tmpl.Status > 599) {
+		return false
+	}
+	var err error
+	switch tmpl.Format {
+	case "html":
+		_, err = htmltemplate.New("error").Parse(tmpl.Template)
+	case "json", "text":
+		_, err = texttemplate.New("error").Parse(tmpl.Template)
+	default:
+		return false
+	}
+	return err == nil
+}
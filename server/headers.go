@@ -0,0 +1,106 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/secnex/reverse-proxy/models"
+	"gorm.io/gorm"
+)
+
+type HeaderRuleStore interface {
+	ListHeaderRules(domain string) ([]models.HeaderRule, error)
+	CreateHeaderRule(actor string, rule models.HeaderRule) error
+	UpdateHeaderRule(actor string, id uint, rule models.HeaderRule) error
+	DeleteHeaderRule(actor string, id uint) error
+}
+
+func (s *APIServer) handleHeaderRules(w http.ResponseWriter, r *http.Request) {
+	if s.headerRules == nil {
+		http.Error(w, "Keine Datenbank konfiguriert", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		domain := r.URL.Query().Get("domain")
+		if domain == "" {
+			http.Error(w, "Domain fehlt", http.StatusBadRequest)
+			return
+		}
+		rules, err := s.headerRules.ListHeaderRules(domain)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, rules)
+	case http.MethodPost:
+		var rule models.HeaderRule
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil || !validHeaderRule(rule) {
+			http.Error(w, "Ungültige Regel", http.StatusBadRequest)
+			return
+		}
+		if err := s.headerRules.CreateHeaderRule(Actor(r), rule); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.reloadAfterChange(w, http.StatusCreated)
+	case http.MethodPut:
+		id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "ID fehlt", http.StatusBadRequest)
+			return
+		}
+		var rule models.HeaderRule
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil || !validHeaderRule(rule) {
+			http.Error(w, "Ungültige Regel", http.StatusBadRequest)
+			return
+		}
+		if err := s.headerRules.UpdateHeaderRule(Actor(r), uint(id), rule); err != nil {
+			writeStoreError(w, err, "Regel nicht gefunden")
+			return
+		}
+		s.reloadAfterChange(w, http.StatusOK)
+	case http.MethodDelete:
+		id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "ID fehlt", http.StatusBadRequest)
+			return
+		}
+		if err := s.headerRules.DeleteHeaderRule(Actor(r), uint(id)); err != nil {
+			writeStoreError(w, err, "Regel nicht gefunden")
+			return
+		}
+		s.reloadAfterChange(w, http.StatusOK)
+	default:
+		http.Error(w, "Methode nicht erlaubt", http.StatusMethodNotAllowed)
+	}
+}
+
+func validHeaderRule(rule models.HeaderRule) bool {
+	if rule.Domain == "" || rule.Name == "" || strings.ContainsAny(rule.Name, " \t\r\n:") || strings.ContainsAny(rule.Value, "\r\n") {
+		return false
+	}
+	switch rule.Direction {
+	case "request", "response":
+	default:
+		return false
+	}
+	switch rule.Action {
+	case "set", "add", "remove":
+		return true
+	default:
+		return false
+	}
+}
+
+func writeStoreError(w http.ResponseWriter, err error, notFound string) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, notFound, http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
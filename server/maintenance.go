@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/secnex/reverse-proxy/models"
+)
+
+type MaintenanceStore interface {
+	ListMaintenanceWindows(domain string) ([]models.MaintenanceWindow, error)
+	CreateMaintenanceWindow(actor string, window models.MaintenanceWindow) error
+	UpdateMaintenanceWindow(actor string, id uint, window models.MaintenanceWindow, bypassSecret *string) error
+	DeleteMaintenanceWindow(actor string, id uint) error
+}
+
+// maintenanceRequest carries the bypass secret, which the model never encodes
+// to JSON. Without BypassSecret, updates keep the current secret.
+type maintenanceRequest struct {
+	models.MaintenanceWindow
+	BypassSecret *string
+}
+
+func (s *APIServer) handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	if s.maintenance == nil {
+		http.Error(w, "Keine Datenbank konfiguriert", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		domain := r.URL.Query().Get("domain")
+		if domain == "" {
+			http.Error(w, "Domain fehlt", http.StatusBadRequest)
+			return
+		}
+		windows, err := s.maintenance.ListMaintenanceWindows(domain)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, windows)
+	case http.MethodPost:
+		var request maintenanceRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || !validMaintenanceWindow(request.MaintenanceWindow) {
+			http.Error(w, "Ungültiges Wartungsfenster", http.StatusBadRequest)
+			return
+		}
+		window := request.MaintenanceWindow
+		if request.BypassSecret != nil {
+			window.BypassSecret = *request.BypassSecret
+		}
+		if err := s.maintenance.CreateMaintenanceWindow(Actor(r), window); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.reloadAfterChange(w, http.StatusCreated)
+	case http.MethodPut:
+		id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "ID fehlt", http.StatusBadRequest)
+			return
+		}
+		var request maintenanceRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || !validMaintenanceWindow(request.MaintenanceWindow) {
+			http.Error(w, "Ungültiges Wartungsfenster", http.StatusBadRequest)
+			return
+		}
+		if err := s.maintenance.UpdateMaintenanceWindow(Actor(r), uint(id), request.MaintenanceWindow, request.BypassSecret); err != nil {
+			writeStoreError(w, err, "Wartungsfenster nicht gefunden")
+			return
+		}
+		s.reloadAfterChange(w, http.StatusOK)
+	case http.MethodDelete:
+		id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "ID fehlt", http.StatusBadRequest)
+			return
+		}
+		if err := s.maintenance.DeleteMaintenanceWindow(Actor(r), uint(id)); err != nil {
+			writeStoreError(w, err, "Wartungsfenster nicht gefunden")
+			return
+		}
+		s.reloadAfterChange(w, http.StatusOK)
+	default:
+		http.Error(w, "Methode nicht erlaubt", http.StatusMethodNotAllowed)
+	}
+}
+
+func validMaintenanceWindow(window models.MaintenanceWindow) bool {
+	if window.Domain == "" || window.RetryAfter < 0 {
+		return false
+	}
+	if window.Start != nil && window.End != nil && !window.End.After(*window.Start) {
+		return false
+	}
+	for _, entry := range window.BypassIPs {
+		if strings.Contains(entry, "/") {
+			if _, _, err := net.ParseCIDR(entry); err != nil {
+				return false
+			}
+		} else if net.ParseIP(entry) == nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/secnex/reverse-proxy/audit"
+	"github.com/secnex/reverse-proxy/config"
+	"github.com/secnex/reverse-proxy/models"
+)
+
+type fakeMaintenanceStore struct {
+	windows      []models.MaintenanceWindow
+	created      models.MaintenanceWindow
+	updated      models.MaintenanceWindow
+	bypassSecret *string
+}
+
+func (s *fakeMaintenanceStore) ListMaintenanceWindows(domain string) ([]models.MaintenanceWindow, error) {
+	return s.windows, nil
+}
+
+func (s *fakeMaintenanceStore) CreateMaintenanceWindow(actor string, window models.MaintenanceWindow) error {
+	s.created = window
+	return nil
+}
+
+func (s *fakeMaintenanceStore) UpdateMaintenanceWindow(actor string, id uint, window models.MaintenanceWindow, bypassSecret *string) error {
+	s.updated, s.bypassSecret = window, bypassSecret
+	return nil
+}
+
+func (s *fakeMaintenanceStore) DeleteMaintenanceWindow(actor string, id uint) error {
+	return nil
+}
+
+func TestMaintenanceBypassSecretIsWriteOnly(t *testing.T) {
+	store := &fakeMaintenanceStore{}
+	s := NewAPIServer(config.APIConfig{})
+	s.SetMaintenanceStore(store)
+
+	w := httptest.NewRecorder()
+	s.handleMaintenance(w, httptest.NewRequest(http.MethodPost, "/api/websites/maintenance",
+		strings.NewReader(`{"Domain": "example.com", "BypassSecret": "s3cret"}`)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST status = %d: %s", w.Code, w.Body.String())
+	}
+	if store.created.BypassSecret != "s3cret" {
+		t.Errorf("created secret = %q", store.created.BypassSecret)
+	}
+
+	store.windows = []models.MaintenanceWindow{store.created}
+	w = httptest.NewRecorder()
+	s.handleMaintenance(w, httptest.NewRequest(http.MethodGet, "/api/websites/maintenance?domain=example.com", nil))
+	if strings.Contains(w.Body.String(), "s3cret") {
+		t.Errorf("GET returned the secret: %s", w.Body.String())
+	}
+
+	entry := audit.NewEntry("admin", audit.ActionCreate, audit.ResourceMaintenance, "1", nil, store.created)
+	if strings.Contains(string(entry.After), "s3cret") {
+		t.Errorf("audit entry contains the secret: %s", entry.After)
+	}
+
+	w = httptest.NewRecorder()
+	s.handleMaintenance(w, httptest.NewRequest(http.MethodPut, "/api/websites/maintenance?id=1",
+		strings.NewReader(`{"Domain": "example.com", "Message": "later"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT status = %d: %s", w.Code, w.Body.String())
+	}
+	if store.bypassSecret != nil {
+		t.Errorf("update without secret changes it to %q", *store.bypassSecret)
+	}
+}
+
+func TestValidMaintenanceWindow(t *testing.T) {
+	tests := []struct {
+		name   string
+		window models.MaintenanceWindow
+		want   bool
+	}{
+		{"immediate", models.MaintenanceWindow{Domain: "example.com"}, true},
+		{"missing domain", models.MaintenanceWindow{}, false},
+		{"negative retry after", models.MaintenanceWindow{Domain: "example.com", RetryAfter: -1}, false},
+		{"bypass ip and cidr", models.MaintenanceWindow{Domain: "example.com", BypassIPs: []string{"10.0.0.1", "2001:db8::/32"}}, true},
+		{"invalid bypass ip", models.MaintenanceWindow{Domain: "example.com", BypassIPs: []string{"10.0.0"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validMaintenanceWindow(tt.window); got != tt.want {
+				t.Errorf("validMaintenanceWindow() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
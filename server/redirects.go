@@ -0,0 +1,212 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/secnex/reverse-proxy/models"
+)
+
+// maxRedirectImportSize limits the size of CSV imports.
+const maxRedirectImportSize = 10 << 20
+
+type RedirectRuleStore interface {
+	ListRedirectRules(domain string) ([]models.RedirectRule, error)
+	CreateRedirectRule(actor string, rule models.RedirectRule) error
+	UpdateRedirectRule(actor string, id uint, rule models.RedirectRule) error
+	DeleteRedirectRule(actor string, id uint) error
+	ImportRedirectRules(actor string, domain string, rules []models.RedirectRule, replace bool) error
+}
+
+func (s *APIServer) handleRedirectRules(w http.ResponseWriter, r *http.Request) {
+	if s.redirectRules == nil {
+		http.Error(w, "Keine Datenbank konfiguriert", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		domain := r.URL.Query().Get("domain")
+		if domain == "" {
+			http.Error(w, "Domain fehlt", http.StatusBadRequest)
+			return
+		}
+		rules, err := s.redirectRules.ListRedirectRules(domain)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, rules)
+	case http.MethodPost:
+		var rule models.RedirectRule
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil || rule.Domain == "" || validRedirectRule(rule) != nil {
+			http.Error(w, "Ungültige Regel", http.StatusBadRequest)
+			return
+		}
+		if err := s.redirectRules.CreateRedirectRule(Actor(r), rule); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.reloadAfterChange(w, http.StatusCreated)
+	case http.MethodPut:
+		id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "ID fehlt", http.StatusBadRequest)
+			return
+		}
+		var rule models.RedirectRule
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil || rule.Domain == "" || validRedirectRule(rule) != nil {
+			http.Error(w, "Ungültige Regel", http.StatusBadRequest)
+			return
+		}
+		if err := s.redirectRules.UpdateRedirectRule(Actor(r), uint(id), rule); err != nil {
+			writeStoreError(w, err, "Regel nicht gefunden")
+			return
+		}
+		s.reloadAfterChange(w, http.StatusOK)
+	case http.MethodDelete:
+		id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "ID fehlt", http.StatusBadRequest)
+			return
+		}
+		if err := s.redirectRules.DeleteRedirectRule(Actor(r), uint(id)); err != nil {
+			writeStoreError(w, err, "Regel nicht gefunden")
+			return
+		}
+		s.reloadAfterChange(w, http.StatusOK)
+	default:
+		http.Error(w, "Methode nicht erlaubt", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleRedirectImport imports a CSV redirect map with the columns source,
+// target, status, regex, preserve_path and preserve_query. Only source and
+// target are required, a header row starting with "source" is skipped.
+func (s *APIServer) handleRedirectImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Methode nicht erlaubt", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.redirectRules == nil {
+		http.Error(w, "Keine Datenbank konfiguriert", http.StatusServiceUnavailable)
+		return
+	}
+
+	domain := r.URL.Query().Get("domain")
+	if domain == "" {
+		http.Error(w, "Domain fehlt", http.StatusBadRequest)
+		return
+	}
+	replace, _ := strconv.ParseBool(r.URL.Query().Get("replace"))
+
+	rules, err := parseRedirectCSV(http.MaxBytesReader(w, r.Body, maxRedirectImportSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.redirectRules.ImportRedirectRules(Actor(r), domain, rules, replace); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.reloadAfterChange(w, http.StatusCreated)
+}
+
+func parseRedirectCSV(body io.Reader) ([]models.RedirectRule, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+
+	var rules []models.RedirectRule
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return rules, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ungültige CSV-Datei: %v", err)
+		}
+		line, _ := reader.FieldPos(0)
+		if len(rules) == 0 && strings.EqualFold(strings.TrimSpace(record[0]), "source") {
+			continue
+		}
+
+		rule, err := parseRedirectRecord(record)
+		if err == nil {
+			err = validRedirectRule(rule)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Zeile %d: %v", line, err)
+		}
+		rules = append(rules, rule)
+	}
+}
+
+func parseRedirectRecord(record []string) (models.RedirectRule, error) {
+	if len(record) < 2 || len(record) > 6 {
+		return models.RedirectRule{}, errors.New("2 bis 6 Spalten erwartet")
+	}
+	field := func(i int) string {
+		if i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	rule := models.RedirectRule{Source: field(0), Target: field(1)}
+	if value := field(2); value != "" {
+		status, err := strconv.Atoi(value)
+		if err != nil {
+			return rule, fmt.Errorf("ungültiger Status %q", value)
+		}
+		rule.Status = status
+	}
+	flags := []*bool{&rule.Regex, &rule.PreservePath, &rule.PreserveQuery}
+	for i, flag := range flags {
+		value := field(3 + i)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return rule, fmt.Errorf("ungültiger Wert %q", value)
+		}
+		*flag = parsed
+	}
+	return rule, nil
+}
+
+// validRedirectRule checks everything but the domain, which imports take
+// from the query.
+func validRedirectRule(rule models.RedirectRule) error {
+	if rule.Source == "" || rule.Target == "" || strings.ContainsAny(rule.Target, "\r\n") {
+		return errors.New("Quelle und Ziel dürfen nicht leer sein")
+	}
+	if !validRedirectStatus(rule.Status) {
+		return fmt.Errorf("ungültiger Status %d", rule.Status)
+	}
+	if rule.Regex {
+		if _, err := regexp.Compile(rule.Source); err != nil {
+			return fmt.Errorf("ungültiger regulärer Ausdruck: %v", err)
+		}
+	} else if !strings.HasPrefix(rule.Source, "/") {
+		return errors.New("Quelle muss mit / beginnen")
+	}
+	return nil
+}
+
+func validRedirectStatus(status int) bool {
+	switch status {
+	case 0, http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
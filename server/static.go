@@ -1,34 +1,272 @@
 package server
 
 import (
+	"errors"
 	"fmt"
+	"html"
+	"io/fs"
+	"mime"
 	"net/http"
-	"os"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
 )
 
+// precompressed lists the sidecar files that are served instead of the
+// original file, in order of preference.
+var precompressed = []struct {
+	encoding  string
+	extension string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+type StaticOptions struct {
+	// IndexFiles are tried in order for directory requests, defaulting to
+	// index.html.
+	IndexFiles []string
+	// SPA serves the first index file of the root for missing paths without
+	// file extension, so client-side routes work on reload.
+	SPA              bool
+	DirectoryListing bool
+	// CacheControl is sent with every file when set.
+	CacheControl string
+	// NotFound handles missing files, defaulting to http.NotFound.
+	NotFound http.HandlerFunc
+}
+
+// StaticServer serves the files of a directory with index files, optional SPA
+// fallback and directory listings. Precompressed .br and .gz files next to
+// the original are served to clients accepting the encoding.
 type StaticServer struct {
-	rootDir string
+	root    http.FileSystem
+	options StaticOptions
 }
 
-func NewStaticServer(rootDir string) *StaticServer {
+func NewStaticServer(rootDir string, options StaticOptions) *StaticServer {
+	if len(options.IndexFiles) == 0 {
+		options.IndexFiles = []string{"index.html"}
+	}
+	if options.NotFound == nil {
+		options.NotFound = http.NotFound
+	}
 	return &StaticServer{
-		rootDir: rootDir,
+		root:    http.Dir(rootDir),
+		options: options,
 	}
 }
 
-func (s *StaticServer) Start(port int) error {
-	if err := os.MkdirAll(s.rootDir, 0755); err != nil {
-		return err
+// ResolveStaticRoot returns the directory of a static website. Relative roots
+// are taken relative to baseDir, absolute ones have to be inside it. Symlinks
+// are resolved first, so they cannot lead out of baseDir either.
+func ResolveStaticRoot(baseDir string, root string) (string, error) {
+	if baseDir == "" || root == "" {
+		return "", errors.New("no static directory configured")
+	}
+	base, err := resolvePath(baseDir)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(root) {
+		root = filepath.Join(baseDir, root)
+	}
+	resolved, err := resolvePath(root)
+	if err != nil {
+		return "", err
 	}
 
-	fileServer := http.FileServer(http.Dir(s.rootDir))
+	rel, err := filepath.Rel(base, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("static root %s is outside of %s", root, baseDir)
+	}
+	return resolved, nil
+}
+
+func resolvePath(name string) (string, error) {
+	name, err := filepath.Abs(name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(name)
+}
+
+func (s *StaticServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Methode nicht erlaubt", http.StatusMethodNotAllowed)
+		return
+	}
 
-	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "/" {
-			r.URL.Path = "/index.html"
+	name := path.Clean("/" + r.URL.Path)
+	if hiddenPath(name) {
+		s.options.NotFound(w, r)
+		return
+	}
+
+	info, err := s.stat(name)
+	if err == nil && info.IsDir() {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			target := path.Base(name) + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		if index, indexInfo := s.index(name); indexInfo != nil {
+			s.serveFile(w, r, index, indexInfo)
+			return
+		}
+		if s.options.DirectoryListing {
+			s.serveListing(w, r, name)
+			return
+		}
+		err = fs.ErrNotExist
+	}
+
+	if err != nil {
+		if s.options.SPA && path.Ext(name) == "" {
+			if index, indexInfo := s.index("/"); indexInfo != nil {
+				s.serveFile(w, r, index, indexInfo)
+				return
+			}
+		}
+		s.options.NotFound(w, r)
+		return
+	}
+
+	s.serveFile(w, r, name, info)
+}
+
+func (s *StaticServer) stat(name string) (fs.FileInfo, error) {
+	f, err := s.root.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Stat()
+}
+
+// index returns the first index file that exists in the directory.
+func (s *StaticServer) index(dir string) (string, fs.FileInfo) {
+	for _, index := range s.options.IndexFiles {
+		name := path.Join(dir, index)
+		if info, err := s.stat(name); err == nil && !info.IsDir() {
+			return name, info
 		}
-		fileServer.ServeHTTP(w, r)
-	})
+	}
+	return "", nil
+}
 
-	return http.ListenAndServe(fmt.Sprintf(":%d", port), handler)
+// serveFile serves the file or a precompressed sidecar of it. ETag, Range and
+// conditional requests are handled by http.ServeContent.
+func (s *StaticServer) serveFile(w http.ResponseWriter, r *http.Request, name string, info fs.FileInfo) {
+	contentType := mime.TypeByExtension(path.Ext(name))
+
+	served, servedInfo := name, info
+	vary := false
+	for _, sidecar := range precompressed {
+		sidecarInfo, err := s.stat(name + sidecar.extension)
+		if err != nil || sidecarInfo.IsDir() {
+			continue
+		}
+		vary = true
+		if contentType != "" && served == name && acceptsEncoding(r.Header.Get("Accept-Encoding"), sidecar.encoding) {
+			served, servedInfo = name+sidecar.extension, sidecarInfo
+			w.Header().Set("Content-Encoding", sidecar.encoding)
+		}
+	}
+	if vary {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+
+	f, err := s.root.Open(served)
+	if err != nil {
+		s.options.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	if s.options.CacheControl != "" {
+		w.Header().Set("Cache-Control", s.options.CacheControl)
+	}
+	w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, servedInfo.ModTime().UnixNano(), servedInfo.Size()))
+	http.ServeContent(w, r, name, servedInfo.ModTime(), f)
+}
+
+func (s *StaticServer) serveListing(w http.ResponseWriter, r *http.Request, name string) {
+	dir, err := s.root.Open(name)
+	if err != nil {
+		s.options.NotFound(w, r)
+		return
+	}
+	defer dir.Close()
+
+	entries, err := dir.Readdir(-1)
+	if err != nil {
+		http.Error(w, "Verzeichnis kann nicht gelesen werden", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	title := html.EscapeString(name)
+	fmt.Fprintf(w, "<!DOCTYPE html>\n<html>\n<head><meta charset=\"UTF-8\"><title>%s</title></head>\n<body>\n<h1>%s</h1>\n<ul>\n", title, title)
+	if name != "/" {
+		fmt.Fprintf(w, "<li><a href=\"../\">../</a></li>\n")
+	}
+	for _, entry := range entries {
+		entryName := entry.Name()
+		if strings.HasPrefix(entryName, ".") {
+			continue
+		}
+		if entry.IsDir() {
+			entryName += "/"
+		}
+		link := url.URL{Path: entryName}
+		fmt.Fprintf(w, "<li><a href=\"%s\">%s</a></li>\n", html.EscapeString(link.String()), html.EscapeString(entryName))
+	}
+	fmt.Fprintf(w, "</ul>\n</body>\n</html>\n")
+}
+
+// hiddenPath reports whether the path contains a dot file or directory.
+// .well-known stays reachable.
+func hiddenPath(name string) bool {
+	for _, segment := range strings.Split(name, "/") {
+		if strings.HasPrefix(segment, ".") && segment != ".well-known" {
+			return true
+		}
+	}
+	return false
+}
+
+func acceptsEncoding(acceptEncoding string, encoding string) bool {
+	accepted := false
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != encoding && name != "*" {
+			continue
+		}
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if q, err := strconv.ParseFloat(value, 64); err == nil {
+				quality = q
+			}
+		}
+		if name == encoding {
+			return quality > 0
+		}
+		accepted = quality > 0
+	}
+	return accepted
 }
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles creates the files below dir, directories end with a slash.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if strings.HasSuffix(name, "/") {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestResolveStaticRoot(t *testing.T) {
+	base := t.TempDir()
+	outside := t.TempDir()
+	writeFiles(t, base, map[string]string{"site/": "", "other/": ""})
+	if err := os.Symlink(outside, filepath.Join(base, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(base, "other"), filepath.Join(base, "alias")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		root string
+		want string
+	}{
+		{"relative", "site", "site"},
+		{"absolute inside", filepath.Join(base, "site"), "site"},
+		{"base itself", base, ""},
+		{"symlink inside", "alias", "other"},
+		{"parent", "..", "-"},
+		{"traversal", "site/../../etc", "-"},
+		{"absolute outside", "/etc", "-"},
+		{"root", "/", "-"},
+		{"symlink outside", "escape", "-"},
+		{"missing", "missing", "-"},
+		{"empty", "", "-"},
+	}
+
+	resolvedBase, _ := filepath.EvalSymlinks(base)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveStaticRoot(base, tt.root)
+			if tt.want == "-" {
+				if err == nil {
+					t.Errorf("ResolveStaticRoot(%q) = %q, want an error", tt.root, got)
+				}
+				return
+			}
+			if want := filepath.Join(resolvedBase, tt.want); err != nil || got != want {
+				t.Errorf("ResolveStaticRoot(%q) = %q, %v, want %q", tt.root, got, err, want)
+			}
+		})
+	}
+
+	if _, err := ResolveStaticRoot("", "site"); err == nil {
+		t.Error("ResolveStaticRoot without static directory succeeded")
+	}
+}
+
+func TestStaticServer(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"index.html":        "home",
+		"app.js":            "plain js",
+		"app.js.br":         "brotli js",
+		"app.js.gz":         "gzip js",
+		"style.css":         "plain css",
+		"style.css.gz":      "gzip css",
+		"docs/readme.txt":   "0123456789",
+		"docs/start.htm":    "docs start",
+		"empty/":            "",
+		"empty/file.txt":    "file",
+		".env":              "secret",
+		".well-known/a.txt": "well known",
+	})
+
+	tests := []struct {
+		name     string
+		options  StaticOptions
+		method   string
+		path     string
+		header   http.Header
+		status   int
+		body     string
+		encoding string
+	}{
+		{name: "file", path: "/docs/readme.txt", status: http.StatusOK, body: "0123456789"},
+		{name: "index", path: "/", status: http.StatusOK, body: "home"},
+		{name: "custom index", options: StaticOptions{IndexFiles: []string{"start.htm"}}, path: "/docs/", status: http.StatusOK, body: "docs start"},
+		{name: "directory without slash", path: "/docs", status: http.StatusMovedPermanently},
+		{name: "missing", path: "/missing", status: http.StatusNotFound},
+		{name: "SPA fallback", options: StaticOptions{SPA: true}, path: "/users/42", status: http.StatusOK, body: "home"},
+		{name: "SPA missing asset", options: StaticOptions{SPA: true}, path: "/missing.png", status: http.StatusNotFound},
+		{name: "listing disabled", path: "/empty/", status: http.StatusNotFound},
+		{name: "listing enabled", options: StaticOptions{DirectoryListing: true}, path: "/empty/", status: http.StatusOK, body: `<a href="file.txt">`},
+		{name: "dot file", path: "/.env", status: http.StatusNotFound},
+		{name: "well-known", path: "/.well-known/a.txt", status: http.StatusOK, body: "well known"},
+		{name: "brotli sidecar", path: "/app.js", header: http.Header{"Accept-Encoding": {"gzip, br"}}, status: http.StatusOK, body: "brotli js", encoding: "br"},
+		{name: "gzip sidecar", path: "/app.js", header: http.Header{"Accept-Encoding": {"gzip, br;q=0"}}, status: http.StatusOK, body: "gzip js", encoding: "gzip"},
+		{name: "only gzip sidecar", path: "/style.css", header: http.Header{"Accept-Encoding": {"br, gzip"}}, status: http.StatusOK, body: "gzip css", encoding: "gzip"},
+		{name: "no accepted encoding", path: "/app.js", status: http.StatusOK, body: "plain js"},
+		{name: "range", path: "/docs/readme.txt", header: http.Header{"Range": {"bytes=2-5"}}, status: http.StatusPartialContent, body: "2345"},
+		{name: "POST", method: http.MethodPost, path: "/", status: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			r := httptest.NewRequest(method, "http://a.example"+tt.path, nil)
+			for name, values := range tt.header {
+				r.Header[name] = values
+			}
+			w := httptest.NewRecorder()
+			NewStaticServer(root, tt.options).ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if !strings.Contains(w.Body.String(), tt.body) {
+				t.Errorf("body = %q, want %q", w.Body.String(), tt.body)
+			}
+			if got := w.Header().Get("Content-Encoding"); got != tt.encoding {
+				t.Errorf("Content-Encoding = %q, want %q", got, tt.encoding)
+			}
+		})
+	}
+}
+
+func TestStaticServerETag(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"page.html": "page", "page.html.gz": "gzip page"})
+	server := NewStaticServer(root, StaticOptions{CacheControl: "max-age=300"})
+
+	get := func(header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "http://a.example/page.html", nil)
+		for name, values := range header {
+			r.Header[name] = values
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w
+	}
+
+	plain := get(nil)
+	etag := plain.Header().Get("ETag")
+	if etag == "" || plain.Header().Get("Last-Modified") == "" {
+		t.Fatalf("validators missing: %v", plain.Header())
+	}
+	if got := plain.Header().Get("Cache-Control"); got != "max-age=300" {
+		t.Errorf("Cache-Control = %q", got)
+	}
+	if got := plain.Header().Get("Vary"); got != "Accept-Encoding" {
+		t.Errorf("Vary = %q", got)
+	}
+	if got := plain.Header().Get("Content-Type"); got != "text/html; charset=utf-8" {
+		t.Errorf("Content-Type = %q", got)
+	}
+
+	if w := get(http.Header{"If-None-Match": {etag}}); w.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match: status = %d, want %d", w.Code, http.StatusNotModified)
+	}
+	if w := get(http.Header{"If-None-Match": {`"other"`}}); w.Code != http.StatusOK {
+		t.Errorf("other If-None-Match: status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	// The sidecar is a different representation with its own ETag.
+	compressed := get(http.Header{"Accept-Encoding": {"gzip"}})
+	if compressed.Header().Get("ETag") == etag {
+		t.Error("compressed and plain response share an ETag")
+	}
+	if got := compressed.Header().Get("Content-Type"); got != "text/html; charset=utf-8" {
+		t.Errorf("compressed Content-Type = %q", got)
+	}
+}
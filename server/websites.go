@@ -0,0 +1,232 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/secnex/reverse-proxy/audit"
+	"github.com/secnex/reverse-proxy/models"
+	"gorm.io/gorm"
+)
+
+type WebsiteStore interface {
+	GetWebsite(domain string) (*models.Website, error)
+	GetAllWebsites() ([]models.Website, error)
+	CreateWebsite(actor string, config models.WebsiteConfig) error
+	UpdateWebsite(actor string, domain string, config models.WebsiteConfig) error
+	DeleteWebsite(actor string, domain string) error
+}
+
+type CertificateManager interface {
+	RenewCertificate(actor string, host string, providerType string, email string) error
+}
+
+func (s *APIServer) handleWebsites(w http.ResponseWriter, r *http.Request) {
+	if s.websites == nil {
+		http.Error(w, "Keine Datenbank konfiguriert", http.StatusServiceUnavailable)
+		return
+	}
+
+	domain := r.URL.Query().Get("domain")
+
+	switch r.Method {
+	case http.MethodGet:
+		if domain != "" {
+			website, err := s.websites.GetWebsite(domain)
+			if err != nil {
+				http.Error(w, "Website nicht gefunden", http.StatusNotFound)
+				return
+			}
+			writeJSON(w, http.StatusOK, website)
+			return
+		}
+
+		websites, err := s.websites.GetAllWebsites()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, websites)
+	case http.MethodPost:
+		var config models.WebsiteConfig
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil || !validDomain(config.Domain) || !s.validWebsite(config) {
+			http.Error(w, "Ungültige Konfiguration", http.StatusBadRequest)
+			return
+		}
+		if err := s.websites.CreateWebsite(Actor(r), config); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.reloadAfterChange(w, http.StatusCreated)
+	case http.MethodPut:
+		var config models.WebsiteConfig
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil || domain == "" || !s.validWebsite(config) {
+			http.Error(w, "Ungültige Konfiguration", http.StatusBadRequest)
+			return
+		}
+		if _, err := s.websites.GetWebsite(domain); err != nil {
+			http.Error(w, "Website nicht gefunden", http.StatusNotFound)
+			return
+		}
+		if err := s.websites.UpdateWebsite(Actor(r), domain, config); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.reloadAfterChange(w, http.StatusOK)
+	case http.MethodDelete:
+		if domain == "" {
+			http.Error(w, "Domain fehlt", http.StatusBadRequest)
+			return
+		}
+		if err := s.websites.DeleteWebsite(Actor(r), domain); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				http.Error(w, "Website nicht gefunden", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.reloadAfterChange(w, http.StatusOK)
+	default:
+		http.Error(w, "Methode nicht erlaubt", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *APIServer) handleWebsiteActive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Methode nicht erlaubt", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		Domain string `json:"domain"`
+		Active bool   `json:"active"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Domain == "" {
+		http.Error(w, "Ungültige Anfrage", http.StatusBadRequest)
+		return
+	}
+
+	before := s.IsActiveConfig(request.Domain)
+	s.SetActiveConfig(request.Domain, request.Active)
+
+	action := audit.ActionDeactivate
+	if request.Active {
+		action = audit.ActionActivate
+	}
+	audit.Record(s.audit, audit.NewEntry(Actor(r), action, audit.ResourceWebsite, request.Domain, map[string]bool{"active": before}, map[string]bool{"active": request.Active}))
+	writeJSON(w, http.StatusOK, request)
+}
+
+func (s *APIServer) handleCertificateRenew(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Methode nicht erlaubt", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.certificates == nil {
+		http.Error(w, "Kein Zertifikatsmanager konfiguriert", http.StatusServiceUnavailable)
+		return
+	}
+
+	var request struct {
+		Domain   string `json:"domain"`
+		Provider string `json:"provider"`
+		Email    string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || !validDomain(request.Domain) {
+		http.Error(w, "Ungültige Anfrage", http.StatusBadRequest)
+		return
+	}
+	if request.Provider == "" {
+		request.Provider = "self"
+	}
+
+	if err := s.certificates.RenewCertificate(Actor(r), request.Domain, request.Provider, request.Email); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "Zertifikat erneuert"})
+}
+
+func (s *APIServer) reloadAfterChange(w http.ResponseWriter, status int) {
+	if s.reload != nil {
+		if err := s.reload(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	writeJSON(w, status, map[string]string{"message": "Konfiguration gespeichert"})
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// validDomain accepts host names and IP addresses. Domains name certificate
+// files, so anything else, such as path separators, is rejected.
+func validDomain(domain string) bool {
+	if net.ParseIP(domain) != nil {
+		return true
+	}
+	if domain == "" || len(domain) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// validWebsite checks the site type and auth mode, static sites need a root
+// directory inside the static directory. Country rules are only accepted with
+// a GeoIP database, without one they would never match.
+func (s *APIServer) validWebsite(config models.WebsiteConfig) bool {
+	if !validAuth(config) {
+		return false
+	}
+	if !s.countryRules && (len(config.AllowCountries) > 0 || len(config.DenyCountries) > 0) {
+		return false
+	}
+	switch config.Type {
+	case "", "proxy":
+		return true
+	case "static":
+		_, err := ResolveStaticRoot(s.staticDir, config.StaticRoot)
+		return err == nil
+	case "redirect":
+		return validRedirectStatus(config.RedirectStatus)
+	}
+	return false
+}
+
+// validAuth checks that the auth mode is known and its settings are present.
+// The proxy refuses requests to sites with an unknown mode.
+func validAuth(config models.WebsiteConfig) bool {
+	switch config.AuthMode {
+	case "", "basic":
+		return true
+	case "forward":
+		return validHTTPURL(config.ForwardAuthURL)
+	case "oidc":
+		return validHTTPURL(config.OIDCIssuer) && config.OIDCClientID != ""
+	}
+	return false
+}
+
+func validHTTPURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/secnex/reverse-proxy/config"
+	"github.com/secnex/reverse-proxy/models"
+)
+
+func TestValidDomain(t *testing.T) {
+	tests := []struct {
+		domain string
+		want   bool
+	}{
+		{"example.com", true},
+		{"sub-1.Example.com", true},
+		{"localhost", true},
+		{"10.0.0.1", true},
+		{"2001:db8::1", true},
+		{"", false},
+		{"../../etc/x", false},
+		{"example.com/..", false},
+		{`example\com`, false},
+		{"-example.com", false},
+		{"example..com", false},
+		{"exa mple.com", false},
+		{strings.Repeat("a", 64) + ".com", false},
+	}
+	for _, tt := range tests {
+		if got := validDomain(tt.domain); got != tt.want {
+			t.Errorf("validDomain(%q) = %v, want %v", tt.domain, got, tt.want)
+		}
+	}
+}
+
+func TestValidWebsite(t *testing.T) {
+	tests := []struct {
+		name   string
+		config models.WebsiteConfig
+		want   bool
+	}{
+		{"proxy", models.WebsiteConfig{}, true},
+		{"static", models.WebsiteConfig{Type: "static", StaticRoot: "site"}, true},
+		{"static without root", models.WebsiteConfig{Type: "static"}, false},
+		{"static root outside", models.WebsiteConfig{Type: "static", StaticRoot: "/etc"}, false},
+		{"static root traversal", models.WebsiteConfig{Type: "static", StaticRoot: "../etc"}, false},
+		{"unknown type", models.WebsiteConfig{Type: "mirror"}, false},
+		{"basic auth", models.WebsiteConfig{AuthMode: "basic"}, true},
+		{"unknown auth mode", models.WebsiteConfig{AuthMode: "Basic"}, false},
+		{"auth mode with space", models.WebsiteConfig{AuthMode: "oidc "}, false},
+		{"forward auth", models.WebsiteConfig{AuthMode: "forward", ForwardAuthURL: "http://auth:9000/verify"}, true},
+		{"forward auth without URL", models.WebsiteConfig{AuthMode: "forward"}, false},
+		{"forward auth with relative URL", models.WebsiteConfig{AuthMode: "forward", ForwardAuthURL: "/verify"}, false},
+		{"oidc", models.WebsiteConfig{AuthMode: "oidc", OIDCIssuer: "https://id.example", OIDCClientID: "proxy"}, true},
+		{"oidc without issuer", models.WebsiteConfig{AuthMode: "oidc", OIDCClientID: "proxy"}, false},
+		{"oidc without client ID", models.WebsiteConfig{AuthMode: "oidc", OIDCIssuer: "https://id.example"}, false},
+		{"allowed countries without GeoIP", models.WebsiteConfig{AllowCountries: []string{"DE"}}, false},
+		{"denied countries without GeoIP", models.WebsiteConfig{DenyCountries: []string{"RU"}}, false},
+	}
+	staticDir := t.TempDir()
+	writeFiles(t, staticDir, map[string]string{"site/": ""})
+	s := NewAPIServer(config.APIConfig{})
+	s.SetStaticDir(staticDir)
+	for _, tt := range tests {
+		if got := s.validWebsite(tt.config); got != tt.want {
+			t.Errorf("%s: validWebsite() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	s.SetCountryRulesEnabled(true)
+	if !s.validWebsite(models.WebsiteConfig{AllowCountries: []string{"DE"}, DenyCountries: []string{"RU"}}) {
+		t.Error("country rules with GeoIP rejected")
+	}
+}
+
+type fakeCertificateManager struct {
+	renewed []string
+}
+
+func (m *fakeCertificateManager) RenewCertificate(actor string, host string, providerType string, email string) error {
+	m.renewed = append(m.renewed, host)
+	return nil
+}
+
+func TestCertificateRenewRejectsInvalidDomain(t *testing.T) {
+	certificates := &fakeCertificateManager{}
+	s := NewAPIServer(config.APIConfig{})
+	s.SetCertificateManager(certificates)
+
+	tests := []struct {
+		body string
+		want int
+	}{
+		{`{"domain": "../../etc/x"}`, http.StatusBadRequest},
+		{`{"domain": ""}`, http.StatusBadRequest},
+		{`{"domain": "example.com"}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		s.handleCertificateRenew(w, httptest.NewRequest(http.MethodPost, "/api/certificates/renew", strings.NewReader(tt.body)))
+		if w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.body, w.Code, tt.want)
+		}
+	}
+	if len(certificates.renewed) != 1 || certificates.renewed[0] != "example.com" {
+		t.Errorf("renewed = %v", certificates.renewed)
+	}
+}
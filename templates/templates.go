@@ -0,0 +1,229 @@
+// Package templates embeds the pages of the proxy and renders them at
+// startup. Files in an override directory take precedence over the embedded
+// ones.
+package templates
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed *.html
+var embedded embed.FS
+
+// Required lists the pages the proxy serves. Startup fails if one of them is
+// neither in the override directory nor embedded.
+var Required = []string{
+	"index.html",
+	"401.html",
+	"403.html",
+	"404.html",
+	"408.html",
+	"413.html",
+	"429.html",
+	"431.html",
+	"502.html",
+	"503.html",
+	"504.html",
+}
+
+type Branding struct {
+	Title       string
+	Description string
+	Version     string
+	Year        string
+	Author      string
+}
+
+func DefaultBranding() Branding {
+	return Branding{
+		Title:       "SecNex Reverse Proxy",
+		Description: "SecNex Reverse Proxy",
+		Version:     "0.1.0",
+		Year:        "2025",
+		Author:      "SecNex",
+	}
+}
+
+// Request holds the values of a page that depend on the request.
+type Request struct {
+	Status    int
+	Message   string
+	Notice    string
+	Host      string
+	RequestID string
+}
+
+// pageData is passed to the templates. Branding and request values are
+// filled in by the same execution, so template syntax in either of them is
+// escaped like any other text and never parsed.
+type pageData struct {
+	Branding
+	Status    string
+	Message   string
+	Notice    string
+	Host      string
+	RequestID string
+}
+
+// placeholders stand in for the request values in the rendered pages.
+func placeholders(branding Branding) pageData {
+	return pageData{
+		Branding:  branding,
+		Status:    "{{ .Status }}",
+		Message:   "{{ .Message }}",
+		Notice:    "{{ .Notice }}",
+		Host:      "{{ .Host }}",
+		RequestID: "{{ .RequestID }}",
+	}
+}
+
+// Pages holds the parsed pages by file name.
+type Pages struct {
+	branding Branding
+	rendered map[string][]byte
+	parsed   map[string]*template.Template
+}
+
+// Render parses all pages and renders them with the branding. Pages in
+// overrideDir replace the embedded pages of the same name; overrideDir may be
+// empty or missing.
+func Render(overrideDir string, branding Branding) (*Pages, error) {
+	sources, err := collect(overrideDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for _, name := range Required {
+		if _, exists := sources[name]; !exists {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing pages %s: not found in %q or the embedded templates", strings.Join(missing, ", "), overrideDir)
+	}
+
+	pages := &Pages{
+		branding: branding,
+		rendered: make(map[string][]byte),
+		parsed:   make(map[string]*template.Template),
+	}
+	for name, source := range sources {
+		tmpl, err := template.New(name).Parse(string(source.content))
+		if err != nil {
+			return nil, fmt.Errorf("parsing page %s from %s: %v", name, source.origin, err)
+		}
+		var output bytes.Buffer
+		if err := tmpl.Execute(&output, placeholders(branding)); err != nil {
+			return nil, fmt.Errorf("rendering page %s from %s: %v", name, source.origin, err)
+		}
+		pages.rendered[name] = output.Bytes()
+		pages.parsed[name] = tmpl
+	}
+	return pages, nil
+}
+
+func (p *Pages) data(request Request) pageData {
+	return pageData{
+		Branding:  p.branding,
+		Status:    strconv.Itoa(request.Status),
+		Message:   request.Message,
+		Notice:    request.Notice,
+		Host:      request.Host,
+		RequestID: request.RequestID,
+	}
+}
+
+type pageSource struct {
+	content []byte
+	origin  string
+}
+
+// collect reads the embedded pages and replaces them by the pages of the
+// override directory.
+func collect(overrideDir string) (map[string]pageSource, error) {
+	sources := make(map[string]pageSource)
+
+	names, err := fs.Glob(embedded, "*.html")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		content, err := embedded.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("reading embedded page %s: %v", name, err)
+		}
+		sources[name] = pageSource{content: content, origin: "embedded templates"}
+	}
+
+	if overrideDir == "" {
+		return sources, nil
+	}
+	info, err := os.Stat(overrideDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return sources, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("page directory %s is not a directory", overrideDir)
+	}
+
+	files, err := filepath.Glob(filepath.Join(overrideDir, "*.html"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("reading page %s: %v", file, err)
+		}
+		sources[filepath.Base(file)] = pageSource{content: content, origin: file}
+	}
+	return sources, nil
+}
+
+// Names returns the names of all pages in sorted order.
+func (p *Pages) Names() []string {
+	names := make([]string, 0, len(p.rendered))
+	for name := range p.rendered {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Rendered returns the page with the branding and placeholders for the
+// request values, as written by the generator. The proxy serves it only for
+// pages without request values.
+func (p *Pages) Rendered(name string) ([]byte, bool) {
+	if p == nil {
+		return nil, false
+	}
+	content, exists := p.rendered[name]
+	return content, exists
+}
+
+// Execute renders the page with the branding and the request values.
+func (p *Pages) Execute(w io.Writer, name string, request Request) error {
+	if p == nil {
+		return fmt.Errorf("page %s not found", name)
+	}
+	tmpl, exists := p.parsed[name]
+	if !exists {
+		return fmt.Errorf("page %s not found", name)
+	}
+	return tmpl.Execute(w, p.data(request))
+}
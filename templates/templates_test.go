@@ -0,0 +1,101 @@
+package templates
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	dir := t.TempDir()
+	page := `<h1>{{ .Title }}</h1><p>{{ .Status }} {{ .Message }}</p><p>{{ .Notice }} {{ .Host }} {{ .RequestID }}</p>`
+	if err := os.WriteFile(filepath.Join(dir, "404.html"), []byte(page), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	branding := DefaultBranding()
+	branding.Title = `{{ .RequestID }} <b>`
+	pages, err := Render(dir, branding)
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+
+	for _, name := range Required {
+		if _, exists := pages.Rendered(name); !exists {
+			t.Errorf("page %s not rendered", name)
+		}
+	}
+
+	// Template syntax in the branding and in request values is text.
+	var output bytes.Buffer
+	err = pages.Execute(&output, "404.html", Request{
+		Status:    404,
+		Message:   "Not Found",
+		Notice:    "{{ .Title }}",
+		Host:      "a.example",
+		RequestID: "req-1",
+	})
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	want := `<h1>{{ .RequestID }} &lt;b&gt;</h1><p>404 Not Found</p><p>{{ .Title }} a.example req-1</p>`
+	if output.String() != want {
+		t.Errorf("Execute() = %q, want %q", output.String(), want)
+	}
+
+	rendered, _ := pages.Rendered("404.html")
+	want = `<h1>{{ .RequestID }} &lt;b&gt;</h1><p>{{ .Status }} {{ .Message }}</p><p>{{ .Notice }} {{ .Host }} {{ .RequestID }}</p>`
+	if string(rendered) != want {
+		t.Errorf("Rendered() = %q, want %q", rendered, want)
+	}
+
+	// The embedded pages are used for everything else.
+	output.Reset()
+	if err := pages.Execute(&output, "503.html", Request{Status: 503, Notice: "Back at 4am", RequestID: "req-2"}); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if !strings.Contains(output.String(), "Back at 4am") || !strings.Contains(output.String(), "req-2") {
+		t.Errorf("embedded 503 page misses the request values: %s", output.String())
+	}
+
+	if err := pages.Execute(&output, "418.html", Request{}); err == nil {
+		t.Error("Execute() of an unknown page succeeded")
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	t.Run("missing required page", func(t *testing.T) {
+		required := Required
+		Required = append(Required[:len(Required):len(Required)], "500.html", "400.html")
+		defer func() { Required = required }()
+
+		_, err := Render(t.TempDir(), DefaultBranding())
+		if err == nil || !strings.Contains(err.Error(), "missing pages 500.html, 400.html") {
+			t.Errorf("Render() error = %v", err)
+		}
+	})
+
+	t.Run("invalid override", func(t *testing.T) {
+		dir := t.TempDir()
+		file := filepath.Join(dir, "404.html")
+		if err := os.WriteFile(file, []byte("{{ .Status"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := Render(dir, DefaultBranding())
+		if err == nil || !strings.Contains(err.Error(), "parsing page 404.html from "+file) {
+			t.Errorf("Render() error = %v", err)
+		}
+	})
+
+	t.Run("override is a file", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "www")
+		if err := os.WriteFile(file, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Render(file, DefaultBranding()); err == nil {
+			t.Error("Render() accepted a file as page directory")
+		}
+	})
+}
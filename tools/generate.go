@@ -1,76 +1,36 @@
 package main
 
 import (
-	"bytes"
-	"html/template"
+	"flag"
 	"log"
 	"os"
 	"path/filepath"
-)
-
-type TemplateGenerator struct {
-	TemplatePath string
-	Title        string
-	Description  string
-	Version      string
-	Year         string
-	Author       string
-	WWWPath      string
-}
 
-func NewTemplateGenerator(path string, title string, description string, version string, year string, author string, wwwPath string) *TemplateGenerator {
-	os.MkdirAll(wwwPath, 0755)
+	"github.com/secnex/reverse-proxy/templates"
+)
 
-	return &TemplateGenerator{
-		TemplatePath: path,
-		Title:        title,
-		Description:  description,
-		Version:      version,
-		Year:         year,
-		Author:       author,
-		WWWPath:      wwwPath,
-	}
-}
+// generate writes the rendered pages to a directory, for example to serve
+// them from another web server or as a starting point for overrides. The
+// proxy itself renders the pages at startup.
+func main() {
+	overrideDir := flag.String("templates", "", "directory with page overrides")
+	outputDir := flag.String("out", "www", "directory to write the pages to")
+	flag.Parse()
 
-func ReadTemplateFiles(path string) ([]string, error) {
-	log.Println("Reading template files from:", path)
-	files, err := filepath.Glob(path + "/*.html")
+	pages, err := templates.Render(*overrideDir, templates.DefaultBranding())
 	if err != nil {
-		return nil, err
+		log.Fatalf("Error rendering pages: %v", err)
 	}
 
-	return files, nil
-}
-
-func (t *TemplateGenerator) Generate() {
-	files, err := ReadTemplateFiles(t.TemplatePath)
-	if err != nil {
-		log.Fatalf("Error reading template files: %v", err)
+	if err := os.MkdirAll(*outputDir, 0755); err != nil {
+		log.Fatalf("Error creating output directory: %v", err)
 	}
-
-	for _, file := range files {
-		log.Println("Generating template:", file)
-		tmpl := template.Must(template.ParseFiles(file))
-		var output bytes.Buffer
-		err := tmpl.Execute(&output, t)
-		if err != nil {
-			log.Fatalf("Error executing template: %v", err)
+	for _, name := range pages.Names() {
+		content, _ := pages.Rendered(name)
+		outputFile := filepath.Join(*outputDir, name)
+		if err := os.WriteFile(outputFile, content, 0644); err != nil {
+			log.Fatalf("Error writing page: %v", err)
 		}
-
-		outputFile := filepath.Join(t.WWWPath, filepath.Base(file))
-		log.Println("Writing template to WWWPath:", outputFile)
-		err = os.WriteFile(outputFile, output.Bytes(), 0644)
-		if err != nil {
-			log.Fatalf("Error writing template to WWWPath: %v", err)
-		}
-
-		log.Println("Template written to WWWPath:", outputFile)
+		log.Println("Page written:", outputFile)
 	}
-
-	log.Println("Templates generated successfully!")
-}
-
-func main() {
-	generator := NewTemplateGenerator("templates", "SecNex Reverse Proxy", "SecNex Reverse Proxy", "0.1.0", "2025", "SecNex", "www")
-	generator.Generate()
 }
@@ -0,0 +1,32 @@
+package tracing
+
+import (
+	"fmt"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// siteSampler samples root spans with the rate of the site found in the
+// server.address attribute, falling back to the default rate.
+type siteSampler struct {
+	defaultRate float64
+	siteRate    SiteSampleRate
+}
+
+func (s siteSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	rate := s.defaultRate
+	for _, attr := range p.Attributes {
+		if attr.Key == semconv.ServerAddressKey {
+			if siteRate, ok := s.siteRate(attr.Value.AsString()); ok {
+				rate = siteRate
+			}
+			break
+		}
+	}
+	return sdktrace.TraceIDRatioBased(rate).ShouldSample(p)
+}
+
+func (s siteSampler) Description() string {
+	return fmt.Sprintf("SiteSampler{default=%g}", s.defaultRate)
+}
@@ -0,0 +1,177 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/secnex/reverse-proxy/config"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	ExporterOTLPHTTP = "otlp-http"
+	ExporterOTLPGRPC = "otlp-grpc"
+	ExporterStdout   = "stdout"
+
+	AttributeUpstream = attribute.Key("proxy.upstream")
+	AttributeRetries  = attribute.Key("proxy.retries")
+	AttributeAttempt  = attribute.Key("proxy.attempt")
+)
+
+// Tracer creates spans for proxied requests. A nil *Tracer is valid and
+// creates no spans.
+type Tracer struct {
+	provider   *sdktrace.TracerProvider
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// SiteSampleRate returns the sample rate configured for a site, if any.
+type SiteSampleRate func(host string) (float64, bool)
+
+func New(ctx context.Context, cfg config.TracingConfig, siteRate SiteSampleRate) (*Tracer, error) {
+	exporter, err := newExporter(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		semconv.ServiceName(cfg.ServiceName),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(siteSampler{
+			defaultRate: cfg.SampleRate,
+			siteRate:    siteRate,
+		})),
+	)
+
+	return &Tracer{
+		provider:   provider,
+		tracer:     provider.Tracer("github.com/secnex/reverse-proxy"),
+		propagator: propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}),
+	}, nil
+}
+
+func newExporter(ctx context.Context, cfg config.TracingConfig) (sdktrace.SpanExporter, error) {
+	switch cfg.Exporter {
+	case ExporterOTLPHTTP:
+		options := []otlptracehttp.Option{}
+		if cfg.Endpoint != "" {
+			options = append(options, otlptracehttp.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		return otlptracehttp.New(ctx, options...)
+	case ExporterOTLPGRPC:
+		options := []otlptracegrpc.Option{}
+		if cfg.Endpoint != "" {
+			options = append(options, otlptracegrpc.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			options = append(options, otlptracegrpc.WithInsecure())
+		}
+		return otlptracegrpc.New(ctx, options...)
+	case ExporterStdout:
+		return stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	}
+	return nil, fmt.Errorf("unknown trace exporter %q", cfg.Exporter)
+}
+
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	return t.provider.Shutdown(ctx)
+}
+
+// StartServer continues the trace of the incoming request and starts the
+// server span for it.
+func (t *Tracer) StartServer(r *http.Request, host string) (context.Context, trace.Span) {
+	if t == nil {
+		return r.Context(), trace.SpanFromContext(r.Context())
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	ctx := t.propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	return t.tracer.Start(ctx, r.Method+" "+host,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.ServerAddress(host),
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLPath(r.URL.Path),
+			semconv.URLScheme(scheme),
+			semconv.UserAgentOriginal(r.UserAgent()),
+		),
+	)
+}
+
+// StartClient starts a span for an upstream request and injects the trace
+// context into its headers.
+func (t *Tracer) StartClient(ctx context.Context, req *http.Request, attempt int) trace.Span {
+	if t == nil {
+		return trace.SpanFromContext(ctx)
+	}
+
+	ctx, span := t.tracer.Start(ctx, req.Method+" "+req.URL.Host,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(req.Method),
+			semconv.URLFull(req.URL.String()),
+			AttributeUpstream.String(req.URL.Scheme+"://"+req.URL.Host),
+			AttributeAttempt.Int(attempt),
+		),
+	)
+	t.propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+	return span
+}
+
+// EndClient records the outcome of an upstream request on its span.
+func EndClient(span trace.Span, resp *http.Response, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+		if resp.StatusCode >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
+		}
+	}
+	span.End()
+}
+
+// EndServer records the result of the proxied request on the server span.
+func EndServer(span trace.Span, status int, upstream string, retries int) {
+	span.SetAttributes(
+		semconv.HTTPResponseStatusCode(status),
+		AttributeRetries.Int(retries),
+	)
+	if upstream != "" {
+		span.SetAttributes(AttributeUpstream.String(upstream))
+	}
+	if status >= 500 {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	span.End()
+}
@@ -0,0 +1,69 @@
+package waf
+
+import "regexp"
+
+var (
+	requestTargets = []string{TargetURI, TargetQuery, TargetBody}
+	allTargets     = []string{TargetURI, TargetQuery, TargetHeaders, TargetBody}
+)
+
+// DefaultRules covers common SQL injection, XSS and path traversal patterns
+// as well as user agents of well-known scanners.
+func DefaultRules() *RuleSet {
+	return NewRuleSet([]Rule{
+		{
+			ID:          "930100",
+			Description: "Path traversal",
+			Targets:     []string{TargetURI, TargetQuery},
+			Pattern:     regexp.MustCompile(`(?:^|[/\\=])\.\.(?:[/\\]|$)`),
+		},
+		{
+			ID:          "930120",
+			Description: "Access to sensitive system files",
+			Targets:     requestTargets,
+			Pattern:     regexp.MustCompile(`(?i)(?:/etc/(?:passwd|shadow|hosts)|c:\\windows\\|/proc/self/)`),
+		},
+		{
+			ID:          "941100",
+			Description: "XSS script tag",
+			Targets:     allTargets,
+			Pattern:     regexp.MustCompile(`(?i)<\s*/?\s*script\b`),
+		},
+		{
+			ID:          "941110",
+			Description: "XSS event handler or javascript URI",
+			Targets:     allTargets,
+			Pattern:     regexp.MustCompile(`(?i)(?:\bon(?:error|load|click|mouseover|focus|submit)\s*=|javascript\s*:|<\s*(?:iframe|object|embed)\b)`),
+		},
+		{
+			ID:          "942100",
+			Description: "SQL injection UNION SELECT",
+			Targets:     requestTargets,
+			Pattern:     regexp.MustCompile(`(?i)\bunion\b(?:\s|/\*.*?\*/)+(?:all\s+)?select\b`),
+		},
+		{
+			ID:          "942110",
+			Description: "SQL injection tautology",
+			Targets:     requestTargets,
+			Pattern:     regexp.MustCompile(`(?i)['"]\s*(?:or|and)\s+['"]?\w+['"]?\s*=\s*['"]?\w+`),
+		},
+		{
+			ID:          "942120",
+			Description: "SQL injection comment or stacked query",
+			Targets:     requestTargets,
+			Pattern:     regexp.MustCompile(`(?i)(?:['"]\s*(?:--|#|/\*)|;\s*(?:drop|delete|insert|update|alter|truncate)\s+)`),
+		},
+		{
+			ID:          "942130",
+			Description: "SQL injection time-based functions",
+			Targets:     requestTargets,
+			Pattern:     regexp.MustCompile(`(?i)\b(?:sleep|benchmark|pg_sleep|waitfor\s+delay)\s*\(`),
+		},
+		{
+			ID:          "913100",
+			Description: "Security scanner user agent",
+			Targets:     []string{TargetUserAgent},
+			Pattern:     regexp.MustCompile(`(?i)(?:sqlmap|nikto|nmap|masscan|acunetix|nessus|dirbuster|wpscan|zgrab)`),
+		},
+	})
+}
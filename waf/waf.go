@@ -0,0 +1,139 @@
+package waf
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const (
+	ModeOff    = ""
+	ModeDetect = "detect"
+	ModeBlock  = "block"
+)
+
+// Targets of a rule.
+const (
+	TargetMethod    = "method"
+	TargetURI       = "uri"
+	TargetQuery     = "query"
+	TargetHeaders   = "headers"
+	TargetUserAgent = "user-agent"
+	TargetBody      = "body"
+)
+
+// Request is the part of an HTTP request that is inspected. Body holds at
+// most the configured number of leading bytes.
+type Request struct {
+	Method string
+	URI    string
+	Query  url.Values
+	Header http.Header
+	Body   []byte
+}
+
+type Match struct {
+	RuleID string
+	Target string
+}
+
+// Inspector evaluates a request and returns all rule hits.
+type Inspector interface {
+	Inspect(req *Request, disabled []string) []Match
+}
+
+type Rule struct {
+	ID          string
+	Description string
+	Targets     []string
+	Pattern     *regexp.Regexp
+}
+
+// RuleSet is an Inspector matching regular expressions against the targets
+// of the request.
+type RuleSet struct {
+	rules []Rule
+}
+
+func NewRuleSet(rules []Rule) *RuleSet {
+	return &RuleSet{rules: rules}
+}
+
+func (s *RuleSet) Inspect(req *Request, disabled []string) []Match {
+	var matches []Match
+	for _, rule := range s.rules {
+		if slices.Contains(disabled, rule.ID) {
+			continue
+		}
+		for _, target := range rule.Targets {
+			if matchesAny(rule.Pattern, values(req, target)) {
+				matches = append(matches, Match{RuleID: rule.ID, Target: target})
+				break
+			}
+		}
+	}
+	return matches
+}
+
+// values returns the decoded values of a target. The URI and body are
+// inspected both raw and decoded to catch encoded payloads.
+func values(req *Request, target string) []string {
+	switch target {
+	case TargetMethod:
+		return []string{req.Method}
+	case TargetURI:
+		return withDecoded(req.URI)
+	case TargetQuery:
+		var values []string
+		for key, items := range req.Query {
+			values = append(values, key)
+			values = append(values, items...)
+		}
+		return values
+	case TargetHeaders:
+		var values []string
+		for key, items := range req.Header {
+			if key == "Authorization" || key == "Cookie" {
+				continue
+			}
+			values = append(values, items...)
+		}
+		return values
+	case TargetUserAgent:
+		return []string{req.Header.Get("User-Agent")}
+	case TargetBody:
+		if len(req.Body) == 0 {
+			return nil
+		}
+		return withDecoded(string(req.Body))
+	default:
+		return nil
+	}
+}
+
+func withDecoded(value string) []string {
+	if decoded, err := url.QueryUnescape(value); err == nil && decoded != value {
+		return []string{value, decoded}
+	}
+	return []string{value}
+}
+
+func matchesAny(pattern *regexp.Regexp, values []string) bool {
+	for _, value := range values {
+		if value != "" && pattern.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// RuleIDs returns the IDs of the matches, e.g. for logging.
+func RuleIDs(matches []Match) string {
+	ids := make([]string, 0, len(matches))
+	for _, match := range matches {
+		ids = append(ids, match.RuleID)
+	}
+	return strings.Join(ids, ",")
+}
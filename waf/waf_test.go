@@ -0,0 +1,66 @@
+package waf
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+)
+
+func TestDefaultRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		request Request
+		want    []string
+	}{
+		{"clean", Request{Method: "GET", URI: "/products?id=42"}, nil},
+		{"path traversal", Request{URI: "/static/../../etc/secret"}, []string{"930100"}},
+		{"encoded path traversal", Request{URI: "/static/%2e%2e/%2e%2e/secret"}, []string{"930100"}},
+		{"path traversal in query", Request{Query: url.Values{"file": {"../config"}}}, []string{"930100"}},
+		{"dots in file names", Request{URI: "/files/archive..tar.gz"}, nil},
+		{"system file", Request{URI: "/download?file=/etc/passwd"}, []string{"930120"}},
+		{"system file in body", Request{Body: []byte(`{"path": "/proc/self/environ"}`)}, []string{"930120"}},
+		{"script tag", Request{Query: url.Values{"q": {"<script>alert(1)</script>"}}}, []string{"941100"}},
+		{"encoded script tag", Request{URI: "/search?q=%3Cscript%3Ealert(1)%3C/script%3E"}, []string{"941100"}},
+		{"script tag in header", Request{Header: http.Header{"Referer": {"<script>"}}}, []string{"941100"}},
+		{"event handler", Request{Body: []byte(`<img src=x onerror=alert(1)>`)}, []string{"941110"}},
+		{"javascript URI", Request{Query: url.Values{"next": {"javascript:alert(1)"}}}, []string{"941110"}},
+		{"iframe", Request{Body: []byte(`<iframe src="https://evil.example">`)}, []string{"941110"}},
+		{"union select", Request{Query: url.Values{"id": {"1 UNION ALL SELECT password FROM users"}}}, []string{"942100"}},
+		{"union select with comment", Request{Query: url.Values{"id": {"1 union/**/select 1"}}}, []string{"942100"}},
+		{"union in text", Request{Body: []byte("the union selected a new board")}, nil},
+		{"tautology", Request{Query: url.Values{"user": {"admin' OR '1'='1"}}}, []string{"942110"}},
+		{"apostrophe in text", Request{Query: url.Values{"author": {"O'Reilly"}}}, nil},
+		{"comment", Request{Query: url.Values{"user": {"admin'--"}}}, []string{"942120"}},
+		{"stacked query", Request{Body: []byte("name=x; DROP TABLE users")}, []string{"942120"}},
+		{"time based", Request{Query: url.Values{"id": {"1 AND SLEEP(5)"}}}, []string{"942130"}},
+		{"scanner", Request{Header: http.Header{"User-Agent": {"sqlmap/1.7"}}}, []string{"913100"}},
+		{"browser", Request{Header: http.Header{"User-Agent": {"Mozilla/5.0 (X11; Linux x86_64)"}}}, nil},
+		{"authorization and cookies are not inspected", Request{Header: http.Header{"Authorization": {"<script>"}, "Cookie": {"a=<script>"}}}, nil},
+		{"several rules", Request{Query: url.Values{"q": {"<script>", "1 union select 1"}}}, []string{"941100", "942100"}},
+	}
+
+	rules := DefaultRules()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, match := range rules.Inspect(&tt.request, nil) {
+				got = append(got, match.RuleID)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("matched %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInspectDisabledRules(t *testing.T) {
+	req := &Request{Query: url.Values{"q": {"<script>", "1 union select 1"}}}
+	matches := DefaultRules().Inspect(req, []string{"941100"})
+	if len(matches) != 1 || matches[0].RuleID != "942100" || matches[0].Target != TargetQuery {
+		t.Errorf("matches = %+v", matches)
+	}
+	if ids := RuleIDs(DefaultRules().Inspect(req, nil)); ids != "941100,942100" {
+		t.Errorf("RuleIDs() = %q", ids)
+	}
+}